@@ -0,0 +1,190 @@
+package hypeman
+
+import (
+	"context"
+	"io"
+	"iter"
+
+	"github.com/kernel/hypeman-go/option"
+	"github.com/kernel/hypeman-go/packages/ssestream"
+)
+
+// The interfaces below have the methods of the client's services, so code that
+// takes them rather than the concrete services can be given a fake in tests.
+// The hypemanmock package has mock implementations of each.
+
+// HealthAPI is the interface of [HealthService].
+type HealthAPI interface {
+	Check(ctx context.Context, opts ...option.RequestOption) (*HealthCheckResponse, error)
+}
+
+// ImagesAPI is the interface of [ImageService].
+type ImagesAPI interface {
+	New(ctx context.Context, body ImageNewParams, opts ...option.RequestOption) (*Image, error)
+	List(ctx context.Context, query ImageListParams, opts ...option.RequestOption) (*[]Image, error)
+	ListIter(ctx context.Context, query ImageListParams, opts ...option.RequestOption) iter.Seq2[Image, error]
+	Delete(ctx context.Context, name string, opts ...option.RequestOption) error
+	Get(ctx context.Context, name string, opts ...option.RequestOption) (*Image, error)
+}
+
+// InstancesAPI is the interface of [InstanceService]. Its nested services are
+// [InstanceAutoStandbyAPI], [InstanceVolumesAPI], [InstanceSnapshotsAPI] and
+// [InstanceSnapshotScheduleAPI].
+type InstancesAPI interface {
+	New(ctx context.Context, body InstanceNewParams, opts ...option.RequestOption) (*Instance, error)
+	Update(ctx context.Context, id string, body InstanceUpdateParams, opts ...option.RequestOption) (*Instance, error)
+	List(ctx context.Context, query InstanceListParams, opts ...option.RequestOption) (*[]Instance, error)
+	ListIter(ctx context.Context, query InstanceListParams, opts ...option.RequestOption) iter.Seq2[Instance, error]
+	Delete(ctx context.Context, id string, opts ...option.RequestOption) error
+	Fork(ctx context.Context, id string, body InstanceForkParams, opts ...option.RequestOption) (*Instance, error)
+	Get(ctx context.Context, id string, opts ...option.RequestOption) (*Instance, error)
+	LogsStreaming(ctx context.Context, id string, query InstanceLogsParams, opts ...option.RequestOption) *ssestream.Stream[string]
+	Restore(ctx context.Context, id string, opts ...option.RequestOption) (*Instance, error)
+	Standby(ctx context.Context, id string, body InstanceStandbyParams, opts ...option.RequestOption) (*Instance, error)
+	Start(ctx context.Context, id string, body InstanceStartParams, opts ...option.RequestOption) (*Instance, error)
+	Stat(ctx context.Context, id string, query InstanceStatParams, opts ...option.RequestOption) (*PathInfo, error)
+	Stats(ctx context.Context, id string, opts ...option.RequestOption) (*InstanceStats, error)
+	Stop(ctx context.Context, id string, opts ...option.RequestOption) (*Instance, error)
+	Wait(ctx context.Context, id string, query InstanceWaitParams, opts ...option.RequestOption) (*WaitForStateResponse, error)
+}
+
+// InstanceAutoStandbyAPI is the interface of [InstanceAutoStandbyService].
+type InstanceAutoStandbyAPI interface {
+	Status(ctx context.Context, id string, opts ...option.RequestOption) (*AutoStandbyStatus, error)
+}
+
+// InstanceVolumesAPI is the interface of [InstanceVolumeService].
+type InstanceVolumesAPI interface {
+	Attach(ctx context.Context, volumeID string, params InstanceVolumeAttachParams, opts ...option.RequestOption) (*Instance, error)
+	Detach(ctx context.Context, volumeID string, body InstanceVolumeDetachParams, opts ...option.RequestOption) (*Instance, error)
+}
+
+// InstanceSnapshotsAPI is the interface of [InstanceSnapshotService].
+type InstanceSnapshotsAPI interface {
+	New(ctx context.Context, id string, body InstanceSnapshotNewParams, opts ...option.RequestOption) (*Snapshot, error)
+	Restore(ctx context.Context, snapshotID string, params InstanceSnapshotRestoreParams, opts ...option.RequestOption) (*Instance, error)
+}
+
+// InstanceSnapshotScheduleAPI is the interface of [InstanceSnapshotScheduleService].
+type InstanceSnapshotScheduleAPI interface {
+	Update(ctx context.Context, id string, body InstanceSnapshotScheduleUpdateParams, opts ...option.RequestOption) (*SnapshotSchedule, error)
+	Delete(ctx context.Context, id string, opts ...option.RequestOption) error
+	Get(ctx context.Context, id string, opts ...option.RequestOption) (*SnapshotSchedule, error)
+}
+
+// SnapshotsAPI is the interface of [SnapshotService].
+type SnapshotsAPI interface {
+	List(ctx context.Context, query SnapshotListParams, opts ...option.RequestOption) (*[]Snapshot, error)
+	ListIter(ctx context.Context, query SnapshotListParams, opts ...option.RequestOption) iter.Seq2[Snapshot, error]
+	Delete(ctx context.Context, snapshotID string, opts ...option.RequestOption) error
+	Fork(ctx context.Context, snapshotID string, body SnapshotForkParams, opts ...option.RequestOption) (*Instance, error)
+	Get(ctx context.Context, snapshotID string, opts ...option.RequestOption) (*Snapshot, error)
+}
+
+// VolumesAPI is the interface of [VolumeService].
+type VolumesAPI interface {
+	New(ctx context.Context, body VolumeNewParams, opts ...option.RequestOption) (*Volume, error)
+	List(ctx context.Context, query VolumeListParams, opts ...option.RequestOption) (*[]Volume, error)
+	ListIter(ctx context.Context, query VolumeListParams, opts ...option.RequestOption) iter.Seq2[Volume, error]
+	Delete(ctx context.Context, id string, opts ...option.RequestOption) error
+	NewFromArchive(ctx context.Context, body io.Reader, params VolumeNewFromArchiveParams, opts ...option.RequestOption) (*Volume, error)
+	Get(ctx context.Context, id string, opts ...option.RequestOption) (*Volume, error)
+}
+
+// DevicesAPI is the interface of [DeviceService].
+type DevicesAPI interface {
+	New(ctx context.Context, body DeviceNewParams, opts ...option.RequestOption) (*Device, error)
+	Get(ctx context.Context, id string, opts ...option.RequestOption) (*Device, error)
+	List(ctx context.Context, query DeviceListParams, opts ...option.RequestOption) (*[]Device, error)
+	ListIter(ctx context.Context, query DeviceListParams, opts ...option.RequestOption) iter.Seq2[Device, error]
+	Delete(ctx context.Context, id string, opts ...option.RequestOption) error
+	ListAvailable(ctx context.Context, opts ...option.RequestOption) (*[]AvailableDevice, error)
+}
+
+// IngressesAPI is the interface of [IngressService].
+type IngressesAPI interface {
+	New(ctx context.Context, body IngressNewParams, opts ...option.RequestOption) (*Ingress, error)
+	List(ctx context.Context, query IngressListParams, opts ...option.RequestOption) (*[]Ingress, error)
+	ListIter(ctx context.Context, query IngressListParams, opts ...option.RequestOption) iter.Seq2[Ingress, error]
+	Delete(ctx context.Context, id string, opts ...option.RequestOption) error
+	Get(ctx context.Context, id string, opts ...option.RequestOption) (*Ingress, error)
+}
+
+// ResourcesAPI is the interface of [ResourceService].
+type ResourcesAPI interface {
+	Get(ctx context.Context, opts ...option.RequestOption) (*Resources, error)
+	ReclaimMemory(ctx context.Context, body ResourceReclaimMemoryParams, opts ...option.RequestOption) (*MemoryReclaimResponse, error)
+}
+
+// BuildsAPI is the interface of [BuildService].
+type BuildsAPI interface {
+	New(ctx context.Context, body BuildNewParams, opts ...option.RequestOption) (*Build, error)
+	List(ctx context.Context, query BuildListParams, opts ...option.RequestOption) (*[]Build, error)
+	ListIter(ctx context.Context, query BuildListParams, opts ...option.RequestOption) iter.Seq2[Build, error]
+	Cancel(ctx context.Context, id string, opts ...option.RequestOption) error
+	EventsStreaming(ctx context.Context, id string, query BuildEventsParams, opts ...option.RequestOption) *ssestream.Stream[BuildEvent]
+	Get(ctx context.Context, id string, opts ...option.RequestOption) (*Build, error)
+}
+
+var (
+	_ HealthAPI                   = (*HealthService)(nil)
+	_ ImagesAPI                   = (*ImageService)(nil)
+	_ InstancesAPI                = (*InstanceService)(nil)
+	_ InstanceAutoStandbyAPI      = (*InstanceAutoStandbyService)(nil)
+	_ InstanceVolumesAPI          = (*InstanceVolumeService)(nil)
+	_ InstanceSnapshotsAPI        = (*InstanceSnapshotService)(nil)
+	_ InstanceSnapshotScheduleAPI = (*InstanceSnapshotScheduleService)(nil)
+	_ SnapshotsAPI                = (*SnapshotService)(nil)
+	_ VolumesAPI                  = (*VolumeService)(nil)
+	_ DevicesAPI                  = (*DeviceService)(nil)
+	_ IngressesAPI                = (*IngressService)(nil)
+	_ ResourcesAPI                = (*ResourceService)(nil)
+	_ BuildsAPI                   = (*BuildService)(nil)
+)
+
+// API is a client made of service interfaces, for code that should work
+// against either a real client or a fake. The services nested under
+// [InstanceService] are fields of their own, so client.Instances.Snapshots
+// is API.InstanceSnapshots.
+type API struct {
+	Health                   HealthAPI
+	Images                   ImagesAPI
+	Instances                InstancesAPI
+	InstanceAutoStandby      InstanceAutoStandbyAPI
+	InstanceVolumes          InstanceVolumesAPI
+	InstanceSnapshots        InstanceSnapshotsAPI
+	InstanceSnapshotSchedule InstanceSnapshotScheduleAPI
+	Snapshots                SnapshotsAPI
+	Volumes                  VolumesAPI
+	Devices                  DevicesAPI
+	Ingresses                IngressesAPI
+	Resources                ResourcesAPI
+	Builds                   BuildsAPI
+}
+
+// NewAPI creates a client as [NewClient] does, and returns its services as
+// an [API].
+func NewAPI(opts ...option.RequestOption) API {
+	client := NewClient(opts...)
+	return client.API()
+}
+
+// API returns r's services as an [API]. The services are shared with r, not
+// copied.
+func (r *Client) API() API {
+	return API{
+		Health:                   &r.Health,
+		Images:                   &r.Images,
+		Instances:                &r.Instances,
+		InstanceAutoStandby:      &r.Instances.AutoStandby,
+		InstanceVolumes:          &r.Instances.Volumes,
+		InstanceSnapshots:        &r.Instances.Snapshots,
+		InstanceSnapshotSchedule: &r.Instances.SnapshotSchedule,
+		Snapshots:                &r.Snapshots,
+		Volumes:                  &r.Volumes,
+		Devices:                  &r.Devices,
+		Ingresses:                &r.Ingresses,
+		Resources:                &r.Resources,
+		Builds:                   &r.Builds,
+	}
+}
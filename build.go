@@ -276,6 +276,10 @@ type BuildNewParams struct {
 	paramObj
 }
 
+func (r BuildNewParams) MarshalMultipartStream() (*apiform.Stream, error) {
+	return apiform.NewStream(r, r.ExtraFields())
+}
+
 func (r BuildNewParams) MarshalMultipart() (data []byte, contentType string, err error) {
 	buf := bytes.NewBuffer(nil)
 	writer := multipart.NewWriter(buf)
@@ -3,17 +3,24 @@
 package hypeman_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/kernel/hypeman-go"
 	"github.com/kernel/hypeman-go/internal"
 	"github.com/kernel/hypeman-go/option"
+	"github.com/kernel/hypeman-go/packages/ssestream"
 )
 
 type closureTransport struct {
@@ -349,3 +356,700 @@ type readerFunc func([]byte) (int, error)
 
 func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
 func (f readerFunc) Close() error               { return nil }
+
+func TestStreamingUploadRetry(t *testing.T) {
+	var bodies []string
+	var progress []int64
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(&http.Client{
+			Transport: &closureTransport{
+				fn: func(req *http.Request) (*http.Response, error) {
+					body, err := io.ReadAll(req.Body)
+					if err != nil {
+						return nil, err
+					}
+					bodies = append(bodies, string(body))
+					status := http.StatusOK
+					if len(bodies) == 1 {
+						status = http.StatusInternalServerError
+					}
+					return &http.Response{
+						StatusCode: status,
+						Header: http.Header{
+							http.CanonicalHeaderKey("Retry-After-Ms"): []string{"1"},
+							http.CanonicalHeaderKey("Content-Type"):   []string{"application/json"},
+						},
+						Body: io.NopCloser(strings.NewReader(`{"id":"build_123","status":"queued"}`)),
+					}, nil
+				},
+			},
+		}),
+		option.WithUploadProgress(func(bytesSent int64) {
+			progress = append(progress, bytesSent)
+		}),
+	)
+	res, err := client.Builds.New(context.Background(), hypeman.BuildNewParams{
+		Source: strings.NewReader("tarball contents"),
+	})
+	if err != nil {
+		t.Fatalf("expected retry to succeed, got %v", err)
+	}
+	if res.ID != "build_123" {
+		t.Errorf("expected build_123, got %s", res.ID)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(bodies))
+	}
+	for i, body := range bodies {
+		if !strings.Contains(body, "tarball contents") {
+			t.Errorf("attempt %d did not contain the full source: %q", i, body)
+		}
+	}
+	if len(progress) == 0 || progress[len(progress)-1] != int64(len(bodies[1])) {
+		t.Errorf("expected final progress of %d bytes, got %v", len(bodies[1]), progress)
+	}
+}
+
+func TestStreamingUploadNotRetriedWithoutSeeker(t *testing.T) {
+	attempts := 0
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(&http.Client{
+			Transport: &closureTransport{
+				fn: func(req *http.Request) (*http.Response, error) {
+					attempts++
+					_, _ = io.ReadAll(req.Body)
+					return &http.Response{
+						StatusCode: http.StatusInternalServerError,
+						Body:       io.NopCloser(strings.NewReader(`{}`)),
+					}, nil
+				},
+			},
+		}),
+	)
+	source := strings.NewReader("tarball contents")
+	_, err := client.Builds.New(context.Background(), hypeman.BuildNewParams{
+		Source: readerFunc(source.Read),
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if attempts != 1 {
+		t.Errorf("expected a non-seekable upload to be attempted once, got %d", attempts)
+	}
+}
+
+// dropAfter returns a body that yields data and then fails as if the
+// connection dropped.
+func dropAfter(data string) io.ReadCloser {
+	return io.NopCloser(io.MultiReader(
+		strings.NewReader(data),
+		readerFunc(func([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }),
+	))
+}
+
+func TestStreamReconnect(t *testing.T) {
+	var lastEventIDs []string
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(&http.Client{
+			Transport: &closureTransport{
+				fn: func(req *http.Request) (*http.Response, error) {
+					lastEventIDs = append(lastEventIDs, req.Header.Get("Last-Event-ID"))
+					body := dropAfter("retry: 10\n\nid: 1\ndata: \"a\"\n\nid: 2\ndata: \"b\"\n\ndata: \"partial")
+					if len(lastEventIDs) > 1 {
+						body = io.NopCloser(strings.NewReader("id: 3\ndata: \"c\"\n\n"))
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     http.Header{http.CanonicalHeaderKey("Content-Type"): []string{"text/event-stream"}},
+						Body:       body,
+					}, nil
+				},
+			},
+		}),
+		option.WithStreamReconnect(ssestream.ReconnectOptions{}),
+	)
+	stream := client.Instances.LogsStreaming(context.Background(), "id", hypeman.InstanceLogsParams{})
+	var lines []string
+	for stream.Next() {
+		lines = append(lines, stream.Current())
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("expected stream to resume, got %v", err)
+	}
+	if !reflect.DeepEqual(lines, []string{"a", "b", "c"}) {
+		t.Errorf("expected lines a, b, c, got %v", lines)
+	}
+	if !reflect.DeepEqual(lastEventIDs, []string{"", "2"}) {
+		t.Errorf("expected reconnect with Last-Event-ID 2, got %v", lastEventIDs)
+	}
+}
+
+func TestStreamReconnectGap(t *testing.T) {
+	attempts := 0
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(&http.Client{
+			Transport: &closureTransport{
+				fn: func(req *http.Request) (*http.Response, error) {
+					attempts++
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     http.Header{http.CanonicalHeaderKey("Content-Type"): []string{"text/event-stream"}},
+						Body:       dropAfter("data: \"a\"\n\n"),
+					}, nil
+				},
+			},
+		}),
+		option.WithStreamReconnect(ssestream.ReconnectOptions{Delay: time.Millisecond}),
+	)
+	stream := client.Instances.LogsStreaming(context.Background(), "id", hypeman.InstanceLogsParams{})
+	for stream.Next() {
+	}
+	var gap *ssestream.GapError
+	if !errors.As(stream.Err(), &gap) {
+		t.Fatalf("expected a gap error, got %v", stream.Err())
+	}
+	if !errors.Is(stream.Err(), io.ErrUnexpectedEOF) {
+		t.Errorf("expected gap error to wrap the drop, got %v", gap.Err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected a stream without event IDs not to be resumed, got %d requests", attempts)
+	}
+}
+
+// closeRecorder records whether a response body was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestListIter(t *testing.T) {
+	body := &closeRecorder{Reader: strings.NewReader(`[{"id":"a","name":"one"},{"id":"b","name":"two"},{"id":"c","name":"three"}]`)}
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(&http.Client{
+			Transport: &closureTransport{
+				fn: func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     http.Header{http.CanonicalHeaderKey("Content-Type"): []string{"application/json"}},
+						Body:       body,
+					}, nil
+				},
+			},
+		}),
+	)
+	var names []string
+	for inst, err := range client.Instances.ListIter(context.Background(), hypeman.InstanceListParams{}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		names = append(names, inst.Name)
+		if len(names) == 2 {
+			break
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"one", "two"}) {
+		t.Errorf("expected the first two instances, got %v", names)
+	}
+	if !body.closed {
+		t.Error("expected the response body to be closed after breaking out early")
+	}
+}
+
+func TestListIterError(t *testing.T) {
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithMaxRetries(0),
+		option.WithHTTPClient(&http.Client{
+			Transport: &closureTransport{
+				fn: func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: http.StatusUnauthorized,
+						Body:       io.NopCloser(strings.NewReader(`{}`)),
+					}, nil
+				},
+			},
+		}),
+	)
+	calls := 0
+	for _, err := range client.Volumes.ListIter(context.Background(), hypeman.VolumeListParams{}) {
+		calls++
+		var apierr *hypeman.Error
+		if !errors.As(err, &apierr) || apierr.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected a 401 API error, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the error to be yielded once, got %d", calls)
+	}
+}
+
+func TestStreamAll(t *testing.T) {
+	body := &closeRecorder{Reader: strings.NewReader("data: \"a\"\n\ndata: \"b\"\n\ndata: \"c\"\n\n")}
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(&http.Client{
+			Transport: &closureTransport{
+				fn: func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     http.Header{http.CanonicalHeaderKey("Content-Type"): []string{"text/event-stream"}},
+						Body:       body,
+					}, nil
+				},
+			},
+		}),
+	)
+	var lines []string
+	for line, err := range client.Instances.LogsStreaming(context.Background(), "id", hypeman.InstanceLogsParams{}).All() {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		lines = append(lines, line)
+		break
+	}
+	if !reflect.DeepEqual(lines, []string{"a"}) {
+		t.Errorf("expected one line, got %v", lines)
+	}
+	if !body.closed {
+		t.Error("expected the stream to be closed after breaking out early")
+	}
+}
+
+func TestAPIErrorSentinels(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		matches []error
+	}{
+		{http.StatusNotFound, `{"code":"not_found","message":"instance not found"}`, []error{hypeman.ErrNotFound}},
+		{http.StatusNotFound, `{}`, []error{hypeman.ErrNotFound}},
+		{http.StatusConflict, `{"code":"invalid_state","message":"instance is not running","details":{"state":"Stopped"}}`, []error{hypeman.ErrConflict, hypeman.ErrInvalidState}},
+		{http.StatusConflict, `{"code":"already_exists","message":"name in use"}`, []error{hypeman.ErrConflict}},
+		{http.StatusServiceUnavailable, `{"code":"insufficient_resources","message":"not enough memory"}`, []error{hypeman.ErrInsufficientResources}},
+		{http.StatusUnauthorized, `{"code":"unauthorized","message":"invalid token"}`, []error{hypeman.ErrUnauthorized}},
+		{http.StatusBadRequest, `{"code":"invalid_request","message":"bad name"}`, nil},
+	}
+	sentinels := []error{hypeman.ErrNotFound, hypeman.ErrConflict, hypeman.ErrInvalidState, hypeman.ErrInsufficientResources, hypeman.ErrUnauthorized}
+	for _, tt := range tests {
+		client := hypeman.NewClient(
+			option.WithAPIKey("My API Key"),
+			option.WithMaxRetries(0),
+			option.WithHTTPClient(&http.Client{
+				Transport: &closureTransport{
+					fn: func(req *http.Request) (*http.Response, error) {
+						return &http.Response{
+							StatusCode: tt.status,
+							Header:     http.Header{"Content-Type": []string{"application/json"}},
+							Body:       io.NopCloser(strings.NewReader(tt.body)),
+						}, nil
+					},
+				},
+			}),
+		)
+		_, err := client.Instances.Get(context.Background(), "id")
+		for _, sentinel := range sentinels {
+			want := false
+			for _, m := range tt.matches {
+				want = want || m == sentinel
+			}
+			if got := errors.Is(err, sentinel); got != want {
+				t.Errorf("%d %s: errors.Is(err, %q) = %v, want %v", tt.status, tt.body, sentinel, got, want)
+			}
+		}
+	}
+
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithMaxRetries(0),
+		option.WithHTTPClient(&http.Client{
+			Transport: &closureTransport{
+				fn: func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: http.StatusConflict,
+						Header:     http.Header{"Content-Type": []string{"application/json"}},
+						Body:       io.NopCloser(strings.NewReader(`{"code":"invalid_state","message":"instance is not running","details":{"state":"Stopped"}}`)),
+					}, nil
+				},
+			},
+		}),
+	)
+	_, err := client.Instances.Stop(context.Background(), "id")
+	var apierr *hypeman.Error
+	if !errors.As(err, &apierr) {
+		t.Fatalf("expected an API error, got %v", err)
+	}
+	if apierr.Code != "invalid_state" || apierr.Message != "instance is not running" {
+		t.Errorf("unexpected code and message: %q %q", apierr.Code, apierr.Message)
+	}
+	if !reflect.DeepEqual(apierr.Details, map[string]any{"state": "Stopped"}) {
+		t.Errorf("unexpected details: %#v", apierr.Details)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	const secret = "sk-test-0123456789"
+	t.Setenv("HYPEMAN_API_KEY", secret)
+	cassette := filepath.Join(t.TempDir(), "testdata", "instances.json")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/instances/inst_1":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"id":"inst_1","name":"web","state":"Running","env":{"TOKEN":%q}}`, secret)
+		case "/instances/inst_1/logs":
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "id: 1\ndata: \"one\"\n\nid: 2\ndata: \"two\"\n\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	run := func(mode option.RecorderMode) (*hypeman.Instance, []string, error) {
+		client := hypeman.NewClient(
+			option.WithBaseURL(server.URL),
+			option.WithAPIKey(secret),
+			option.WithMaxRetries(0),
+			option.WithRecorder(cassette, mode),
+		)
+		inst, err := client.Instances.Get(context.Background(), "inst_1")
+		if err != nil {
+			return nil, nil, err
+		}
+		var lines []string
+		for line, err := range client.Instances.LogsStreaming(context.Background(), "inst_1", hypeman.InstanceLogsParams{Tail: hypeman.Int(10)}).All() {
+			if err != nil {
+				return nil, nil, err
+			}
+			lines = append(lines, line)
+		}
+		return inst, lines, nil
+	}
+
+	inst, lines, err := run(option.RecorderModeAuto)
+	if err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+	if inst.Name != "web" || !reflect.DeepEqual(lines, []string{"one", "two"}) {
+		t.Fatalf("unexpected recorded results %q %v", inst.Name, lines)
+	}
+	server.Close()
+
+	data, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Errorf("expected the API key to be scrubbed from the cassette:\n%s", data)
+	}
+	if !strings.Contains(string(data), "[REDACTED]") {
+		t.Errorf("expected redacted values in the cassette:\n%s", data)
+	}
+
+	inst, lines, err = run(option.RecorderModeAuto)
+	if err != nil {
+		t.Fatalf("replaying: %v", err)
+	}
+	if inst.Name != "web" || inst.Env["TOKEN"] != "[REDACTED]" {
+		t.Errorf("unexpected replayed instance %+v", inst)
+	}
+	if !reflect.DeepEqual(lines, []string{"one", "two"}) {
+		t.Errorf("expected replayed log lines one, two, got %v", lines)
+	}
+
+	client := hypeman.NewClient(
+		option.WithBaseURL(server.URL),
+		option.WithMaxRetries(0),
+		option.WithRecorder(cassette, option.RecorderModeReplay),
+	)
+	_, err = client.Instances.Get(context.Background(), "inst_2")
+	if !errors.Is(err, option.ErrNoRecordedInteraction) {
+		t.Errorf("expected an unrecorded request to fail, got %v", err)
+	}
+	_, err = client.Instances.Get(context.Background(), "inst_1")
+	if err != nil {
+		t.Fatalf("replaying: %v", err)
+	}
+	_, err = client.Instances.Get(context.Background(), "inst_1")
+	if !errors.Is(err, option.ErrNoRecordedInteraction) {
+		t.Errorf("expected each interaction to be replayed once, got %v", err)
+	}
+}
+
+func TestRecorderLargeBody(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "build.json")
+	chunk := bytes.Repeat([]byte("x"), 1<<20)
+	firstChunk := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadFull(r.Body, make([]byte, len(chunk))); err != nil {
+			t.Errorf("reading first chunk: %v", err)
+		}
+		close(firstChunk)
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"build_1"}`)
+	}))
+	defer server.Close()
+
+	post := func(mode option.RecorderMode, last byte, streamed chan struct{}) error {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.Write(chunk)
+			// The rest is only written once the server has the first chunk,
+			// so a body buffered before sending would never be sent
+			if streamed != nil {
+				select {
+				case <-streamed:
+				case <-time.After(5 * time.Second):
+					pw.CloseWithError(errors.New("request body was not streamed"))
+					return
+				}
+			}
+			pw.Write(chunk)
+			pw.Write([]byte{last})
+			pw.Close()
+		}()
+		client := hypeman.NewClient(
+			option.WithBaseURL(server.URL),
+			option.WithAPIKey("My API Key"),
+			option.WithMaxRetries(0),
+			option.WithRecorder(cassette, mode, option.MatchMethod, option.MatchPath, option.MatchBody),
+		)
+		var res map[string]any
+		return client.Post(context.Background(), "builds", nil, &res, option.WithRequestBody("application/octet-stream", pr))
+	}
+
+	if err := post(option.RecorderModeRecord, 'a', firstChunk); err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+	data, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 10<<10 || !strings.Contains(string(data), `"body_size": 2097153`) || !strings.Contains(string(data), `"body_sha256"`) {
+		t.Errorf("expected only the size and digest of the body in the cassette, got %d bytes:\n%.500s", len(data), data)
+	}
+
+	if err := post(option.RecorderModeReplay, 'b', nil); !errors.Is(err, option.ErrNoRecordedInteraction) {
+		t.Errorf("expected a different body not to match, got %v", err)
+	}
+	if err := post(option.RecorderModeReplay, 'a', nil); err != nil {
+		t.Errorf("expected the same body to match, got %v", err)
+	}
+}
+
+func TestRecorderMatchQuery(t *testing.T) {
+	a, _ := http.NewRequest(http.MethodGet, "http://a/instances?tags%5Bteam%5D=x&state=Running", nil)
+	b, _ := http.NewRequest(http.MethodGet, "http://b/instances?state=Running&tags%5Bteam%5D=x", nil)
+	c, _ := http.NewRequest(http.MethodGet, "http://b/instances?state=Stopped", nil)
+	if !option.MatchQuery(a, b) || !option.MatchPath(a, b) {
+		t.Error("expected requests differing in host and query order to match")
+	}
+	if option.MatchQuery(a, c) {
+		t.Error("expected requests with different query values not to match")
+	}
+}
+
+// failingTransport answers every request with status, recording the
+// Idempotency-Key header of each attempt.
+func failingTransport(status int, keys *[]string) *http.Client {
+	return &http.Client{
+		Transport: &closureTransport{
+			fn: func(req *http.Request) (*http.Response, error) {
+				*keys = append(*keys, req.Header.Get("Idempotency-Key"))
+				return &http.Response{
+					StatusCode: status,
+					Header:     http.Header{http.CanonicalHeaderKey("Retry-After-Ms"): []string{"1"}},
+					Body:       io.NopCloser(strings.NewReader("{}")),
+				}, nil
+			},
+		},
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var keys []string
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(failingTransport(http.StatusBadGateway, &keys)),
+	)
+	_, err := client.Instances.New(context.Background(), hypeman.InstanceNewParams{Name: "web", Image: "alpine"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected a POST with an idempotency key to be retried, got %d attempts", len(keys))
+	}
+	if !strings.HasPrefix(keys[0], "hypeman-go-") || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("expected the same generated key on every attempt, got %q", keys)
+	}
+
+	first := keys[0]
+	keys = nil
+	client.Instances.Fork(context.Background(), "id", hypeman.InstanceForkParams{Name: "copy"})
+	if keys[0] == first {
+		t.Errorf("expected a new key for a new request, got %q again", first)
+	}
+
+	keys = nil
+	client.Instances.New(context.Background(), hypeman.InstanceNewParams{Name: "web", Image: "alpine"}, option.WithIdempotencyKey("deploy-42"))
+	if !reflect.DeepEqual(keys, []string{"deploy-42", "deploy-42", "deploy-42"}) {
+		t.Errorf("expected the given key on every attempt, got %q", keys)
+	}
+
+	keys = nil
+	client.Instances.Stop(context.Background(), "id", option.WithAutoIdempotencyKey(false))
+	if len(keys) != 1 || keys[0] != "" {
+		t.Errorf("expected a POST without a key not to be retried after a 502, got %q", keys)
+	}
+}
+
+func TestRetrySafeMethods(t *testing.T) {
+	var keys []string
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(failingTransport(http.StatusTooManyRequests, &keys)),
+		option.WithAutoIdempotencyKey(false),
+	)
+	client.Instances.Stop(context.Background(), "id")
+	if len(keys) != 3 {
+		t.Errorf("expected a POST turned away with 429 to be retried, got %d attempts", len(keys))
+	}
+
+	keys = nil
+	client = hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(failingTransport(http.StatusServiceUnavailable, &keys)),
+	)
+	client.Instances.Get(context.Background(), "id")
+	if len(keys) != 3 {
+		t.Errorf("expected a GET to be retried, got %d attempts", len(keys))
+	}
+	keys = nil
+	client.Instances.New(context.Background(), hypeman.InstanceNewParams{Name: "web", Image: "alpine"})
+	if len(keys) != 3 {
+		t.Errorf("expected a default client to retry a POST after a 503, got %d attempts", len(keys))
+	}
+	keys = nil
+	client.Instances.Update(context.Background(), "id", hypeman.InstanceUpdateParams{})
+	if len(keys) != 3 {
+		t.Errorf("expected a default client to retry a PATCH after a 503, got %d attempts", len(keys))
+	}
+	keys = nil
+	client.Instances.Get(context.Background(), "id", option.WithRetrySafeMethods())
+	if len(keys) != 1 {
+		t.Errorf("expected a GET not to be retried when no method is retry-safe, got %d attempts", len(keys))
+	}
+	keys = nil
+	client.Instances.Stop(context.Background(), "id", option.WithAutoIdempotencyKey(false), option.WithRetrySafeMethods(http.MethodPost))
+	if len(keys) != 3 {
+		t.Errorf("expected a POST to be retried when POST is retry-safe, got %d attempts", len(keys))
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var keys []string
+	var delays []time.Duration
+	var attempts []int
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithMaxRetries(3),
+		option.WithHTTPClient(failingTransport(http.StatusServiceUnavailable, &keys)),
+		option.WithRetryPolicy(option.Backoff{Base: time.Millisecond, Max: 3 * time.Millisecond, Jitter: option.JitterNone, IgnoreRetryAfter: true}),
+		option.WithOnRetry(func(attempt option.RetryAttempt, delay time.Duration) {
+			attempts = append(attempts, attempt.Attempt)
+			delays = append(delays, delay)
+		}),
+	)
+	client.Instances.Get(context.Background(), "id")
+	if len(keys) != 4 {
+		t.Errorf("expected 4 attempts, got %d", len(keys))
+	}
+	if !reflect.DeepEqual(attempts, []int{1, 2, 3}) {
+		t.Errorf("expected OnRetry after attempts 1, 2 and 3, got %v", attempts)
+	}
+	if want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}; !reflect.DeepEqual(delays, want) {
+		t.Errorf("expected delays %v, got %v", want, delays)
+	}
+
+	for _, jitter := range []option.Jitter{option.JitterFull, option.JitterDecorrelated} {
+		delays = nil
+		client.Instances.Get(context.Background(), "id", option.WithRetryPolicy(option.Backoff{Base: time.Millisecond, Max: 4 * time.Millisecond, Jitter: jitter}))
+		for _, d := range delays {
+			if d < 0 || d > 4*time.Millisecond || (jitter == option.JitterDecorrelated && d < time.Millisecond) {
+				t.Errorf("jitter %d: delay %v out of range", jitter, d)
+			}
+		}
+	}
+
+	keys = nil
+	client.Instances.Get(context.Background(), "id", option.WithRetryPolicy(option.Backoff{
+		Rules: []option.RetryRule{option.NoRetryOnStatus(http.StatusServiceUnavailable)},
+	}))
+	if len(keys) != 1 {
+		t.Errorf("expected a rule to stop retries, got %d attempts", len(keys))
+	}
+
+	keys = nil
+	client = hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(failingTransport(http.StatusBadRequest, &keys)),
+		option.WithRetryPolicy(option.Backoff{Rules: []option.RetryRule{option.RetryOnStatus(http.StatusBadRequest)}}),
+	)
+	client.Instances.Get(context.Background(), "id")
+	if len(keys) != 3 {
+		t.Errorf("expected a rule to retry a 400, got %d attempts", len(keys))
+	}
+	keys = nil
+	client.Instances.Stop(context.Background(), "id", option.WithAutoIdempotencyKey(false))
+	if len(keys) != 1 {
+		t.Errorf("expected a policy not to retry an unsafe request, got %d attempts", len(keys))
+	}
+}
+
+func TestRetryPolicyError(t *testing.T) {
+	refused := errors.New("connection refused")
+	attempts := 0
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(&http.Client{
+			Transport: &closureTransport{
+				fn: func(req *http.Request) (*http.Response, error) {
+					attempts++
+					return nil, refused
+				},
+			},
+		}),
+		option.WithRetryPolicy(option.Backoff{Rules: []option.RetryRule{option.RetryOnError(refused, false)}}),
+	)
+	_, err := client.Instances.Get(context.Background(), "id")
+	if !errors.Is(err, refused) {
+		t.Errorf("expected the transport error, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected a rule to stop retrying the error, got %d attempts", attempts)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	var keys []string
+	client := hypeman.NewClient(
+		option.WithAPIKey("My API Key"),
+		option.WithHTTPClient(failingTransport(http.StatusServiceUnavailable, &keys)),
+		option.WithRetryPolicy(option.Backoff{Budget: option.NewRetryBudget(3, 0)}),
+	)
+	client.Instances.Get(context.Background(), "a")
+	client.Instances.Get(context.Background(), "b")
+	client.Instances.Get(context.Background(), "c")
+	if len(keys) != 6 {
+		t.Errorf("expected the budget to allow 3 retries across requests, got %d attempts", len(keys))
+	}
+}
@@ -0,0 +1,28 @@
+package hypeman
+
+import "github.com/kernel/hypeman-go/internal/apierror"
+
+// Errors returned by API calls match these with errors.Is, according to the
+// error code in the response body or the HTTP status code:
+//
+//	inst, err := client.Instances.Get(ctx, id)
+//	if errors.Is(err, hypeman.ErrNotFound) {
+//		// the instance was deleted
+//	}
+//
+// Use errors.As with *hypeman.Error to read the code, message and details.
+var (
+	// ErrNotFound matches errors for resources that do not exist (404, "not_found")
+	ErrNotFound = apierror.ErrNotFound
+	// ErrConflict matches errors for requests that conflict with the current
+	// state of a resource, e.g. a name already in use (409, "conflict", "already_exists")
+	ErrConflict = apierror.ErrConflict
+	// ErrInvalidState matches errors for operations not allowed in a resource's
+	// current state, e.g. stopping a stopped instance ("invalid_state")
+	ErrInvalidState = apierror.ErrInvalidState
+	// ErrInsufficientResources matches errors for requests the host has no
+	// capacity for ("insufficient_resources")
+	ErrInsufficientResources = apierror.ErrInsufficientResources
+	// ErrUnauthorized matches errors for missing or invalid credentials (401, "unauthorized")
+	ErrUnauthorized = apierror.ErrUnauthorized
+)
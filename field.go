@@ -1,6 +1,7 @@
 package hypeman
 
 import (
+	"errors"
 	"github.com/kernel/hypeman-go/packages/param"
 	"io"
 	"time"
@@ -43,3 +44,12 @@ func (f file) Filename() string {
 func (f file) ContentType() string {
 	return f.contentType
 }
+
+// Seek lets file uploads be rewound for retries when the wrapped reader
+// implements [io.Seeker].
+func (f file) Seek(offset int64, whence int) (int64, error) {
+	if seeker, ok := f.Reader.(io.Seeker); ok {
+		return seeker.Seek(offset, whence)
+	}
+	return 0, errors.New("hypeman: file reader does not implement io.Seeker")
+}
@@ -15,8 +15,17 @@ import (
 // made and the API returns a response with a HTTP status code. Other errors are
 // not wrapped by this SDK.
 type Error struct {
+	// Machine-readable error code, e.g. "not_found"
+	Code string `json:"code"`
+	// Human-readable error message
+	Message string `json:"message"`
+	// Additional structured context about the error, if any
+	Details any `json:"details"`
 	// JSON contains metadata for fields, check presence with [respjson.Field.Valid].
 	JSON struct {
+		Code        respjson.Field
+		Message     respjson.Field
+		Details     respjson.Field
 		ExtraFields map[string]respjson.Field
 		raw         string
 	} `json:"-"`
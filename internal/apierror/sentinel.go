@@ -0,0 +1,33 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Sentinel errors matched by *Error with errors.Is, based on the error code in
+// the response body or, where the code is missing, the HTTP status.
+var (
+	ErrNotFound              = errors.New("not found")
+	ErrConflict              = errors.New("conflict")
+	ErrInvalidState          = errors.New("invalid state")
+	ErrInsufficientResources = errors.New("insufficient resources")
+	ErrUnauthorized          = errors.New("unauthorized")
+)
+
+// Is reports whether r is an instance of one of the sentinel errors above.
+func (r *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return r.Code == "not_found" || r.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return r.Code == "conflict" || r.Code == "already_exists" || r.StatusCode == http.StatusConflict
+	case ErrInvalidState:
+		return r.Code == "invalid_state"
+	case ErrInsufficientResources:
+		return r.Code == "insufficient_resources"
+	case ErrUnauthorized:
+		return r.Code == "unauthorized" || r.StatusCode == http.StatusUnauthorized
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package apiform
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+	"reflect"
+	"sync"
+)
+
+// StreamMarshaler is implemented by params whose multipart body should be
+// encoded while the request is being sent instead of being buffered in memory.
+type StreamMarshaler interface {
+	MarshalMultipartStream() (*Stream, error)
+}
+
+// Stream is a multipart/form-data request body that is encoded on the fly
+// through an [io.Pipe] as it is read, so file parts are never held in memory.
+//
+// A Stream can be re-opened for retries only if every [io.Reader] in the
+// encoded value also implements [io.Seeker]; see [Stream.Rewindable].
+type Stream struct {
+	value    any
+	extras   map[string]any
+	boundary string
+	readers  []streamReader
+	seekable bool
+
+	mu     sync.Mutex
+	cur    *io.PipeReader
+	done   chan struct{}
+	opened bool
+}
+
+// streamReader records where a file part started so it can be rewound
+type streamReader struct {
+	r      io.Reader
+	offset int64
+}
+
+// NewStream returns a Stream which encodes value with [MarshalRoot] followed
+// by extras with [WriteExtras]. Nothing is read from value until the Stream is.
+func NewStream(value any, extras map[string]any) (*Stream, error) {
+	s := &Stream{
+		value:    value,
+		extras:   extras,
+		boundary: multipart.NewWriter(io.Discard).Boundary(),
+		seekable: true,
+	}
+	for _, r := range collectReaders(reflect.ValueOf(value)) {
+		entry := streamReader{r: r}
+		if seeker, ok := r.(io.Seeker); ok {
+			offset, err := seeker.Seek(0, io.SeekCurrent)
+			if err != nil {
+				s.seekable = false
+			}
+			entry.offset = offset
+		} else {
+			s.seekable = false
+		}
+		s.readers = append(s.readers, entry)
+	}
+	return s, nil
+}
+
+// ContentType returns the multipart/form-data content type, including the
+// boundary, to send alongside the body.
+func (s *Stream) ContentType() string {
+	return "multipart/form-data; boundary=" + s.boundary
+}
+
+// Rewindable reports whether [Stream.Open] can be called more than once.
+func (s *Stream) Rewindable() bool {
+	return s.seekable
+}
+
+// Open starts a new encoding of the body and returns a reader over it. Any
+// previously opened body is closed first, and file parts are seeked back to
+// where they started. It is suitable for use as [http.Request.GetBody].
+func (s *Stream) Open() (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.open()
+}
+
+func (s *Stream) open() (io.ReadCloser, error) {
+	if s.opened {
+		if !s.seekable {
+			return nil, errors.New("apiform: multipart stream cannot be rewound because a file part is not an io.Seeker")
+		}
+		// Stop the previous encoder before touching the readers it may be using
+		s.cur.CloseWithError(errors.New("apiform: multipart stream reopened"))
+		<-s.done
+		for _, entry := range s.readers {
+			if _, err := entry.r.(io.Seeker).Seek(entry.offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s.opened = true
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writer := multipart.NewWriter(pw)
+		err := writer.SetBoundary(s.boundary)
+		if err == nil {
+			err = MarshalRoot(s.value, writer)
+		}
+		if err == nil {
+			err = WriteExtras(writer, s.extras)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	s.cur = pr
+	s.done = done
+	return pr, nil
+}
+
+// Read reads from the most recently opened body, opening one if necessary.
+func (s *Stream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	if !s.opened {
+		if _, err := s.open(); err != nil {
+			s.mu.Unlock()
+			return 0, err
+		}
+	}
+	cur := s.cur
+	s.mu.Unlock()
+	return cur.Read(p)
+}
+
+var readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+
+// collectReaders finds every io.Reader that the encoder will write as a file part.
+func collectReaders(v reflect.Value) []io.Reader {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Implements(readerType) && v.CanInterface() {
+			return []io.Reader{v.Interface().(io.Reader)}
+		}
+		return collectReaders(v.Elem())
+	case reflect.Struct:
+		var out []io.Reader
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			out = append(out, collectReaders(v.Field(i))...)
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		var out []io.Reader
+		for i := 0; i < v.Len(); i++ {
+			out = append(out, collectReaders(v.Index(i))...)
+		}
+		return out
+	case reflect.Map:
+		var out []io.Reader
+		iter := v.MapRange()
+		for iter.Next() {
+			out = append(out, collectReaders(iter.Value())...)
+		}
+		return out
+	default:
+		return nil
+	}
+}
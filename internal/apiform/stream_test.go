@@ -0,0 +1,101 @@
+package apiform
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+type StreamUpload struct {
+	Name string    `form:"name"`
+	File io.Reader `form:"file"`
+}
+
+// onlyReader hides any io.Seeker implementation of the wrapped reader
+type onlyReader struct{ r io.Reader }
+
+func (o onlyReader) Read(p []byte) (int, error) { return o.r.Read(p) }
+
+func bufferedMultipart(t *testing.T, value any, extras map[string]any, boundary string) string {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	writer := multipart.NewWriter(buf)
+	if err := writer.SetBoundary(boundary); err != nil {
+		t.Fatalf("setting boundary failed with error %v", err)
+	}
+	if err := MarshalRoot(value, writer); err != nil {
+		t.Fatalf("serialization of %v failed with error %v", value, err)
+	}
+	if err := WriteExtras(writer, extras); err != nil {
+		t.Fatalf("serialization of extras failed with error %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("closing writer failed with error %v", err)
+	}
+	return buf.String()
+}
+
+func TestStreamMatchesBufferedEncoding(t *testing.T) {
+	extras := map[string]any{"extra": "value"}
+	stream, err := NewStream(StreamUpload{Name: "ctx", File: strings.NewReader("file contents")}, extras)
+	if err != nil {
+		t.Fatalf("NewStream failed with error %v", err)
+	}
+	body, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("reading stream failed with error %v", err)
+	}
+
+	boundary := strings.TrimPrefix(stream.ContentType(), "multipart/form-data; boundary=")
+	expected := bufferedMultipart(t, StreamUpload{Name: "ctx", File: strings.NewReader("file contents")}, extras, boundary)
+	if string(body) != expected {
+		t.Errorf("expected stream to encode to '%s' but got '%s'", expected, string(body))
+	}
+}
+
+func TestStreamReopenRewindsSeekers(t *testing.T) {
+	stream, err := NewStream(StreamUpload{Name: "ctx", File: strings.NewReader("file contents")}, nil)
+	if err != nil {
+		t.Fatalf("NewStream failed with error %v", err)
+	}
+	if !stream.Rewindable() {
+		t.Fatalf("expected stream over a strings.Reader to be rewindable")
+	}
+
+	first, _ := stream.Open()
+	// Abandon the first attempt part-way through, as a failed request would
+	partial := make([]byte, 10)
+	if _, err := io.ReadFull(first, partial); err != nil {
+		t.Fatalf("reading first attempt failed with error %v", err)
+	}
+
+	second, err := stream.Open()
+	if err != nil {
+		t.Fatalf("reopening stream failed with error %v", err)
+	}
+	body, err := io.ReadAll(second)
+	if err != nil {
+		t.Fatalf("reading second attempt failed with error %v", err)
+	}
+	if !strings.Contains(string(body), "\r\n\r\nfile contents\r\n") {
+		t.Errorf("expected reopened stream to contain the full file, got '%s'", string(body))
+	}
+}
+
+func TestStreamNonSeekableCannotReopen(t *testing.T) {
+	stream, err := NewStream(StreamUpload{File: onlyReader{strings.NewReader("data")}}, nil)
+	if err != nil {
+		t.Fatalf("NewStream failed with error %v", err)
+	}
+	if stream.Rewindable() {
+		t.Fatalf("expected stream over a plain io.Reader not to be rewindable")
+	}
+	if _, err := stream.Open(); err != nil {
+		t.Fatalf("first open failed with error %v", err)
+	}
+	if _, err := stream.Open(); err == nil {
+		t.Errorf("expected second open of a non-seekable stream to fail")
+	}
+}
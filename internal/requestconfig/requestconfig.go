@@ -5,15 +5,15 @@ package requestconfig
 import (
 	"bytes"
 	"context"
+	cryptorand "crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
-	"math"
-	"math/rand"
 	"mime"
 	"net/http"
 	"net/url"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -22,6 +22,7 @@ import (
 	"github.com/kernel/hypeman-go/internal/apierror"
 	"github.com/kernel/hypeman-go/internal/apiform"
 	"github.com/kernel/hypeman-go/internal/apiquery"
+	"github.com/kernel/hypeman-go/packages/ssestream"
 )
 
 func getDefaultHeaders() map[string]string {
@@ -101,7 +102,15 @@ func NewRequestConfig(ctx context.Context, method string, u string, body any, ds
 		reader = bytes.NewBuffer(content)
 		hasSerializationFunc = true
 	}
-	if body, ok := body.(apiform.Marshaler); ok {
+	if body, ok := body.(apiform.StreamMarshaler); ok {
+		stream, err := body.MarshalMultipartStream()
+		if err != nil {
+			return nil, err
+		}
+		reader = stream
+		contentType = stream.ContentType()
+		hasSerializationFunc = true
+	} else if body, ok := body.(apiform.Marshaler); ok {
 		var (
 			content []byte
 			err     error
@@ -227,8 +236,35 @@ type RequestConfig struct {
 	// given address
 	ResponseInto **http.Response
 	Body         io.Reader
+	// UploadProgress, if set, is called with the number of request body bytes
+	// sent so far. The count restarts from zero on each retry attempt.
+	UploadProgress func(bytesSent int64)
+	// StreamReconnect, if set, makes text/event-stream responses resume with
+	// Last-Event-ID when their connection drops.
+	StreamReconnect *ssestream.ReconnectOptions
+	// RetrySafeMethods are the HTTP methods that are retried after failures
+	// the server may have acted on. If nil, DefaultRetrySafeMethods is used.
+	RetrySafeMethods []string
+	// NoAutoIdempotencyKey turns off generating an Idempotency-Key header for
+	// POST requests that do not have one.
+	NoAutoIdempotencyKey bool
+	// RetryPolicy decides which failed attempts are retried and when. If nil,
+	// the zero Backoff is used.
+	RetryPolicy RetryPolicy
+	// OnRetry, if set, is called before each retry with the failed attempt
+	// and the delay before the retry.
+	OnRetry func(attempt RetryAttempt, delay time.Duration)
 }
 
+// IdempotencyKeyHeader is the header that lets the server recognize a retried
+// request it has already acted on.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultRetrySafeMethods are the methods whose requests can be repeated
+// without changing their effect. PATCH is included because the API's PATCH
+// requests set fields to the values given, so repeating one changes nothing.
+var DefaultRetrySafeMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
 // middleware is exactly the same type as the Middleware type found in the [option] package,
 // but it is redeclared here for circular dependency issues.
 type middleware = func(*http.Request, middlewareNext) (*http.Response, error)
@@ -270,6 +306,21 @@ func shouldRetry(req *http.Request, res *http.Response) bool {
 		res.StatusCode >= http.StatusInternalServerError
 }
 
+// retrySafe reports whether retrying the request cannot repeat its effect:
+// its method is retry-safe, it carries an idempotency key, or res shows that
+// the server did not act on it. A failure with no response is assumed to
+// have reached the server.
+func (cfg *RequestConfig) retrySafe(res *http.Response) bool {
+	methods := cfg.RetrySafeMethods
+	if methods == nil {
+		methods = DefaultRetrySafeMethods
+	}
+	if slices.Contains(methods, cfg.Request.Method) || cfg.Request.Header.Get(IdempotencyKeyHeader) != "" {
+		return true
+	}
+	return res != nil && (res.StatusCode == http.StatusTooManyRequests || res.Header.Get("x-should-retry") == "true")
+}
+
 func parseRetryAfterHeader(resp *http.Response) (time.Duration, bool) {
 	if resp == nil {
 		return 0, false
@@ -360,21 +411,32 @@ func (b *bodyWithTimeout) Close() error {
 	return err
 }
 
-func retryDelay(res *http.Response, retryCount int) time.Duration {
-	// If the backend tells us to wait a certain amount of time, use that value
-	if retryAfterDelay, ok := parseRetryAfterHeader(res); ok {
-		return max(0, retryAfterDelay)
-	}
+// progressBody is an io.ReadCloser which reports the number of bytes read so
+// far. It wraps an existing io.ReadCloser.
+type progressBody struct {
+	rc         io.ReadCloser
+	sent       int64
+	onProgress func(int64)
+}
 
-	maxDelay := 8 * time.Second
-	delay := time.Duration(0.5 * float64(time.Second) * math.Pow(2, float64(retryCount)))
-	if delay > maxDelay {
-		delay = maxDelay
+func (b *progressBody) Read(p []byte) (n int, err error) {
+	n, err = b.rc.Read(p)
+	if n > 0 {
+		b.sent += int64(n)
+		b.onProgress(b.sent)
 	}
+	return n, err
+}
+
+func (b *progressBody) Close() error {
+	return b.rc.Close()
+}
 
-	jitter := rand.Int63n(int64(delay / 4))
-	delay -= time.Duration(jitter)
-	return delay
+func readCloser(r io.Reader) io.ReadCloser {
+	if rc, ok := r.(io.ReadCloser); ok {
+		return rc
+	}
+	return io.NopCloser(r)
 }
 
 func (cfg *RequestConfig) Execute() (err error) {
@@ -405,6 +467,28 @@ func (cfg *RequestConfig) Execute() (err error) {
 				return io.NopCloser(body), err
 			}
 			cfg.Request.Body, _ = cfg.Request.GetBody()
+		case *apiform.Stream:
+			// Multipart bodies are encoded while sending; they can only be
+			// replayed for retries when every file part is seekable.
+			if body.Rewindable() {
+				cfg.Request.GetBody = body.Open
+			}
+			cfg.Request.Body, err = body.Open()
+			if err != nil {
+				return err
+			}
+		case io.ReadSeeker:
+			// Rewind seekable bodies (e.g. an *os.File) so they can be retried
+			offset, seekErr := body.Seek(0, io.SeekCurrent)
+			if seekErr != nil {
+				cfg.Request.Body = readCloser(body)
+				break
+			}
+			cfg.Request.GetBody = func() (io.ReadCloser, error) {
+				_, err := body.Seek(offset, io.SeekStart)
+				return io.NopCloser(body), err
+			}
+			cfg.Request.Body, _ = cfg.Request.GetBody()
 		default:
 			if rc, ok := body.(io.ReadCloser); ok {
 				cfg.Request.Body = rc
@@ -422,11 +506,24 @@ func (cfg *RequestConfig) Execute() (err error) {
 		handler = applyMiddleware(cfg.Middlewares[i], handler)
 	}
 
+	// Give POST requests a key so that a retry of one the server acted on,
+	// whose response was lost, is not acted on twice. Every attempt is cloned
+	// from cfg.Request and so sends the same key.
+	if cfg.Request.Method == http.MethodPost && !cfg.NoAutoIdempotencyKey && cfg.Request.Header.Get(IdempotencyKeyHeader) == "" {
+		cfg.Request.Header.Set(IdempotencyKeyHeader, "hypeman-go-"+cryptorand.Text())
+	}
+
 	// Don't send the current retry count in the headers if the caller modified the header defaults.
 	shouldSendRetryCount := cfg.Request.Header.Get("X-Stainless-Retry-Count") == "0"
 
+	policy := cfg.RetryPolicy
+	if policy == nil {
+		policy = Backoff{}
+	}
+
 	var res *http.Response
 	var cancel context.CancelFunc
+	var delay time.Duration
 	for retryCount := 0; retryCount <= cfg.MaxRetries; retryCount += 1 {
 		ctx := cfg.Request.Context()
 		if cfg.RequestTimeout != time.Duration(0) && isBeforeContextDeadline(time.Now().Add(cfg.RequestTimeout), ctx) {
@@ -440,6 +537,9 @@ func (cfg *RequestConfig) Execute() (err error) {
 		}
 
 		req := cfg.Request.Clone(ctx)
+		if cfg.UploadProgress != nil && req.Body != nil {
+			req.Body = &progressBody{rc: req.Body, onProgress: cfg.UploadProgress}
+		}
 		if shouldSendRetryCount {
 			req.Header.Set("X-Stainless-Retry-Count", strconv.Itoa(retryCount))
 		}
@@ -448,9 +548,19 @@ func (cfg *RequestConfig) Execute() (err error) {
 		if ctx != nil && ctx.Err() != nil {
 			return ctx.Err()
 		}
-		if !shouldRetry(cfg.Request, res) || retryCount >= cfg.MaxRetries {
+		// Stop when out of retries, when the body cannot be sent again, or when
+		// a retry could repeat the request's effect, whatever the policy says.
+		if retryCount >= cfg.MaxRetries || (cfg.Request.Body != nil && cfg.Request.GetBody == nil) || !cfg.retrySafe(res) {
 			break
 		}
+		attempt := RetryAttempt{Request: cfg.Request, Response: res, Err: err, Attempt: retryCount + 1, PreviousDelay: delay}
+		var retry bool
+		if delay, retry = policy.Retry(attempt); !retry {
+			break
+		}
+		if cfg.OnRetry != nil {
+			cfg.OnRetry(attempt, delay)
+		}
 
 		// Prepare next request and wait for the retry delay
 		if cfg.Request.GetBody != nil {
@@ -473,7 +583,7 @@ func (cfg *RequestConfig) Execute() (err error) {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(retryDelay(res, retryCount)):
+		case <-time.After(delay):
 		}
 	}
 
@@ -522,6 +632,14 @@ func (cfg *RequestConfig) Execute() (err error) {
 			res.Body = &bodyWithTimeout{rc: res.Body, stop: cancel}
 			cancel = nil
 		}
+		if cfg.StreamReconnect != nil && intoCustomResponseBody && isEventStream(res) {
+			res.Body = &ssestream.ResumableBody{
+				ReadCloser: res.Body,
+				Context:    cfg.Request.Context(),
+				Reopen:     cfg.reopenStream,
+				Options:    *cfg.StreamReconnect,
+			}
+		}
 		return nil
 	}
 
@@ -564,6 +682,30 @@ func (cfg *RequestConfig) Execute() (err error) {
 	return nil
 }
 
+func isEventStream(res *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("content-type"))
+	return mediaType == "text/event-stream"
+}
+
+// reopenStream repeats the request for an event stream, asking the server to
+// resume after lastEventID.
+func (cfg *RequestConfig) reopenStream(lastEventID string) (*http.Response, error) {
+	next := cfg.Clone(cfg.Request.Context())
+	if next == nil {
+		return nil, fmt.Errorf("requestconfig: cannot repeat request for event stream")
+	}
+	next.CustomHTTPDoer = cfg.CustomHTTPDoer
+	if lastEventID != "" {
+		next.Request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	var res *http.Response
+	next.ResponseBodyInto = &res
+	if err := next.Execute(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func ExecuteNewRequest(ctx context.Context, method string, u string, body any, dst any, opts ...RequestOption) error {
 	cfg, err := NewRequestConfig(ctx, method, u, body, dst, opts...)
 	if err != nil {
@@ -585,14 +727,19 @@ func (cfg *RequestConfig) Clone(ctx context.Context) *RequestConfig {
 		return nil
 	}
 	new := &RequestConfig{
-		MaxRetries:     cfg.MaxRetries,
-		RequestTimeout: cfg.RequestTimeout,
-		Context:        ctx,
-		Request:        req,
-		BaseURL:        cfg.BaseURL,
-		HTTPClient:     cfg.HTTPClient,
-		Middlewares:    cfg.Middlewares,
-		APIKey:         cfg.APIKey,
+		MaxRetries:           cfg.MaxRetries,
+		RequestTimeout:       cfg.RequestTimeout,
+		Context:              ctx,
+		Request:              req,
+		BaseURL:              cfg.BaseURL,
+		HTTPClient:           cfg.HTTPClient,
+		Middlewares:          cfg.Middlewares,
+		APIKey:               cfg.APIKey,
+		UploadProgress:       cfg.UploadProgress,
+		RetrySafeMethods:     cfg.RetrySafeMethods,
+		NoAutoIdempotencyKey: cfg.NoAutoIdempotencyKey,
+		RetryPolicy:          cfg.RetryPolicy,
+		OnRetry:              cfg.OnRetry,
 	}
 
 	return new
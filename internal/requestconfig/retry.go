@@ -0,0 +1,182 @@
+package requestconfig
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides whether a failed request attempt is retried, and how
+// long to wait first. It is only asked about attempts that may be retried at
+// all: fewer than MaxRetries retries have been made, the request body can be
+// sent again, and retrying is safe under RetrySafeMethods.
+type RetryPolicy interface {
+	Retry(attempt RetryAttempt) (delay time.Duration, retry bool)
+}
+
+// RetryAttempt describes a failed request attempt.
+type RetryAttempt struct {
+	Request *http.Request
+	// Response is the attempt's response, or nil if it failed with Err
+	Response *http.Response
+	Err      error
+	// Attempt is the number of attempts made so far, 1 after the first
+	Attempt int
+	// PreviousDelay is the delay before this attempt, 0 for the first
+	PreviousDelay time.Duration
+}
+
+// Jitter is how [Backoff] randomizes its delays, so that clients that failed
+// together do not retry together.
+type Jitter int
+
+const (
+	// JitterDefault takes up to a quarter off each delay.
+	JitterDefault Jitter = iota
+	// JitterNone uses the exponential delays as they are.
+	JitterNone
+	// JitterFull picks each delay at random between 0 and the exponential
+	// delay.
+	JitterFull
+	// JitterDecorrelated picks each delay at random between Base and three
+	// times the previous delay, up to Max.
+	JitterDecorrelated
+)
+
+// RetryRule decides whether to retry an attempt, if it applies to it. ok is
+// false if the rule does not apply.
+type RetryRule func(attempt RetryAttempt) (retry, ok bool)
+
+// RetryOnStatus returns a rule that retries responses with any of statuses.
+func RetryOnStatus(statuses ...int) RetryRule {
+	return statusRule(statuses, true)
+}
+
+// NoRetryOnStatus returns a rule that does not retry responses with any of
+// statuses.
+func NoRetryOnStatus(statuses ...int) RetryRule {
+	return statusRule(statuses, false)
+}
+
+func statusRule(statuses []int, retry bool) RetryRule {
+	return func(attempt RetryAttempt) (bool, bool) {
+		if attempt.Response == nil || !slices.Contains(statuses, attempt.Response.StatusCode) {
+			return false, false
+		}
+		return retry, true
+	}
+}
+
+// RetryOnError returns a rule that decides whether to retry attempts that
+// failed with an error matching target with [errors.Is].
+func RetryOnError(target error, retry bool) RetryRule {
+	return func(attempt RetryAttempt) (bool, bool) {
+		if attempt.Err == nil || !errors.Is(attempt.Err, target) {
+			return false, false
+		}
+		return retry, true
+	}
+}
+
+// Backoff is a [RetryPolicy] with exponential backoff. Its zero value is the
+// default policy: it retries connection errors, 408, 409, 429 and 5xx
+// responses after 0.5s, 1s, 2s and so on up to 8s, less up to a quarter, or
+// after the delay the server asks for with Retry-After or Retry-After-Ms.
+type Backoff struct {
+	// Optional: the first delay, 500ms if zero
+	Base time.Duration
+	// Optional: the longest delay, 8s if zero
+	Max time.Duration
+	// Optional: how delays are randomized
+	Jitter Jitter
+	// Optional: rules tried in order before the default decision. The first
+	// that applies decides whether to retry.
+	Rules []RetryRule
+	// Optional: a budget that retries are taken from, shared by every request
+	// that uses it. When it is empty requests are not retried.
+	Budget *RetryBudget
+	// Optional: ignore the delay asked for in Retry-After headers
+	IgnoreRetryAfter bool
+}
+
+// Retry implements [RetryPolicy].
+func (b Backoff) Retry(attempt RetryAttempt) (time.Duration, bool) {
+	if !b.decide(attempt) {
+		return 0, false
+	}
+	if b.Budget != nil && !b.Budget.take() {
+		return 0, false
+	}
+	if !b.IgnoreRetryAfter {
+		if d, ok := parseRetryAfterHeader(attempt.Response); ok {
+			return max(0, d), true
+		}
+	}
+	return b.delay(attempt), true
+}
+
+func (b Backoff) decide(attempt RetryAttempt) bool {
+	for _, rule := range b.Rules {
+		if retry, ok := rule(attempt); ok {
+			return retry
+		}
+	}
+	return shouldRetry(attempt.Request, attempt.Response)
+}
+
+func (b Backoff) delay(attempt RetryAttempt) time.Duration {
+	base, maxDelay := b.Base, b.Max
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = 8 * time.Second
+	}
+	delay := time.Duration(min(float64(base)*math.Pow(2, float64(attempt.Attempt-1)), float64(maxDelay)))
+
+	switch b.Jitter {
+	case JitterNone:
+		return delay
+	case JitterFull:
+		return time.Duration(rand.Int63n(int64(delay) + 1))
+	case JitterDecorrelated:
+		upper := max(3*attempt.PreviousDelay, base)
+		return min(base+time.Duration(rand.Int63n(int64(upper-base)+1)), maxDelay)
+	default:
+		return delay - time.Duration(rand.Int63n(int64(delay/4)+1))
+	}
+}
+
+// RetryBudget is a token bucket that limits how often requests sharing it are
+// retried, so that a server that fails every request, e.g. while restarting,
+// is not met with a retry storm. Each retry takes a token.
+type RetryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	burst  float64
+	rate   float64 // tokens added per second
+	last   time.Time
+}
+
+// NewRetryBudget returns a budget of burst retries, refilled at perSecond
+// retries a second.
+func NewRetryBudget(burst int, perSecond float64) *RetryBudget {
+	return &RetryBudget{tokens: float64(burst), burst: float64(burst), rate: perSecond, last: time.Now()}
+}
+
+func (b *RetryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,155 @@
+// Package lib provides manually-maintained functionality that extends the auto-generated SDK.
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kernel/hypeman-go"
+)
+
+// buildLockfiles are the dependency lockfiles whose hashes the server records in
+// BuildProvenance.LockfileHashes when they are present at the context root
+var buildLockfiles = []string{
+	"package-lock.json",
+	"npm-shrinkwrap.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"bun.lockb",
+	"go.sum",
+	"Cargo.lock",
+	"poetry.lock",
+	"Pipfile.lock",
+	"uv.lock",
+	"requirements.txt",
+	"Gemfile.lock",
+	"composer.lock",
+}
+
+// BuildSource is the content address of a build context, in the same form as
+// the server's BuildProvenance
+type BuildSource struct {
+	SourceHash     string            // SHA256 of the source tarball, hex encoded
+	LockfileHashes map[string]string // Lockfile name to SHA256, hex encoded
+}
+
+// HashBuildDir computes the BuildSource for dir as BuildDir would upload it.
+// BuildDir archives deterministically, so the hash covers exactly the file
+// names, modes and contents that survive .dockerignore, and matches the
+// SourceHash the server records for a build of the same tree. dockerfile is
+// the same path as BuildDirOptions.Dockerfile.
+func HashBuildDir(dir, dockerfile string) (BuildSource, error) {
+	bc, err := openBuildContext(dir, dockerfile)
+	if err != nil {
+		return BuildSource{}, err
+	}
+	return bc.hash()
+}
+
+// hash computes the BuildSource of the build context.
+func (bc *buildContext) hash() (BuildSource, error) {
+	return bc.writeHashed(io.Discard)
+}
+
+// writeHashed archives the build context to w and returns the BuildSource of
+// the archive it wrote.
+func (bc *buildContext) writeHashed(w io.Writer) (BuildSource, error) {
+	h := sha256.New()
+	if err := bc.write(io.MultiWriter(w, h)); err != nil {
+		return BuildSource{}, fmt.Errorf("hash build context: %w", err)
+	}
+	src := BuildSource{
+		SourceHash:     hex.EncodeToString(h.Sum(nil)),
+		LockfileHashes: map[string]string{},
+	}
+
+	for _, name := range buildLockfiles {
+		if bc.ignore.Excluded(name) {
+			continue
+		}
+		sum, err := hashFile(filepath.Join(bc.dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return BuildSource{}, fmt.Errorf("hash %s: %w", name, err)
+		}
+		src.LockfileHashes[name] = sum
+	}
+	return src, nil
+}
+
+// hashFile returns the hex encoded SHA256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FindReusableBuild lists builds carrying tags and returns the most recently
+// completed ready build whose provenance matches src, or nil if there is none.
+// Lockfile hashes are compared for every lockfile both sides recorded.
+//
+// Example:
+//
+//	src, _ := lib.HashBuildDir("./app", "")
+//	build, err := lib.FindReusableBuild(ctx, &client.Builds, map[string]string{"app": "api"}, src)
+//	if build != nil {
+//	    fmt.Println("reusing", build.ImageRef)
+//	}
+func FindReusableBuild(ctx context.Context, builds hypeman.BuildsAPI, tags map[string]string, src BuildSource) (*hypeman.Build, error) {
+	list, err := builds.List(ctx, hypeman.BuildListParams{Tags: tags})
+	if err != nil {
+		return nil, fmt.Errorf("list builds: %w", err)
+	}
+	if list == nil {
+		return nil, nil
+	}
+
+	var best *hypeman.Build
+	for i := range *list {
+		build := &(*list)[i]
+		if build.Status != hypeman.BuildStatusReady || build.ImageRef == "" {
+			continue
+		}
+		if !src.matches(build.Provenance) {
+			continue
+		}
+		if best == nil || build.CompletedAt.After(best.CompletedAt) {
+			best = build
+		}
+	}
+	return best, nil
+}
+
+// matches reports whether a build with provenance p was built from src.
+func (src BuildSource) matches(p hypeman.BuildProvenance) bool {
+	if src.SourceHash == "" || normalizeSHA256(p.SourceHash) != normalizeSHA256(src.SourceHash) {
+		return false
+	}
+	for name, sum := range p.LockfileHashes {
+		if local, ok := src.LockfileHashes[name]; ok && normalizeSHA256(local) != normalizeSHA256(sum) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeSHA256 strips an optional "sha256:" prefix and lowercases the digest
+func normalizeSHA256(sum string) string {
+	return strings.ToLower(strings.TrimPrefix(sum, "sha256:"))
+}
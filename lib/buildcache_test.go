@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHashBuildDir tests that the source hash covers the uploaded archive only
+func TestHashBuildDir(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"Dockerfile":    "FROM scratch\n",
+		".dockerignore": "*.log\n",
+		"go.sum":        "example.com/x v1.0.0 h1:abc\n",
+		"main.go":       "package main\n",
+	})
+
+	src, err := HashBuildDir(dir, "")
+	require.NoError(t, err)
+
+	var archive strings.Builder
+	bc, err := openBuildContext(dir, "")
+	require.NoError(t, err)
+	require.NoError(t, bc.write(&archive))
+	sum := sha256.Sum256([]byte(archive.String()))
+	assert.Equal(t, hex.EncodeToString(sum[:]), src.SourceHash)
+
+	goSum := sha256.Sum256([]byte("example.com/x v1.0.0 h1:abc\n"))
+	assert.Equal(t, map[string]string{"go.sum": hex.EncodeToString(goSum[:])}, src.LockfileHashes)
+
+	// Ignored files do not change the hash
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "debug.log"), []byte("noise"), 0644))
+	again, err := HashBuildDir(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, src.SourceHash, again.SourceHash)
+
+	// Included files do
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+	changed, err := HashBuildDir(dir, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, src.SourceHash, changed.SourceHash)
+}
+
+// TestBuildDir_ReusesMatchingBuild tests that an identical source skips the build
+func TestBuildDir_ReusesMatchingBuild(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"Dockerfile": "FROM scratch\n", "main.go": "package main\n"})
+	src, err := HashBuildDir(dir, "")
+	require.NoError(t, err)
+
+	now := time.Now()
+	builds := newFakeBuilds(&fakeBuilds{
+		list: []hypeman.Build{
+			{ID: "old", Status: hypeman.BuildStatusReady, ImageRef: "reg/app@sha256:old", CompletedAt: now.Add(-time.Hour),
+				Provenance: hypeman.BuildProvenance{SourceHash: src.SourceHash}},
+			{ID: "new", Status: hypeman.BuildStatusReady, ImageRef: "reg/app@sha256:new", CompletedAt: now,
+				Provenance: hypeman.BuildProvenance{SourceHash: "sha256:" + src.SourceHash}},
+			{ID: "failed", Status: hypeman.BuildStatusFailed, CompletedAt: now.Add(time.Hour),
+				Provenance: hypeman.BuildProvenance{SourceHash: src.SourceHash}},
+			{ID: "other", Status: hypeman.BuildStatusReady, ImageRef: "reg/app@sha256:other", CompletedAt: now.Add(time.Hour),
+				Provenance: hypeman.BuildProvenance{SourceHash: "deadbeef"}},
+		},
+	})
+
+	build, err := BuildDir(t.Context(), builds, dir, BuildDirOptions{ReuseTags: map[string]string{"app": "api"}})
+	require.NoError(t, err)
+	assert.Equal(t, "new", build.ID)
+	assert.Nil(t, builds.files, "no build should have been submitted")
+	builds.AssertCalled(t, "List", hypeman.BuildListParams{Tags: map[string]string{"app": "api"}})
+}
+
+// TestBuildDir_TagsNewBuildForReuse tests that a cache miss builds and tags the result
+func TestBuildDir_TagsNewBuildForReuse(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"Dockerfile": "FROM scratch\n"})
+
+	builds := newFakeBuilds(&fakeBuilds{
+		events: []hypeman.BuildEvent{{Type: hypeman.BuildEventTypeStatus, Status: hypeman.BuildStatusReady}},
+		final:  hypeman.Build{ID: "b1", Status: hypeman.BuildStatusReady},
+	})
+
+	build, err := BuildDir(t.Context(), builds, dir, BuildDirOptions{ReuseTags: map[string]string{"app": "api"}})
+	require.NoError(t, err)
+	assert.Equal(t, "b1", build.ID)
+	assert.JSONEq(t, `{"app":"api"}`, builds.tags)
+
+	// The upload is the archive that was hashed
+	src, err := HashBuildDir(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, src.SourceHash, builds.sourceHash)
+	assert.Equal(t, []string{"Dockerfile"}, builds.uploadedNames())
+}
+
+// TestBuildSourceMatches tests lockfile comparison
+func TestBuildSourceMatches(t *testing.T) {
+	src := BuildSource{SourceHash: "AB", LockfileHashes: map[string]string{"go.sum": "11"}}
+	assert.True(t, src.matches(hypeman.BuildProvenance{SourceHash: "ab", LockfileHashes: map[string]string{"go.sum": "11", "yarn.lock": "22"}}))
+	assert.False(t, src.matches(hypeman.BuildProvenance{SourceHash: "ab", LockfileHashes: map[string]string{"go.sum": "99"}}))
+	assert.False(t, src.matches(hypeman.BuildProvenance{}))
+}
@@ -0,0 +1,275 @@
+// Package lib provides manually-maintained functionality that extends the auto-generated SDK.
+package lib
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+)
+
+// BuildDirOptions configures a build from a local directory
+type BuildDirOptions struct {
+	// Optional: Dockerfile path, relative to the build directory unless absolute
+	// (defaults to "Dockerfile")
+	Dockerfile string
+	// Optional: build settings such as CPUs, tags or secrets. Source is always
+	// replaced with the archived directory.
+	Params hypeman.BuildNewParams
+	// Optional: number of trailing log lines kept for BuildError (defaults to 50)
+	LogTailLines int
+	// Optional: called for every event received while following the build
+	OnEvent func(event hypeman.BuildEvent)
+	// Optional: reuse a ready build carrying these tags whose source hash matches
+	// dir instead of starting a new one. Only the source archive is hashed, so the
+	// tags must identify everything else that affects the image, such as a
+	// Dockerfile outside the context or build secrets. New builds are tagged with
+	// them unless Params.Tags is set.
+	ReuseTags map[string]string
+}
+
+// BuildDir archives dir as a gzipped tarball, honoring .dockerignore, submits it
+// as a build and follows it with FollowBuild, so the build is cancelled if ctx
+// ends first and Params.TimeoutSeconds is also enforced client-side, from when
+// the build leaves the queue. The archive is produced while it is uploaded, so
+// the directory is never buffered in memory. It returns the final Build when
+// the build is ready, or a *BuildError carrying the build log tail when it
+// failed or was cancelled.
+//
+// When ReuseTags is set, a ready build with those tags and an identical source
+// hash is returned without building; see FindReusableBuild. The archive is then
+// written to a temporary file while it is hashed, and a new build uploads that
+// file, so the directory is read once and the upload is exactly the archive
+// that was hashed even if files change in the meantime.
+//
+// Example:
+//
+//	build, err := lib.BuildDir(ctx, &client.Builds, "./app", lib.BuildDirOptions{
+//	    Dockerfile: "deploy/Dockerfile",
+//	})
+//	var buildErr *lib.BuildError
+//	if errors.As(err, &buildErr) {
+//	    fmt.Println(strings.Join(buildErr.LogTail, "\n"))
+//	}
+func BuildDir(ctx context.Context, builds hypeman.BuildsAPI, dir string, opts BuildDirOptions) (*hypeman.Build, error) {
+	bc, err := openBuildContext(dir, opts.Dockerfile)
+	if err != nil {
+		return nil, err
+	}
+
+	params := opts.Params
+	if bc.dockerfile.content != "" && !params.Dockerfile.Valid() {
+		params.Dockerfile = hypeman.String(bc.dockerfile.content)
+	}
+
+	var source io.Reader
+	if opts.ReuseTags != nil {
+		archive, err := os.CreateTemp("", "hypeman-build-*.tar.gz")
+		if err != nil {
+			return nil, fmt.Errorf("create build archive: %w", err)
+		}
+		defer os.Remove(archive.Name())
+		defer archive.Close()
+
+		src, err := bc.writeHashed(archive)
+		if err != nil {
+			return nil, err
+		}
+		build, err := FindReusableBuild(ctx, builds, opts.ReuseTags, src)
+		if err != nil {
+			return nil, err
+		}
+		if build != nil {
+			return build, nil
+		}
+		if !params.Tags.Valid() {
+			tags, err := json.Marshal(opts.ReuseTags)
+			if err != nil {
+				return nil, fmt.Errorf("marshal tags: %w", err)
+			}
+			params.Tags = hypeman.String(string(tags))
+		}
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("rewind build archive: %w", err)
+		}
+		source = archive
+	} else {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(bc.write(pw))
+		}()
+		defer pr.Close()
+		source = pr
+	}
+
+	params.Source = hypeman.File(source, "source.tar.gz", "application/gzip")
+	build, err := builds.New(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("submit build: %w", err)
+	}
+
+	return FollowBuild(ctx, builds, build.ID, FollowBuildOptions{
+		Timeout:      time.Duration(params.TimeoutSeconds.Or(0)) * time.Second,
+		LogTailLines: opts.LogTailLines,
+		OnEvent:      opts.OnEvent,
+	})
+}
+
+// buildContext is a local directory prepared for archiving as a build source
+type buildContext struct {
+	dir        string
+	ignore     *dockerIgnore
+	dockerfile buildDockerfile
+}
+
+// openBuildContext validates dir and loads its .dockerignore and Dockerfile.
+func openBuildContext(dir, dockerfile string) (*buildContext, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("stat build directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("build context %s is not a directory", dir)
+	}
+
+	ignore, err := readDockerIgnore(dir)
+	if err != nil {
+		return nil, err
+	}
+	df, err := resolveDockerfile(dir, dockerfile)
+	if err != nil {
+		return nil, err
+	}
+	return &buildContext{dir: dir, ignore: ignore, dockerfile: df}, nil
+}
+
+// write archives the build context to w as a gzipped tarball.
+func (bc *buildContext) write(w io.Writer) error {
+	return writeBuildContext(w, bc.dir, bc.ignore, bc.dockerfile.contextPath)
+}
+
+// buildDockerfile describes where the Dockerfile for a build comes from: either
+// a path inside the archived context, or content sent alongside it.
+type buildDockerfile struct {
+	contextPath string
+	content     string
+}
+
+// resolveDockerfile locates the Dockerfile. The default Dockerfile at the
+// context root travels inside the archive; any other path is sent as content.
+func resolveDockerfile(dir, dockerfile string) (buildDockerfile, error) {
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	path := dockerfile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, dockerfile)
+	}
+
+	if rel, err := filepath.Rel(dir, path); err == nil && filepath.ToSlash(rel) == "Dockerfile" {
+		if _, err := os.Stat(path); err != nil {
+			return buildDockerfile{}, fmt.Errorf("stat Dockerfile: %w", err)
+		}
+		return buildDockerfile{contextPath: "Dockerfile"}, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return buildDockerfile{}, fmt.Errorf("read Dockerfile: %w", err)
+	}
+	return buildDockerfile{content: string(content)}, nil
+}
+
+// writeBuildContext writes dir as a gzipped tarball to w, skipping paths
+// excluded by ignore. keep is always included, matching Docker's handling of
+// the Dockerfile. Ownership and timestamps are normalized so identical
+// directory contents always produce identical archives.
+func writeBuildContext(w io.Writer, dir string, ignore *dockerIgnore, keep string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.WalkDir(dir, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if walkPath == dir {
+			return nil // Skip root
+		}
+
+		rel, err := filepath.Rel(dir, walkPath)
+		if err != nil {
+			return fmt.Errorf("relative path: %w", err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel != keep && rel != ".dockerignore" && ignore.Excluded(rel) {
+			if d.IsDir() && ignore.CanSkipDir(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("info: %w", err)
+		}
+
+		var linkTarget string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			linkTarget, err = os.Readlink(walkPath)
+			if err != nil {
+				return fmt.Errorf("read symlink %s: %w", rel, err)
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, linkTarget)
+		if err != nil {
+			return fmt.Errorf("tar header %s: %w", rel, err)
+		}
+		hdr.Name = rel
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "", ""
+		hdr.ModTime = time.Unix(0, 0)
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		hdr.Format = tar.FormatPAX
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("write tar header %s: %w", rel, err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(walkPath)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", rel, err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("archive %s: %w", rel, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("close tar: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("close gzip: %w", err)
+	}
+	return nil
+}
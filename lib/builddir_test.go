@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib/hypemanmock"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/kernel/hypeman-go/packages/ssestream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBuilds is a mock of the builds service that records uploads and
+// replays scripted events for every build it accepts.
+type fakeBuilds struct {
+	*hypemanmock.Builds
+	events []hypeman.BuildEvent
+	final  hypeman.Build
+	hang   bool // keep the event stream open after the scripted events
+	list   []hypeman.Build
+
+	mu         sync.Mutex
+	files      map[string]string // archive entries from the last upload
+	dockerfile string            // dockerfile field from the last upload
+	tags       string            // tags field from the last upload
+	sourceHash string            // hex SHA256 of the last uploaded archive
+}
+
+func newFakeBuilds(f *fakeBuilds) *fakeBuilds {
+	f.Builds = hypemanmock.New().Builds
+	f.NewFunc = func(ctx context.Context, params hypeman.BuildNewParams, opts ...option.RequestOption) (*hypeman.Build, error) {
+		if err := f.recordUpload(params); err != nil {
+			return nil, err
+		}
+		return &hypeman.Build{ID: f.final.ID, Status: hypeman.BuildStatusQueued}, nil
+	}
+	f.GetFunc = func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Build, error) {
+		final := f.final
+		return &final, nil
+	}
+	f.ListFunc = func(ctx context.Context, params hypeman.BuildListParams, opts ...option.RequestOption) (*[]hypeman.Build, error) {
+		return &f.list, nil
+	}
+	f.EventsStreamingFunc = func(ctx context.Context, id string, params hypeman.BuildEventsParams, opts ...option.RequestOption) *ssestream.Stream[hypeman.BuildEvent] {
+		if !f.hang {
+			return hypemanmock.StreamOf(f.events...)
+		}
+		events := make(chan hypeman.BuildEvent, len(f.events))
+		for _, event := range f.events {
+			events <- event
+		}
+		return hypemanmock.Stream(ctx, events)
+	}
+	f.CancelFunc = func(ctx context.Context, id string, opts ...option.RequestOption) error { return nil }
+	return f
+}
+
+func (f *fakeBuilds) recordUpload(params hypeman.BuildNewParams) error {
+	archive, err := io.ReadAll(params.Source)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(archive)
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		content, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(content)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files = files
+	f.dockerfile = params.Dockerfile.Value
+	f.tags = params.Tags.Value
+	f.sourceHash = hex.EncodeToString(sum[:])
+	return nil
+}
+
+func (f *fakeBuilds) uploadedNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for name := range f.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+// TestBuildDir_Ready tests that a directory is archived with .dockerignore applied
+func TestBuildDir_Ready(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"Dockerfile":          "FROM scratch\n",
+		".dockerignore":       "node_modules\n*.log\nDockerfile\n",
+		"main.go":             "package main\n",
+		"debug.log":           "noise",
+		"node_modules/x/a.js": "ignored",
+		"pkg/util/util.go":    "package util\n",
+	})
+
+	builds := newFakeBuilds(&fakeBuilds{
+		events: []hypeman.BuildEvent{
+			{Type: hypeman.BuildEventTypeStatus, Status: hypeman.BuildStatusBuilding},
+			{Type: hypeman.BuildEventTypeLog, Content: "step 1\n"},
+			{Type: hypeman.BuildEventTypeHeartbeat},
+			{Type: hypeman.BuildEventTypeStatus, Status: hypeman.BuildStatusReady},
+		},
+		final: hypeman.Build{ID: "b1", Status: hypeman.BuildStatusReady, ImageRef: "registry/app@sha256:abc"},
+	})
+
+	var seen []hypeman.BuildEventType
+	build, err := BuildDir(t.Context(), builds, dir, BuildDirOptions{
+		OnEvent: func(e hypeman.BuildEvent) { seen = append(seen, e.Type) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "registry/app@sha256:abc", build.ImageRef)
+	assert.Len(t, seen, 4)
+
+	// The Dockerfile and .dockerignore are always sent, even when ignored
+	assert.Equal(t, []string{".dockerignore", "Dockerfile", "main.go", "pkg/", "pkg/util/", "pkg/util/util.go"}, builds.uploadedNames())
+	assert.Empty(t, builds.dockerfile)
+}
+
+// TestBuildDir_CustomDockerfile tests that a non-default Dockerfile is sent as content
+func TestBuildDir_CustomDockerfile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"deploy/Dockerfile.prod": "FROM alpine\n",
+		"main.go":                "package main\n",
+	})
+
+	builds := newFakeBuilds(&fakeBuilds{
+		events: []hypeman.BuildEvent{{Type: hypeman.BuildEventTypeStatus, Status: hypeman.BuildStatusReady}},
+		final:  hypeman.Build{ID: "b2", Status: hypeman.BuildStatusReady},
+	})
+
+	_, err := BuildDir(t.Context(), builds, dir, BuildDirOptions{Dockerfile: "deploy/Dockerfile.prod"})
+	require.NoError(t, err)
+	assert.Equal(t, "FROM alpine\n", builds.dockerfile)
+}
+
+// TestBuildDir_Failed tests that a failed build returns a BuildError with the log tail
+func TestBuildDir_Failed(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"Dockerfile": "FROM scratch\nRUN false\n"})
+
+	var events []hypeman.BuildEvent
+	for i := 1; i <= 5; i++ {
+		events = append(events, hypeman.BuildEvent{Type: hypeman.BuildEventTypeLog, Content: fmt.Sprintf("line %d\n", i)})
+	}
+	events = append(events, hypeman.BuildEvent{Type: hypeman.BuildEventTypeStatus, Status: hypeman.BuildStatusFailed})
+	builds := newFakeBuilds(&fakeBuilds{
+		events: events,
+		final:  hypeman.Build{ID: "b3", Status: hypeman.BuildStatusFailed, Error: "RUN false exited 1"},
+	})
+
+	build, err := BuildDir(t.Context(), builds, dir, BuildDirOptions{LogTailLines: 2})
+	require.Error(t, err)
+	require.NotNil(t, build)
+
+	var buildErr *BuildError
+	require.True(t, errors.As(err, &buildErr))
+	assert.Equal(t, []string{"line 4", "line 5"}, buildErr.LogTail)
+	assert.True(t, strings.HasPrefix(err.Error(), "build b3 failed: RUN false exited 1"))
+}
+
+// TestWriteBuildContext_Deterministic tests that identical trees produce identical archives
+func TestWriteBuildContext_Deterministic(t *testing.T) {
+	files := map[string]string{"Dockerfile": "FROM scratch\n", "a/b.txt": "b"}
+	dirA, dirB := t.TempDir(), t.TempDir()
+	writeTestFiles(t, dirA, files)
+	writeTestFiles(t, dirB, files)
+
+	var a, b strings.Builder
+	require.NoError(t, writeBuildContext(&a, dirA, &dockerIgnore{}, "Dockerfile"))
+	require.NoError(t, writeBuildContext(&b, dirB, &dockerIgnore{}, "Dockerfile"))
+	assert.Equal(t, a.String(), b.String())
+}
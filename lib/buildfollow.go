@@ -0,0 +1,199 @@
+// Package lib provides manually-maintained functionality that extends the auto-generated SDK.
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+)
+
+const (
+	// defaultBuildLogTailLines is how many log lines a BuildError keeps by default
+	defaultBuildLogTailLines = 50
+	// defaultBuildCancelTimeout bounds the Cancel call made after ctx has ended
+	defaultBuildCancelTimeout = 10 * time.Second
+)
+
+var (
+	// ErrBuildFailed is matched by a BuildError for a build that ended failed
+	ErrBuildFailed = errors.New("build failed")
+	// ErrBuildCancelled is matched by a BuildError for a build that ended cancelled
+	ErrBuildCancelled = errors.New("build cancelled")
+	// ErrBuildTimeout is returned when FollowBuildOptions.Timeout elapses first
+	ErrBuildTimeout = errors.New("build timed out")
+)
+
+// FollowBuildOptions configures how a build is followed
+type FollowBuildOptions struct {
+	// Optional: client-side deadline for the build, measured from when it is
+	// seen running, so that time spent queued does not count. Set it to the
+	// build's TimeoutSeconds to stop waiting on a server that never reports the
+	// timeout. Zero means no deadline beyond ctx.
+	Timeout time.Duration
+	// Optional: bound on the Cancel call made once ctx or Timeout ends (defaults to 10s)
+	CancelTimeout time.Duration
+	// Optional: number of trailing log lines kept for BuildError (defaults to 50)
+	LogTailLines int
+	// Optional: called for every event received while following the build
+	OnEvent func(event hypeman.BuildEvent)
+}
+
+// BuildError is returned when a build finishes in any status other than ready.
+// It matches ErrBuildFailed or ErrBuildCancelled with errors.Is.
+type BuildError struct {
+	Build   *hypeman.Build // Final state of the build
+	LogTail []string       // Last build log lines, oldest first
+}
+
+func (e *BuildError) Error() string {
+	msg := fmt.Sprintf("build %s %s", e.Build.ID, e.Build.Status)
+	if e.Build.Error != "" {
+		msg += ": " + e.Build.Error
+	}
+	if len(e.LogTail) > 0 {
+		msg += "\n" + strings.Join(e.LogTail, "\n")
+	}
+	return msg
+}
+
+// Unwrap returns the sentinel error for the build's final status
+func (e *BuildError) Unwrap() error {
+	switch e.Build.Status {
+	case hypeman.BuildStatusCancelled:
+		return ErrBuildCancelled
+	case hypeman.BuildStatusFailed:
+		return ErrBuildFailed
+	}
+	return nil
+}
+
+// FollowBuild streams events for the build until it reaches a terminal status
+// and returns its final state. A build that does not end ready yields a
+// *BuildError.
+//
+// If ctx ends or opts.Timeout elapses first, the build is cancelled on the
+// server with a short context detached from ctx, so an interrupted caller does
+// not leave it holding builder capacity. The returned error then wraps
+// ctx.Err(), or ErrBuildTimeout for the client-side deadline. opts.Timeout
+// starts once the build is past queued: when it already is as following
+// starts, or else when it reports a new status or its first log line.
+//
+// Example:
+//
+//	build, err := lib.FollowBuild(ctx, &client.Builds, build.ID, lib.FollowBuildOptions{
+//	    Timeout: 10 * time.Minute,
+//	})
+//	switch {
+//	case errors.Is(err, lib.ErrBuildCancelled):
+//	case errors.Is(err, lib.ErrBuildFailed):
+//	}
+func FollowBuild(ctx context.Context, builds hypeman.BuildsAPI, buildID string, opts FollowBuildOptions) (*hypeman.Build, error) {
+	tailLines := opts.LogTailLines
+	if tailLines <= 0 {
+		tailLines = defaultBuildLogTailLines
+	}
+
+	followCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// The deadline starts when the build leaves the queue, like the server's
+	var timer *time.Timer
+	startTimer := func() {
+		if opts.Timeout > 0 && timer == nil {
+			timer = time.AfterFunc(opts.Timeout, func() { cancel(ErrBuildTimeout) })
+		}
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	if opts.Timeout > 0 {
+		build, err := builds.Get(followCtx, buildID)
+		switch {
+		case followCtx.Err() != nil:
+			return nil, abandonBuild(ctx, followCtx, builds, buildID, opts.CancelTimeout)
+		case err != nil || build.Status != hypeman.BuildStatusQueued:
+			// If the status is unknown, count from now rather than not at all
+			startTimer()
+		}
+	}
+
+	var logTail []string
+	stream := builds.EventsStreaming(followCtx, buildID, hypeman.BuildEventsParams{
+		Follow: hypeman.Bool(true),
+	})
+	defer stream.Close()
+
+	for stream.Next() {
+		event := stream.Current()
+		if opts.OnEvent != nil {
+			opts.OnEvent(event)
+		}
+		if event.Type == hypeman.BuildEventTypeLog || (event.Type == hypeman.BuildEventTypeStatus && event.Status != hypeman.BuildStatusQueued) {
+			startTimer()
+		}
+		if event.Type == hypeman.BuildEventTypeLog {
+			logTail = append(logTail, strings.TrimRight(event.Content, "\n"))
+			if len(logTail) > tailLines {
+				logTail = logTail[len(logTail)-tailLines:]
+			}
+		}
+		if event.Type == hypeman.BuildEventTypeStatus && isTerminalBuildStatus(event.Status) {
+			break
+		}
+	}
+	if followCtx.Err() != nil {
+		return nil, abandonBuild(ctx, followCtx, builds, buildID, opts.CancelTimeout)
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("follow build %s: %w", buildID, err)
+	}
+
+	final, err := builds.Get(followCtx, buildID)
+	if err != nil {
+		if followCtx.Err() != nil {
+			return nil, abandonBuild(ctx, followCtx, builds, buildID, opts.CancelTimeout)
+		}
+		return nil, fmt.Errorf("get build %s: %w", buildID, err)
+	}
+	if !isTerminalBuildStatus(final.Status) {
+		return final, fmt.Errorf("build %s event stream ended while build was %s", final.ID, final.Status)
+	}
+	if final.Status != hypeman.BuildStatusReady {
+		return final, &BuildError{Build: final, LogTail: logTail}
+	}
+	return final, nil
+}
+
+// abandonBuild cancels a build the caller stopped waiting for and describes why.
+func abandonBuild(ctx, followCtx context.Context, builds hypeman.BuildsAPI, buildID string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultBuildCancelTimeout
+	}
+	cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	reason := context.Cause(followCtx)
+	if ctx.Err() != nil {
+		reason = ctx.Err()
+	}
+	err := fmt.Errorf("build %s: %w", buildID, reason)
+	if cancelErr := builds.Cancel(cancelCtx, buildID); cancelErr != nil {
+		return errors.Join(err, fmt.Errorf("cancel build %s: %w", buildID, cancelErr))
+	}
+	return err
+}
+
+// isTerminalBuildStatus reports whether a build in status will not change again
+func isTerminalBuildStatus(status hypeman.BuildStatus) bool {
+	switch status {
+	case hypeman.BuildStatusReady, hypeman.BuildStatusFailed, hypeman.BuildStatusCancelled:
+		return true
+	}
+	return false
+}
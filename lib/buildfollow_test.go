@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFollowBuild_TerminalStatusErrors tests that failed and cancelled builds are distinguishable
+func TestFollowBuild_TerminalStatusErrors(t *testing.T) {
+	tests := []struct {
+		status hypeman.BuildStatus
+		target error
+	}{
+		{hypeman.BuildStatusFailed, ErrBuildFailed},
+		{hypeman.BuildStatusCancelled, ErrBuildCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			builds := newFakeBuilds(&fakeBuilds{
+				events: []hypeman.BuildEvent{{Type: hypeman.BuildEventTypeStatus, Status: tt.status}},
+				final:  hypeman.Build{ID: "b1", Status: tt.status},
+			})
+
+			build, err := FollowBuild(t.Context(), builds, "b1", FollowBuildOptions{})
+			require.Error(t, err)
+			assert.Equal(t, tt.status, build.Status)
+			assert.ErrorIs(t, err, tt.target)
+			var buildErr *BuildError
+			assert.ErrorAs(t, err, &buildErr)
+			builds.AssertNotCalled(t, "Cancel")
+		})
+	}
+}
+
+// TestFollowBuild_ContextCancelled tests that the server-side build is cancelled when ctx ends
+func TestFollowBuild_ContextCancelled(t *testing.T) {
+	builds := newFakeBuilds(&fakeBuilds{
+		events: []hypeman.BuildEvent{{Type: hypeman.BuildEventTypeStatus, Status: hypeman.BuildStatusBuilding}},
+		final:  hypeman.Build{ID: "b1", Status: hypeman.BuildStatusBuilding},
+		hang:   true,
+	})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	_, err := FollowBuild(ctx, builds, "b1", FollowBuildOptions{
+		OnEvent: func(hypeman.BuildEvent) { cancel() },
+	})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, errors.Is(err, ErrBuildTimeout))
+	builds.AssertNumberOfCalls(t, "Cancel", 1)
+}
+
+// TestFollowBuild_Timeout tests that the client-side deadline cancels the build
+func TestFollowBuild_Timeout(t *testing.T) {
+	builds := newFakeBuilds(&fakeBuilds{
+		final: hypeman.Build{ID: "b1", Status: hypeman.BuildStatusBuilding},
+		hang:  true,
+	})
+
+	_, err := FollowBuild(t.Context(), builds, "b1", FollowBuildOptions{Timeout: 50 * time.Millisecond})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrBuildTimeout)
+	builds.AssertNumberOfCalls(t, "Cancel", 1)
+}
+
+// TestFollowBuild_TimeoutQueued tests that time spent queued does not count
+// against the client-side deadline
+func TestFollowBuild_TimeoutQueued(t *testing.T) {
+	builds := newFakeBuilds(&fakeBuilds{
+		final: hypeman.Build{ID: "b1", Status: hypeman.BuildStatusQueued},
+		hang:  true,
+	})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	defer cancel()
+	_, err := FollowBuild(ctx, builds, "b1", FollowBuildOptions{Timeout: 20 * time.Millisecond})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, errors.Is(err, ErrBuildTimeout), "a queued build does not time out")
+
+	builds = newFakeBuilds(&fakeBuilds{
+		events: []hypeman.BuildEvent{{Type: hypeman.BuildEventTypeStatus, Status: hypeman.BuildStatusBuilding}},
+		final:  hypeman.Build{ID: "b1", Status: hypeman.BuildStatusQueued},
+		hang:   true,
+	})
+	_, err = FollowBuild(t.Context(), builds, "b1", FollowBuildOptions{Timeout: 20 * time.Millisecond})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrBuildTimeout, "the deadline starts once the build is building")
+	builds.AssertNumberOfCalls(t, "Cancel", 1)
+}
@@ -7,8 +7,6 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"net/http"
-	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -141,30 +139,10 @@ func CpToInstance(ctx context.Context, cfg CpConfig, opts CpToInstanceOptions) e
 
 // cpToInstanceInternal is the internal implementation that accepts visitedDirs for cycle detection
 func cpToInstanceInternal(ctx context.Context, cfg CpConfig, opts CpToInstanceOptions, visitedDirs map[string]bool) error {
-	// Build WebSocket URL
-	wsURL, err := buildWsURL(cfg.BaseURL, opts.InstanceID)
-	if err != nil {
-		return fmt.Errorf("build ws url: %w", err)
-	}
-
 	// Connect to WebSocket
-	headers := http.Header{}
-	headers.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.APIKey))
-
-	// Use provided dialer or default
-	dialer := opts.Dialer
-	if dialer == nil {
-		dialer = &DefaultDialer{}
-	}
-
-	ws, resp, err := dialer.DialContext(ctx, wsURL, headers)
+	ws, err := dialInstance(ctx, cfg, opts.Dialer, opts.InstanceID, "cp")
 	if err != nil {
-		if resp != nil {
-			defer resp.Body.Close()
-			body, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("websocket connect failed (HTTP %d): %s", resp.StatusCode, string(body))
-		}
-		return fmt.Errorf("websocket connect failed: %w", err)
+		return err
 	}
 	defer ws.Close()
 
@@ -416,30 +394,10 @@ func copyDirToWs(ctx context.Context, cfg CpConfig, ws WsConn, srcPath, dstPath,
 //	    DstPath:    "./local-output.txt",
 //	})
 func CpFromInstance(ctx context.Context, cfg CpConfig, opts CpFromInstanceOptions) error {
-	// Build WebSocket URL
-	wsURL, err := buildWsURL(cfg.BaseURL, opts.InstanceID)
-	if err != nil {
-		return fmt.Errorf("build ws url: %w", err)
-	}
-
 	// Connect to WebSocket
-	headers := http.Header{}
-	headers.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.APIKey))
-
-	// Use provided dialer or default
-	dialer := opts.Dialer
-	if dialer == nil {
-		dialer = &DefaultDialer{}
-	}
-
-	ws, resp, err := dialer.DialContext(ctx, wsURL, headers)
+	ws, err := dialInstance(ctx, cfg, opts.Dialer, opts.InstanceID, "cp")
 	if err != nil {
-		if resp != nil {
-			defer resp.Body.Close()
-			body, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("websocket connect failed (HTTP %d): %s", resp.StatusCode, string(body))
-		}
-		return fmt.Errorf("websocket connect failed: %w", err)
+		return err
 	}
 	defer ws.Close()
 
@@ -652,33 +610,10 @@ func sanitizePath(base, path string) (string, error) {
 
 // buildWsURL builds the WebSocket URL for the cp endpoint
 func buildWsURL(baseURL, instanceID string) (string, error) {
-	// Validate instanceID to prevent path traversal attacks
-	if instanceID == "" {
-		return "", fmt.Errorf("instance ID cannot be empty")
-	}
-	if strings.Contains(instanceID, "/") || strings.Contains(instanceID, "\\") || strings.Contains(instanceID, "..") {
-		return "", fmt.Errorf("invalid instance ID: contains path separator or traversal sequence")
-	}
-
-	u, err := url.Parse(baseURL)
-	if err != nil {
-		return "", fmt.Errorf("invalid base URL: %w", err)
-	}
-
-	// Append to existing path (preserves any path prefix like /api)
-	// Use path.Join to handle trailing slashes and ensure clean paths
-	u.Path = path.Join(u.Path, "instances", instanceID, "cp")
-
-	switch u.Scheme {
-	case "https":
-		u.Scheme = "wss"
-	case "http":
-		u.Scheme = "ws"
-	}
-
-	return u.String(), nil
+	return buildInstanceWsURL(baseURL, instanceID, "cp")
 }
 
+
 // CpToInstanceFromURL is a convenience function that uses base URL and API key directly.
 func CpToInstanceFromURL(ctx context.Context, baseURL, apiKey string, opts CpToInstanceOptions) error {
 	cfg := CpConfig{
@@ -48,7 +48,7 @@ func (m *MockWsConn) Close() error {
 
 // MockWsDialer implements WsDialer for testing
 type MockWsDialer struct {
-	conn     *MockWsConn
+	conn     WsConn
 	dialErr  error
 	dialResp *http.Response
 }
@@ -0,0 +1,151 @@
+// Package lib provides manually-maintained functionality that extends the auto-generated SDK.
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignorePattern is a single compiled .dockerignore rule
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negated bool
+}
+
+// dockerIgnore matches context-relative paths against .dockerignore rules
+// using the same semantics as Docker: later rules win, "!" re-includes, and a
+// rule matching a directory also matches everything below it.
+type dockerIgnore struct {
+	patterns    []ignorePattern
+	hasNegation bool
+}
+
+// readDockerIgnore loads <dir>/.dockerignore. A missing file ignores nothing.
+func readDockerIgnore(dir string) (*dockerIgnore, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return &dockerIgnore{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open .dockerignore: %w", err)
+	}
+	defer f.Close()
+	return parseDockerIgnore(f)
+}
+
+// parseDockerIgnore compiles the rules in r.
+func parseDockerIgnore(r io.Reader) (*dockerIgnore, error) {
+	di := &dockerIgnore{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		negated := false
+		if strings.HasPrefix(line, "!") {
+			negated = true
+			line = strings.TrimSpace(line[1:])
+		}
+
+		// Patterns are always relative to the context root
+		line = path.Clean(filepath.ToSlash(line))
+		line = strings.TrimPrefix(line, "/")
+		if line == "" || line == "." {
+			continue
+		}
+
+		re, err := compileIgnorePattern(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore pattern %q: %w", line, err)
+		}
+		di.patterns = append(di.patterns, ignorePattern{re: re, negated: negated})
+		di.hasNegation = di.hasNegation || negated
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read .dockerignore: %w", err)
+	}
+	return di, nil
+}
+
+// compileIgnorePattern translates a Go filepath.Match pattern extended with
+// "**" (any number of directories) into an anchored regular expression.
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// "**/" matches zero or more leading directories
+					i++
+					sb.WriteString("(.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "^") || strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// Excluded reports whether the slash-separated, context-relative path rel
+// should be left out of the build context.
+func (d *dockerIgnore) Excluded(rel string) bool {
+	excluded := false
+	for _, p := range d.patterns {
+		if p.matches(rel) {
+			excluded = !p.negated
+		}
+	}
+	return excluded
+}
+
+// matches reports whether the pattern matches rel or any of its parent directories
+func (p ignorePattern) matches(rel string) bool {
+	for candidate := rel; candidate != "." && candidate != "/" && candidate != ""; candidate = path.Dir(candidate) {
+		if p.re.MatchString(candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanSkipDir reports whether an excluded directory can be skipped entirely,
+// which is only safe when no "!" rule could re-include something below it.
+func (d *dockerIgnore) CanSkipDir(rel string) bool {
+	return !d.hasNegation && d.Excluded(rel)
+}
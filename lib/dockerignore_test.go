@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDockerIgnore tests .dockerignore matching semantics
+func TestDockerIgnore(t *testing.T) {
+	rules := strings.Join([]string{
+		"# comment",
+		"",
+		"node_modules",
+		"*.log",
+		"**/*.tmp",
+		"/build",
+		"docs/*",
+		"!docs/README.md",
+		"secret?.txt",
+	}, "\n")
+	di, err := parseDockerIgnore(strings.NewReader(rules))
+	require.NoError(t, err)
+
+	tests := []struct {
+		path     string
+		excluded bool
+	}{
+		{"main.go", false},
+		{"node_modules", true},
+		{"node_modules/pkg/index.js", true},
+		{"app.log", true},
+		{"sub/app.log", false},
+		{"a.tmp", true},
+		{"deep/nested/a.tmp", true},
+		{"build", true},
+		{"build/out.bin", true},
+		{"src/build", false},
+		{"docs/guide.md", true},
+		{"docs/README.md", false},
+		{"docs", false},
+		{"secret1.txt", true},
+		{"secret10.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.excluded, di.Excluded(tt.path))
+		})
+	}
+}
+
+// TestDockerIgnore_CanSkipDir tests that negation rules prevent skipping directories
+func TestDockerIgnore_CanSkipDir(t *testing.T) {
+	di, err := parseDockerIgnore(strings.NewReader("vendor\n"))
+	require.NoError(t, err)
+	assert.True(t, di.CanSkipDir("vendor"))
+
+	di, err = parseDockerIgnore(strings.NewReader("vendor\n!vendor/keep.go\n"))
+	require.NoError(t, err)
+	assert.False(t, di.CanSkipDir("vendor"))
+	assert.True(t, di.Excluded("vendor/other.go"))
+	assert.False(t, di.Excluded("vendor/keep.go"))
+}
@@ -0,0 +1,275 @@
+// Package lib provides manually-maintained functionality that extends the auto-generated SDK.
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Stream identifiers prefixed to binary output frames sent by the exec endpoint
+const (
+	execStreamStdout byte = 1
+	execStreamStderr byte = 2
+)
+
+// ExecOptions configures a command execution inside an instance
+type ExecOptions struct {
+	InstanceID string            // Instance ID to run the command in
+	Command    []string          // Command and arguments (argv)
+	Env        map[string]string // Optional: additional environment variables
+	WorkingDir string            // Optional: working directory in guest
+	Stdin      io.Reader         // Optional: streamed to the command's stdin until EOF or exit
+	Stdout     io.Writer         // Optional: receives the command's stdout
+	Stderr     io.Writer         // Optional: receives the command's stderr
+	Dialer     WsDialer          // Optional: custom WebSocket dialer (for testing)
+}
+
+// execRequest is the JSON request sent over WebSocket to start a command
+type execRequest struct {
+	Command []string          `json:"command"`
+	Env     map[string]string `json:"env,omitempty"`
+	Cwd     string            `json:"cwd,omitempty"`
+	TTY     bool              `json:"tty,omitempty"`
+	Rows    uint16            `json:"rows,omitempty"`
+	Cols    uint16            `json:"cols,omitempty"`
+}
+
+// execControl is a control frame sent to the server while a command runs
+type execControl struct {
+	Type   string `json:"type"`
+	Rows   uint16 `json:"rows,omitempty"`
+	Cols   uint16 `json:"cols,omitempty"`
+	Signal string `json:"signal,omitempty"`
+}
+
+// execMessage is a text frame received from the server (exit status or error)
+type execMessage struct {
+	Type     string `json:"type"`
+	ExitCode int    `json:"exit_code"`
+	Message  string `json:"message,omitempty"`
+}
+
+// Exec runs a command inside a running instance via the guest agent and
+// returns its exit code. Stdout and stderr are streamed to the given writers
+// as output arrives. The instance must not have been created with
+// SkipGuestAgent.
+//
+// Stdin is read until it returns EOF or the command exits. Exec does not close
+// Stdin, so a Read that is blocked when the command exits is left to finish
+// in the background and what it returns is discarded. Close Stdin, e.g. the
+// write end of a pipe, to release it.
+//
+// Example:
+//
+//	cfg, _ := lib.ExtractCpConfig(client.Options)
+//	code, err := lib.Exec(ctx, cfg, lib.ExecOptions{
+//	    InstanceID: "inst_123",
+//	    Command:    []string{"ls", "-la", "/app"},
+//	    Stdout:     os.Stdout,
+//	    Stderr:     os.Stderr,
+//	})
+func Exec(ctx context.Context, cfg CpConfig, opts ExecOptions) (int, error) {
+	if len(opts.Command) == 0 {
+		return -1, fmt.Errorf("command cannot be empty")
+	}
+
+	ws, err := dialInstance(ctx, cfg, opts.Dialer, opts.InstanceID, "exec")
+	if err != nil {
+		return -1, err
+	}
+	conn := &syncWsConn{conn: ws}
+	defer conn.Close()
+
+	req := execRequest{
+		Command: opts.Command,
+		Env:     opts.Env,
+		Cwd:     opts.WorkingDir,
+	}
+	if err := conn.writeJSON(req); err != nil {
+		return -1, fmt.Errorf("send request: %w", err)
+	}
+
+	if opts.Stdin != nil {
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			pumpExecStdin(conn, readChunks(opts.Stdin, done), done, false)
+		}()
+		defer func() {
+			// Closing the connection unblocks a write in progress
+			close(done)
+			conn.Close()
+			<-stopped
+		}()
+	} else if err := conn.writeJSON(execControl{Type: "stdin_eof"}); err != nil {
+		return -1, fmt.Errorf("send stdin eof: %w", err)
+	}
+
+	return readExecOutput(ctx, conn, opts.Stdout, opts.Stderr)
+}
+
+// inputChunk is the result of one Read of a session's input
+type inputChunk struct {
+	data []byte
+	err  error
+}
+
+// readChunks reads r from a goroutine of its own and sends what it reads on
+// the returned channel, which is closed after the first error. Once done is
+// closed the goroutine exits as soon as its Read in progress returns.
+func readChunks(r io.Reader, done <-chan struct{}) <-chan inputChunk {
+	chunks := make(chan inputChunk)
+	go func() {
+		defer close(chunks)
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := r.Read(buf)
+			select {
+			case chunks <- inputChunk{data: buf[:n], err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return chunks
+}
+
+// pumpExecStdin copies input to the WebSocket as binary frames and signals EOF
+// with a stdin_eof control frame. With interrupts set, each Ctrl-C in input is
+// sent as a SIGINT signal frame instead. It returns when input ends or done is
+// closed. Write errors are left for the reader to surface.
+func pumpExecStdin(ws *syncWsConn, input <-chan inputChunk, done <-chan struct{}, interrupts bool) {
+	for {
+		select {
+		case <-done:
+			return
+		case chunk, ok := <-input:
+			if err := writeExecStdin(ws, chunk.data, interrupts); err != nil {
+				return
+			}
+			if !ok || chunk.err != nil {
+				ws.writeJSON(execControl{Type: "stdin_eof"})
+				return
+			}
+		}
+	}
+}
+
+// writeExecStdin sends data as a binary frame, or as several split around
+// signal frames for its Ctrl-C bytes if interrupts is set.
+func writeExecStdin(ws *syncWsConn, data []byte, interrupts bool) error {
+	for len(data) > 0 {
+		i := -1
+		if interrupts {
+			i = bytes.IndexByte(data, ctrlC)
+		}
+		input := data
+		if i >= 0 {
+			input = data[:i]
+		}
+		if len(input) > 0 {
+			if err := ws.WriteMessage(websocket.BinaryMessage, input); err != nil {
+				return err
+			}
+		}
+		if i < 0 {
+			return nil
+		}
+		if err := ws.writeJSON(execControl{Type: "signal", Signal: "SIGINT"}); err != nil {
+			return err
+		}
+		data = data[i+1:]
+	}
+	return nil
+}
+
+// readExecOutput demultiplexes output frames until the server reports the exit
+// status. Cancelling ctx closes the connection and returns ctx.Err().
+func readExecOutput(ctx context.Context, ws *syncWsConn, stdout, stderr io.Writer) (int, error) {
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	for {
+		msgType, message, err := ws.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return -1, ctx.Err()
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return -1, fmt.Errorf("exec stream ended without exit status")
+			}
+			return -1, fmt.Errorf("read message: %w", err)
+		}
+
+		switch msgType {
+		case websocket.BinaryMessage:
+			if len(message) == 0 {
+				continue
+			}
+			var dst io.Writer
+			switch message[0] {
+			case execStreamStdout:
+				dst = stdout
+			case execStreamStderr:
+				dst = stderr
+			}
+			if dst == nil {
+				continue
+			}
+			if _, err := dst.Write(message[1:]); err != nil {
+				return -1, fmt.Errorf("write output: %w", err)
+			}
+
+		case websocket.TextMessage:
+			var msg execMessage
+			if err := json.Unmarshal(message, &msg); err != nil {
+				return -1, fmt.Errorf("parse message: %w", err)
+			}
+			switch msg.Type {
+			case "exit":
+				return msg.ExitCode, nil
+			case "error":
+				return -1, fmt.Errorf("exec failed: %s", msg.Message)
+			}
+		}
+	}
+}
+
+// syncWsConn serializes writes so stdin, resize and signal frames can be sent
+// from different goroutines while another goroutine reads.
+type syncWsConn struct {
+	conn WsConn
+	mu   sync.Mutex
+}
+
+func (c *syncWsConn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(messageType, data)
+}
+
+func (c *syncWsConn) ReadMessage() (int, []byte, error) {
+	return c.conn.ReadMessage()
+}
+
+func (c *syncWsConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *syncWsConn) writeJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func execOutputFrame(stream byte, data string) wsMessage {
+	return wsMessage{Type: websocket.BinaryMessage, Data: append([]byte{stream}, data...)}
+}
+
+// TestExec_StreamsOutputAndExitCode tests stdout/stderr demultiplexing and exit status
+func TestExec_StreamsOutputAndExitCode(t *testing.T) {
+	exitMsg, _ := json.Marshal(execMessage{Type: "exit", ExitCode: 3})
+	mockConn := &MockWsConn{
+		readQueue: []wsMessage{
+			execOutputFrame(execStreamStdout, "hello "),
+			execOutputFrame(execStreamStderr, "oops"),
+			execOutputFrame(execStreamStdout, "world"),
+			{Type: websocket.TextMessage, Data: exitMsg},
+		},
+	}
+
+	var stdout, stderr bytes.Buffer
+	code, err := Exec(context.Background(), CpConfig{
+		BaseURL: "http://localhost:8080",
+		APIKey:  "test-key",
+	}, ExecOptions{
+		InstanceID: "inst_123",
+		Command:    []string{"sh", "-c", "echo hello world"},
+		Env:        map[string]string{"FOO": "bar"},
+		WorkingDir: "/app",
+		Stdout:     &stdout,
+		Stderr:     &stderr,
+		Dialer:     &MockWsDialer{conn: mockConn},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "hello world", stdout.String())
+	assert.Equal(t, "oops", stderr.String())
+	assert.True(t, mockConn.closed)
+
+	// First message should be the JSON request, followed by stdin EOF
+	require.Len(t, mockConn.writtenMessages, 2)
+	var req execRequest
+	require.NoError(t, json.Unmarshal(mockConn.writtenMessages[0].Data, &req))
+	assert.Equal(t, []string{"sh", "-c", "echo hello world"}, req.Command)
+	assert.Equal(t, "bar", req.Env["FOO"])
+	assert.Equal(t, "/app", req.Cwd)
+	assert.False(t, req.TTY)
+
+	var ctrl execControl
+	require.NoError(t, json.Unmarshal(mockConn.writtenMessages[1].Data, &ctrl))
+	assert.Equal(t, "stdin_eof", ctrl.Type)
+}
+
+// TestExec_ServerError tests that an error frame is surfaced
+func TestExec_ServerError(t *testing.T) {
+	errMsg, _ := json.Marshal(execMessage{Type: "error", Message: "guest agent not available"})
+	mockConn := &MockWsConn{
+		readQueue: []wsMessage{
+			{Type: websocket.TextMessage, Data: errMsg},
+		},
+	}
+
+	_, err := Exec(context.Background(), CpConfig{BaseURL: "http://localhost:8080"}, ExecOptions{
+		InstanceID: "inst_123",
+		Command:    []string{"true"},
+		Dialer:     &MockWsDialer{conn: mockConn},
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "guest agent not available")
+}
+
+// TestExec_MissingExitStatus tests that a closed stream without an exit frame is an error
+func TestExec_MissingExitStatus(t *testing.T) {
+	mockConn := &MockWsConn{}
+
+	_, err := Exec(context.Background(), CpConfig{BaseURL: "http://localhost:8080"}, ExecOptions{
+		InstanceID: "inst_123",
+		Command:    []string{"true"},
+		Dialer:     &MockWsDialer{conn: mockConn},
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "without exit status")
+}
+
+// TestExec_EmptyCommand tests that an empty argv is rejected before dialing
+func TestExec_EmptyCommand(t *testing.T) {
+	_, err := Exec(context.Background(), CpConfig{BaseURL: "http://localhost:8080"}, ExecOptions{
+		InstanceID: "inst_123",
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "command cannot be empty")
+}
+
+// TestPumpExecStdin tests that stdin is sent as binary frames followed by EOF
+func TestPumpExecStdin(t *testing.T) {
+	mockConn := &MockWsConn{}
+
+	done := make(chan struct{})
+	defer close(done)
+	pumpExecStdin(&syncWsConn{conn: mockConn}, readChunks(strings.NewReader("input data"), done), done, false)
+
+	require.Len(t, mockConn.writtenMessages, 2)
+	assert.Equal(t, websocket.BinaryMessage, mockConn.writtenMessages[0].Type)
+	assert.Equal(t, "input data", string(mockConn.writtenMessages[0].Data))
+
+	var ctrl execControl
+	require.NoError(t, json.Unmarshal(mockConn.writtenMessages[1].Data, &ctrl))
+	assert.Equal(t, "stdin_eof", ctrl.Type)
+}
+
+// TestExec_StopsReadingStdin tests that a blocked Stdin is not read or
+// forwarded after the command exits
+func TestExec_StopsReadingStdin(t *testing.T) {
+	conn := newChanWsConn()
+	exitMsg, _ := json.Marshal(execMessage{Type: "exit", ExitCode: 0})
+	stdinR, stdinW := io.Pipe()
+	defer stdinR.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := Exec(context.Background(), CpConfig{BaseURL: "http://localhost:8080"}, ExecOptions{
+			InstanceID: "inst_123",
+			Command:    []string{"cat"},
+			Stdin:      stdinR,
+			Dialer:     &MockWsDialer{conn: conn},
+		})
+		result <- err
+	}()
+
+	assert.Equal(t, []any{"cat"}, conn.nextControl(t)["command"])
+	_, err := stdinW.Write([]byte("before"))
+	require.NoError(t, err)
+	msg := <-conn.written
+	assert.Equal(t, "before", string(msg.Data))
+
+	conn.incoming <- wsMessage{Type: websocket.TextMessage, Data: exitMsg}
+	require.NoError(t, <-result)
+
+	// The Read blocked when the command exited takes this write, and the
+	// reading goroutine then exits without forwarding it
+	_, err = stdinW.Write([]byte("after"))
+	require.NoError(t, err)
+	stdinW.CloseWithError(errors.New("unexpected read"))
+	select {
+	case msg := <-conn.written:
+		t.Fatalf("unexpected frame after exit: %q", msg.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
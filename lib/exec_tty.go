@@ -0,0 +1,146 @@
+// Package lib provides manually-maintained functionality that extends the auto-generated SDK.
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// defaultInteractiveCommand is run when ExecInteractiveOptions.Command is empty
+var defaultInteractiveCommand = []string{"/bin/sh"}
+
+// ctrlC is the byte a terminal in raw mode reads for Ctrl-C
+const ctrlC = 0x03
+
+// ExecInteractiveOptions configures an interactive TTY session inside an instance
+type ExecInteractiveOptions struct {
+	InstanceID string            // Instance ID to open the session in
+	Command    []string          // Optional: command to run (defaults to /bin/sh)
+	Env        map[string]string // Optional: additional environment variables
+	WorkingDir string            // Optional: working directory in guest
+	Terminal   Terminal          // Optional: local terminal (defaults to StdTerminal)
+	Dialer     WsDialer          // Optional: custom WebSocket dialer (for testing)
+	// Optional: send Ctrl-C to the guest as input rather than as a SIGINT
+	// signal frame, for programs that read it themselves, such as editors
+	CtrlCAsInput bool
+}
+
+// ExecInteractive opens an interactive session with a PTY allocated in the
+// guest. The local terminal is put into raw mode for the duration of the
+// session and window-size changes are forwarded as resize frames. It returns
+// the remote command's exit status once it exits and the session's input has
+// stopped being read.
+//
+// Ctrl-C is forwarded as a SIGINT signal frame. In raw mode the terminal reads
+// it as input, so each Ctrl-C byte is taken out of the input and sent as a
+// signal frame in its place; when stdin is not a terminal, interrupts of the
+// local process are forwarded. Set CtrlCAsInput to send the byte as input
+// instead, leaving the guest's PTY or program to act on it.
+//
+// Example:
+//
+//	cfg, _ := lib.ExtractCpConfig(client.Options)
+//	code, err := lib.ExecInteractive(ctx, cfg, lib.ExecInteractiveOptions{
+//	    InstanceID: "inst_123",
+//	    Command:    []string{"/bin/bash", "-l"},
+//	})
+func ExecInteractive(ctx context.Context, cfg CpConfig, opts ExecInteractiveOptions) (int, error) {
+	command := opts.Command
+	if len(command) == 0 {
+		command = defaultInteractiveCommand
+	}
+	terminal := opts.Terminal
+	if terminal == nil {
+		terminal = StdTerminal()
+	}
+
+	ws, err := dialInstance(ctx, cfg, opts.Dialer, opts.InstanceID, "exec")
+	if err != nil {
+		return -1, err
+	}
+	conn := &syncWsConn{conn: ws}
+	defer conn.Close()
+
+	size, _ := terminal.Size()
+	req := execRequest{
+		Command: command,
+		Env:     opts.Env,
+		Cwd:     opts.WorkingDir,
+		TTY:     true,
+		Rows:    size.Rows,
+		Cols:    size.Cols,
+	}
+	if err := conn.writeJSON(req); err != nil {
+		return -1, fmt.Errorf("send request: %w", err)
+	}
+
+	restore, err := terminal.MakeRaw()
+	if err != nil {
+		return -1, fmt.Errorf("set raw mode: %w", err)
+	}
+	defer restore()
+
+	resize, interrupt, stop := terminal.WatchSignals()
+	defer stop()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		forwardTerminalEvents(conn, terminal, resize, interrupt, done)
+	}()
+	go func() {
+		defer wg.Done()
+		pumpExecStdin(conn, terminalInput(terminal, done), done, !opts.CtrlCAsInput)
+	}()
+	// Stop forwarding before the terminal is restored. Closing the connection
+	// unblocks a write in progress.
+	defer func() {
+		close(done)
+		conn.Close()
+		wg.Wait()
+	}()
+
+	// With a PTY the guest merges stderr into stdout
+	return readExecOutput(ctx, conn, terminal, terminal)
+}
+
+// inputSource is implemented by terminals that read their input in the
+// background, like StdTerminal, so that a session can stop waiting for it
+// without leaving a Read behind.
+type inputSource interface {
+	input() <-chan inputChunk
+}
+
+// terminalInput returns a session's input from terminal, read until done is
+// closed.
+func terminalInput(terminal Terminal, done <-chan struct{}) <-chan inputChunk {
+	if src, ok := terminal.(inputSource); ok {
+		return src.input()
+	}
+	return readChunks(terminal, done)
+}
+
+// forwardTerminalEvents sends resize and signal control frames until done is closed.
+func forwardTerminalEvents(ws *syncWsConn, terminal Terminal, resize, interrupt <-chan struct{}, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-resize:
+			size, err := terminal.Size()
+			if err != nil || size == (TerminalSize{}) {
+				continue
+			}
+			if err := ws.writeJSON(execControl{Type: "resize", Rows: size.Rows, Cols: size.Cols}); err != nil {
+				return
+			}
+		case <-interrupt:
+			if err := ws.writeJSON(execControl{Type: "signal", Signal: "SIGINT"}); err != nil {
+				return
+			}
+		}
+	}
+}
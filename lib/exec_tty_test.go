@@ -0,0 +1,269 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chanWsConn implements WsConn over channels so tests can interleave frames
+type chanWsConn struct {
+	written   chan wsMessage
+	incoming  chan wsMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newChanWsConn() *chanWsConn {
+	return &chanWsConn{
+		written:  make(chan wsMessage, 64),
+		incoming: make(chan wsMessage, 64),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (c *chanWsConn) WriteMessage(messageType int, data []byte) error {
+	select {
+	case <-c.closed:
+		return websocket.ErrCloseSent
+	default:
+	}
+	c.written <- wsMessage{Type: messageType, Data: append([]byte(nil), data...)}
+	return nil
+}
+
+func (c *chanWsConn) ReadMessage() (int, []byte, error) {
+	select {
+	case msg := <-c.incoming:
+		return msg.Type, msg.Data, nil
+	case <-c.closed:
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure}
+	}
+}
+
+func (c *chanWsConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// nextControl returns the next text frame written by the client
+func (c *chanWsConn) nextControl(t *testing.T) map[string]any {
+	t.Helper()
+	for {
+		select {
+		case msg := <-c.written:
+			if msg.Type != websocket.TextMessage {
+				continue
+			}
+			var out map[string]any
+			require.NoError(t, json.Unmarshal(msg.Data, &out))
+			return out
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for control frame")
+			return nil
+		}
+	}
+}
+
+// fakeTerminal implements Terminal for testing
+type fakeTerminal struct {
+	mu        sync.Mutex
+	in        *strings.Reader
+	out       strings.Builder
+	size      TerminalSize
+	raw       bool
+	restored  bool
+	resize    chan struct{}
+	interrupt chan struct{}
+}
+
+func (f *fakeTerminal) Read(p []byte) (int, error) { return f.in.Read(p) }
+
+func (f *fakeTerminal) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.out.Write(p)
+}
+
+func (f *fakeTerminal) MakeRaw() (func() error, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.raw = true
+	return func() error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.restored = true
+		return nil
+	}, nil
+}
+
+func (f *fakeTerminal) Size() (TerminalSize, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.size, nil
+}
+
+func (f *fakeTerminal) setSize(size TerminalSize) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.size = size
+}
+
+func (f *fakeTerminal) WatchSignals() (<-chan struct{}, <-chan struct{}, func()) {
+	return f.resize, f.interrupt, func() {}
+}
+
+// TestExecInteractive tests PTY allocation, resize and signal forwarding
+func TestExecInteractive(t *testing.T) {
+	conn := newChanWsConn()
+	terminal := &fakeTerminal{
+		in:        strings.NewReader(""),
+		size:      TerminalSize{Rows: 24, Cols: 80},
+		resize:    make(chan struct{}, 1),
+		interrupt: make(chan struct{}, 1),
+	}
+
+	type result struct {
+		code int
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, err := ExecInteractive(context.Background(), CpConfig{
+			BaseURL: "http://localhost:8080",
+			APIKey:  "test-key",
+		}, ExecInteractiveOptions{
+			InstanceID: "inst_123",
+			Terminal:   terminal,
+			Dialer:     &MockWsDialer{conn: conn},
+		})
+		done <- result{code, err}
+	}()
+
+	req := conn.nextControl(t)
+	assert.Equal(t, true, req["tty"])
+	assert.Equal(t, []any{"/bin/sh"}, req["command"])
+	assert.Equal(t, float64(24), req["rows"])
+	assert.Equal(t, float64(80), req["cols"])
+
+	assert.Equal(t, "stdin_eof", conn.nextControl(t)["type"])
+
+	terminal.setSize(TerminalSize{Rows: 40, Cols: 120})
+	terminal.resize <- struct{}{}
+	resize := conn.nextControl(t)
+	assert.Equal(t, "resize", resize["type"])
+	assert.Equal(t, float64(40), resize["rows"])
+	assert.Equal(t, float64(120), resize["cols"])
+
+	terminal.interrupt <- struct{}{}
+	sig := conn.nextControl(t)
+	assert.Equal(t, "signal", sig["type"])
+	assert.Equal(t, "SIGINT", sig["signal"])
+
+	exitMsg, _ := json.Marshal(execMessage{Type: "exit", ExitCode: 130})
+	conn.incoming <- execOutputFrame(execStreamStdout, "^C\r\n")
+	conn.incoming <- wsMessage{Type: websocket.TextMessage, Data: exitMsg}
+
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, 130, res.code)
+
+	terminal.mu.Lock()
+	defer terminal.mu.Unlock()
+	assert.Equal(t, "^C\r\n", terminal.out.String())
+	assert.True(t, terminal.raw)
+	assert.True(t, terminal.restored)
+}
+
+// sourceTerminal is a fakeTerminal whose input is read in the background,
+// like StdTerminal's
+type sourceTerminal struct {
+	*fakeTerminal
+	chunks chan inputChunk
+}
+
+func (s *sourceTerminal) input() <-chan inputChunk { return s.chunks }
+
+// TestExecInteractive_StopsReadingInput tests that input typed after the
+// session ends is left for the next session
+func TestExecInteractive_StopsReadingInput(t *testing.T) {
+	conn := newChanWsConn()
+	terminal := &sourceTerminal{
+		fakeTerminal: &fakeTerminal{resize: make(chan struct{}), interrupt: make(chan struct{})},
+		chunks:       make(chan inputChunk),
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ExecInteractive(context.Background(), CpConfig{BaseURL: "http://localhost:8080"}, ExecInteractiveOptions{
+			InstanceID: "inst_123",
+			Terminal:   terminal,
+			Dialer:     &MockWsDialer{conn: conn},
+		})
+		done <- err
+	}()
+
+	assert.Equal(t, true, conn.nextControl(t)["tty"])
+	terminal.chunks <- inputChunk{data: []byte("ls\r")}
+	msg := <-conn.written
+	assert.Equal(t, "ls\r", string(msg.Data))
+
+	exitMsg, _ := json.Marshal(execMessage{Type: "exit", ExitCode: 0})
+	conn.incoming <- wsMessage{Type: websocket.TextMessage, Data: exitMsg}
+	require.NoError(t, <-done)
+
+	select {
+	case terminal.chunks <- inputChunk{data: []byte("next")}:
+		t.Fatal("input read after the session ended")
+	case <-time.After(50 * time.Millisecond):
+	}
+	terminal.mu.Lock()
+	defer terminal.mu.Unlock()
+	assert.True(t, terminal.restored)
+}
+
+// TestExecInteractive_CtrlC tests that Ctrl-C read in raw mode is sent as a
+// signal frame, or as input with CtrlCAsInput
+func TestExecInteractive_CtrlC(t *testing.T) {
+	for _, asInput := range []bool{false, true} {
+		conn := newChanWsConn()
+		terminal := &sourceTerminal{
+			fakeTerminal: &fakeTerminal{resize: make(chan struct{}), interrupt: make(chan struct{})},
+			chunks:       make(chan inputChunk),
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := ExecInteractive(context.Background(), CpConfig{BaseURL: "http://localhost:8080"}, ExecInteractiveOptions{
+				InstanceID:   "inst_123",
+				Terminal:     terminal,
+				Dialer:       &MockWsDialer{conn: conn},
+				CtrlCAsInput: asInput,
+			})
+			done <- err
+		}()
+
+		assert.Equal(t, true, conn.nextControl(t)["tty"])
+		terminal.chunks <- inputChunk{data: []byte("sleep 9\r\x03")}
+		if asInput {
+			msg := <-conn.written
+			assert.Equal(t, "sleep 9\r\x03", string(msg.Data))
+		} else {
+			msg := <-conn.written
+			assert.Equal(t, "sleep 9\r", string(msg.Data))
+			sig := conn.nextControl(t)
+			assert.Equal(t, "signal", sig["type"])
+			assert.Equal(t, "SIGINT", sig["signal"])
+		}
+
+		exitMsg, _ := json.Marshal(execMessage{Type: "exit", ExitCode: 130})
+		conn.incoming <- wsMessage{Type: websocket.TextMessage, Data: exitMsg}
+		require.NoError(t, <-done)
+	}
+}
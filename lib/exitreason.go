@@ -0,0 +1,141 @@
+// Package lib provides manually-maintained functionality that extends the auto-generated SDK.
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/kernel/hypeman-go"
+)
+
+// ExitReason classifies why an instance's program exited
+type ExitReason string
+
+const (
+	// ExitReasonNone means the instance has not exited
+	ExitReasonNone ExitReason = "none"
+	// ExitReasonClean means the program exited with code 0
+	ExitReasonClean ExitReason = "clean"
+	// ExitReasonNonZero means the program exited with a non-zero code
+	ExitReasonNonZero ExitReason = "non_zero"
+	// ExitReasonSignal means the program was killed by a signal
+	ExitReasonSignal ExitReason = "signal"
+	// ExitReasonOOMKilled means the program was killed for running out of memory
+	ExitReasonOOMKilled ExitReason = "oom_killed"
+	// ExitReasonCommandNotFound means the program could not be found
+	ExitReasonCommandNotFound ExitReason = "command_not_found"
+	// ExitReasonInitFailed means the guest init failed before starting the program
+	ExitReasonInitFailed ExitReason = "init_failed"
+)
+
+var (
+	// ErrExitNonZero is matched by an *ExitError for a non-zero exit code
+	ErrExitNonZero = errors.New("exited with non-zero code")
+	// ErrKilledBySignal is matched by an *ExitError for a program killed by a
+	// signal, including OOM kills
+	ErrKilledBySignal = errors.New("killed by signal")
+	// ErrOOMKilled is matched by an *ExitError for a program killed for running
+	// out of memory
+	ErrOOMKilled = errors.New("out of memory")
+	// ErrCommandNotFound is matched by an *ExitError when the program could not be found
+	ErrCommandNotFound = errors.New("command not found")
+	// ErrGuestInitFailed is matched by an *ExitError when the guest init failed
+	ErrGuestInitFailed = errors.New("guest init failed")
+)
+
+var (
+	oomPattern      = regexp.MustCompile(`(?i)\boom\b|out of memory`)
+	notFoundPattern = regexp.MustCompile(`(?i)command not found|executable file not found`)
+	initPattern     = regexp.MustCompile(`(?i)\binit(ialization)?\b.*\b(fail(ed|ure)?|error)\b`)
+	signalPattern   = regexp.MustCompile(`(?i)\bsignal (\d+)\b`)
+)
+
+// ExitStatus is the diagnosis of an instance's exit
+type ExitStatus struct {
+	Reason  ExitReason
+	Code    int64  // Exit code, 0 for ExitReasonNone
+	Signal  int    // Signal number for ExitReasonSignal and ExitReasonOOMKilled, if known
+	Message string // ExitMessage as reported by the server
+}
+
+// DiagnoseExit classifies the exit of inst from its ExitCode and ExitMessage.
+// Instances without an exit code have not exited.
+//
+// Example:
+//
+//	switch lib.DiagnoseExit(inst).Reason {
+//	case lib.ExitReasonOOMKilled:
+//	    // restart with more memory
+//	case lib.ExitReasonNonZero:
+//	    // restart as is
+//	}
+func DiagnoseExit(inst *hypeman.Instance) ExitStatus {
+	if !inst.JSON.ExitCode.Valid() {
+		return ExitStatus{Reason: ExitReasonNone}
+	}
+	s := ExitStatus{Code: inst.ExitCode, Message: inst.ExitMessage}
+	if m := signalPattern.FindStringSubmatch(s.Message); m != nil {
+		s.Signal, _ = strconv.Atoi(m[1])
+	} else if s.Code > 128 && s.Code <= 128+64 {
+		// Shells report death by signal n as exit code 128+n
+		s.Signal = int(s.Code - 128)
+	}
+
+	switch {
+	case oomPattern.MatchString(s.Message):
+		s.Reason = ExitReasonOOMKilled
+	case s.Code == 127 || notFoundPattern.MatchString(s.Message):
+		s.Reason = ExitReasonCommandNotFound
+	case initPattern.MatchString(s.Message):
+		s.Reason = ExitReasonInitFailed
+	case s.Signal != 0:
+		s.Reason = ExitReasonSignal
+	case s.Code == 0:
+		s.Reason = ExitReasonClean
+	default:
+		s.Reason = ExitReasonNonZero
+	}
+	return s
+}
+
+// Err returns an *ExitError for a failed exit, and nil if the instance has
+// not exited or exited cleanly.
+func (s ExitStatus) Err() error {
+	if s.Reason == ExitReasonNone || s.Reason == ExitReasonClean {
+		return nil
+	}
+	return &ExitError{Status: s}
+}
+
+// ExitError reports a failed exit. It matches ErrInstanceExited and the
+// sentinel of its reason with errors.Is.
+type ExitError struct {
+	Status ExitStatus
+}
+
+func (e *ExitError) Error() string {
+	if e.Status.Message != "" {
+		return fmt.Sprintf("%s with code %d: %s", ErrInstanceExited, e.Status.Code, e.Status.Message)
+	}
+	return fmt.Sprintf("%s with code %d", ErrInstanceExited, e.Status.Code)
+}
+
+func (e *ExitError) Unwrap() []error {
+	errs := []error{ErrInstanceExited}
+	switch e.Status.Reason {
+	case ExitReasonNonZero:
+		errs = append(errs, ErrExitNonZero)
+	case ExitReasonOOMKilled:
+		errs = append(errs, ErrOOMKilled)
+	case ExitReasonCommandNotFound:
+		errs = append(errs, ErrCommandNotFound)
+	case ExitReasonInitFailed:
+		errs = append(errs, ErrGuestInitFailed)
+	}
+	if e.Status.Signal != 0 {
+		errs = append(errs, ErrKilledBySignal)
+	}
+	return errs
+}
@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDiagnoseExit tests classification of exit codes and messages
+func TestDiagnoseExit(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		reason ExitReason
+		signal int
+		target error
+	}{
+		{"running", `{"state":"Running","exit_code":null}`, ExitReasonNone, 0, nil},
+		{"clean", `{"state":"Stopped","exit_code":0}`, ExitReasonClean, 0, nil},
+		{"non-zero", `{"state":"Stopped","exit_code":1,"exit_message":"exit status 1"}`, ExitReasonNonZero, 0, ErrExitNonZero},
+		{"oom", `{"state":"Stopped","exit_code":137,"exit_message":"killed by signal 9 (SIGKILL) - OOM"}`, ExitReasonOOMKilled, 9, ErrOOMKilled},
+		{"signal", `{"state":"Stopped","exit_code":143,"exit_message":"killed by signal 15 (SIGTERM)"}`, ExitReasonSignal, 15, ErrKilledBySignal},
+		{"signal from code", `{"state":"Stopped","exit_code":130}`, ExitReasonSignal, 2, ErrKilledBySignal},
+		{"command not found", `{"state":"Stopped","exit_code":127,"exit_message":"command not found"}`, ExitReasonCommandNotFound, 0, ErrCommandNotFound},
+		{"not found message", `{"state":"Stopped","exit_code":1,"exit_message":"exec: \"server\": executable file not found in $PATH"}`, ExitReasonCommandNotFound, 0, ErrCommandNotFound},
+		{"init failed", `{"state":"Stopped","exit_code":1,"exit_message":"guest init failed: mount /dev/vdb: no medium"}`, ExitReasonInitFailed, 0, ErrGuestInitFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inst hypeman.Instance
+			require.NoError(t, json.Unmarshal([]byte(tt.body), &inst))
+
+			status := DiagnoseExit(&inst)
+			assert.Equal(t, tt.reason, status.Reason)
+			assert.Equal(t, tt.signal, status.Signal)
+
+			err := status.Err()
+			if tt.target == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.target)
+			assert.ErrorIs(t, err, ErrInstanceExited)
+			var exitErr *ExitError
+			require.True(t, errors.As(err, &exitErr))
+			assert.Equal(t, inst.ExitCode, exitErr.Status.Code)
+		})
+	}
+}
+
+// TestExitError tests that OOM kills also match ErrKilledBySignal but not other reasons
+func TestExitError(t *testing.T) {
+	err := (&ExitError{Status: ExitStatus{Reason: ExitReasonOOMKilled, Code: 137, Signal: 9, Message: "OOM"}})
+	assert.ErrorIs(t, err, ErrKilledBySignal)
+	assert.NotErrorIs(t, err, ErrExitNonZero)
+	assert.NotErrorIs(t, err, ErrCommandNotFound)
+	assert.Equal(t, "instance exited with code 137: OOM", err.Error())
+}
@@ -0,0 +1,222 @@
+//go:build ignore
+
+// gen.go writes mocks.go, a mock for each service interface of the hypeman
+// package. Run it with go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/kernel/hypeman-go"
+)
+
+// interfaces are the service interfaces to mock, in the order of hypeman.API's
+// fields. Each mock is named after its interface without the API suffix.
+var interfaces = []reflect.Type{
+	reflect.TypeFor[hypeman.HealthAPI](),
+	reflect.TypeFor[hypeman.ImagesAPI](),
+	reflect.TypeFor[hypeman.InstancesAPI](),
+	reflect.TypeFor[hypeman.InstanceAutoStandbyAPI](),
+	reflect.TypeFor[hypeman.InstanceVolumesAPI](),
+	reflect.TypeFor[hypeman.InstanceSnapshotsAPI](),
+	reflect.TypeFor[hypeman.InstanceSnapshotScheduleAPI](),
+	reflect.TypeFor[hypeman.SnapshotsAPI](),
+	reflect.TypeFor[hypeman.VolumesAPI](),
+	reflect.TypeFor[hypeman.DevicesAPI](),
+	reflect.TypeFor[hypeman.IngressesAPI](),
+	reflect.TypeFor[hypeman.ResourcesAPI](),
+	reflect.TypeFor[hypeman.BuildsAPI](),
+}
+
+var errorType = reflect.TypeFor[error]()
+
+// imports are the packages mocks.go may use, by the name it refers to them by
+var imports = []struct{ name, path string }{
+	{"context", "context"},
+	{"io", "io"},
+	{"iter", "iter"},
+	{"hypeman", "github.com/kernel/hypeman-go"},
+	{"option", "github.com/kernel/hypeman-go/option"},
+	{"ssestream", "github.com/kernel/hypeman-go/packages/ssestream"},
+}
+
+func main() {
+	var body bytes.Buffer
+	writeAPI(&body)
+	for _, iface := range interfaces {
+		writeMock(&body, iface)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gen.go. DO NOT EDIT.\n\npackage hypemanmock\n\nimport (\n")
+	std := true
+	for _, imp := range imports {
+		if !bytes.Contains(body.Bytes(), []byte(imp.name+".")) {
+			continue
+		}
+		if std && strings.Contains(imp.path, ".") {
+			b.WriteString("\n")
+			std = false
+		}
+		fmt.Fprintf(&b, "%q\n", imp.path)
+	}
+	b.WriteString(")\n\n")
+	b.Write(body.Bytes())
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatalf("formatting generated code: %v\n%s", err, b.Bytes())
+	}
+	if err := os.WriteFile("mocks.go", src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func mockName(iface reflect.Type) string {
+	return strings.TrimSuffix(iface.Name(), "API")
+}
+
+// writeAPI writes the API type, which holds a mock for each interface.
+func writeAPI(b *bytes.Buffer) {
+	fmt.Fprintf(b, "// API holds a mock for each service of a [hypeman.API].\ntype API struct {\n")
+	for _, iface := range interfaces {
+		fmt.Fprintf(b, "%s *%s\n", mockName(iface), mockName(iface))
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// New returns an API with a mock for each service, none of which has any\n// Funcs set.\nfunc New() *API {\nreturn &API{\n")
+	for _, iface := range interfaces {
+		fmt.Fprintf(b, "%s: &%s{},\n", mockName(iface), mockName(iface))
+	}
+	fmt.Fprintf(b, "}\n}\n\n")
+
+	fmt.Fprintf(b, "// API returns the mocks as a [hypeman.API].\nfunc (m *API) API() hypeman.API {\nreturn hypeman.API{\n")
+	for _, iface := range interfaces {
+		fmt.Fprintf(b, "%s: m.%s,\n", mockName(iface), mockName(iface))
+	}
+	fmt.Fprintf(b, "}\n}\n\n")
+}
+
+// writeMock writes the mock for iface: a struct with a Func field per method,
+// and the methods, which record the call and then call the Func.
+func writeMock(b *bytes.Buffer, iface reflect.Type) {
+	name := mockName(iface)
+	fmt.Fprintf(b, "// %s is a mock [hypeman.%s].\ntype %s struct {\nRecorder\n\n", name, iface.Name(), name)
+	for m := range iface.Methods() {
+		fmt.Fprintf(b, "%sFunc func%s\n", m.Name, signature(m.Type))
+	}
+	fmt.Fprintf(b, "}\n\nvar _ hypeman.%s = (*%s)(nil)\n\n", iface.Name(), name)
+
+	for m := range iface.Methods() {
+		names := paramNames(m.Type)
+		var recorded, args []string
+		for i, n := range names {
+			switch {
+			case m.Type.IsVariadic() && i == len(names)-1:
+				args = append(args, n+"...")
+			case n == "ctx":
+				args = append(args, n)
+			default:
+				args = append(args, n)
+				recorded = append(recorded, n)
+			}
+		}
+
+		fmt.Fprintf(b, "func (m *%s) %s%s {\n", name, m.Name, signature(m.Type))
+		fmt.Fprintf(b, "m.record(%q", m.Name)
+		for _, r := range recorded {
+			fmt.Fprintf(b, ", %s", r)
+		}
+		fmt.Fprintf(b, ")\nif m.%sFunc == nil {\nreturn %s\n}\n", m.Name, unexpectedResults(m.Type, fmt.Sprintf("unexpected(%q, %q)", name, m.Name)))
+		fmt.Fprintf(b, "return m.%sFunc(%s)\n}\n\n", m.Name, strings.Join(args, ", "))
+	}
+}
+
+// paramNames names a method's parameters after their types.
+func paramNames(fn reflect.Type) []string {
+	var names []string
+	for i := range fn.NumIn() {
+		t := fn.In(i)
+		switch {
+		case fn.IsVariadic() && i == fn.NumIn()-1:
+			names = append(names, "opts")
+		case t.PkgPath() == "context" && t.Name() == "Context":
+			names = append(names, "ctx")
+		case t.Kind() == reflect.String:
+			names = append(names, "id")
+		case t.Kind() == reflect.Interface:
+			names = append(names, "body")
+		default:
+			names = append(names, "params")
+		}
+	}
+	return names
+}
+
+func signature(fn reflect.Type) string {
+	names := paramNames(fn)
+	var params, results []string
+	for i := range fn.NumIn() {
+		if fn.IsVariadic() && i == fn.NumIn()-1 {
+			params = append(params, names[i]+" ..."+typeString(fn.In(i).Elem()))
+		} else {
+			params = append(params, names[i]+" "+typeString(fn.In(i)))
+		}
+	}
+	for i := range fn.NumOut() {
+		results = append(results, typeString(fn.Out(i)))
+	}
+	s := "(" + strings.Join(params, ", ") + ")"
+	switch len(results) {
+	case 0:
+		return s
+	case 1:
+		return s + " " + results[0]
+	default:
+		return s + " (" + strings.Join(results, ", ") + ")"
+	}
+}
+
+// unexpectedResults is the results a method returns when its Func is nil:
+// err, in a form that fits the method's results.
+func unexpectedResults(fn reflect.Type, err string) string {
+	if fn.NumOut() == 1 {
+		t := fn.Out(0)
+		switch {
+		case t == errorType:
+			return err
+		case t.Kind() == reflect.Pointer && t.Elem().PkgPath() == "github.com/kernel/hypeman-go/packages/ssestream":
+			return strings.Replace(typeString(t.Elem()), "ssestream.Stream[", "ssestream.NewStream[", 1) + "(nil, " + err + ")"
+		case t.PkgPath() == "iter":
+			elem := typeString(t.In(0).In(0))
+			return fmt.Sprintf("func(yield func(%s, error) bool) {\nyield(%s{}, %s)\n}", elem, elem, err)
+		}
+	}
+	var results []string
+	for i := range fn.NumOut() {
+		if fn.Out(i) == errorType {
+			results = append(results, err)
+		} else {
+			results = append(results, "nil")
+		}
+	}
+	return strings.Join(results, ", ")
+}
+
+// typeString is t as written in mocks.go, whose imports use the packages'
+// default names. Reflection sees through the option.RequestOption alias to
+// the internal type, which mocks.go cannot name.
+func typeString(t reflect.Type) string {
+	return typeNames.Replace(t.String())
+}
+
+var typeNames = strings.NewReplacer(
+	"github.com/kernel/hypeman-go.", "hypeman.",
+	"requestconfig.RequestOption", "option.RequestOption",
+)
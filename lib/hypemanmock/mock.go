@@ -0,0 +1,152 @@
+// Package hypemanmock provides mock implementations of the hypeman service
+// interfaces, for unit tests of code that takes a [hypeman.API] or one of its
+// services.
+//
+// Each mock has a Func field per method. A call records its arguments and
+// then calls the Func, or returns an error matching [ErrUnexpectedCall] if
+// the Func is nil:
+//
+//	m := hypemanmock.New()
+//	m.Instances.GetFunc = func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Instance, error) {
+//		return &hypeman.Instance{ID: id, State: hypeman.InstanceStateRunning}, nil
+//	}
+//	runCodeUnderTest(m.API())
+//	m.Instances.AssertCalled(t, "Get", "inst_1")
+//
+// [FromJSON] builds results from response bodies, and [Stream] and
+// [StreamOf] build the results of streaming methods.
+//
+// The mocks are generated from the interfaces by gen.go; run go generate
+// after changing them.
+package hypemanmock
+
+//go:generate go run gen.go
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// ErrUnexpectedCall is matched by the error a mock method returns when its
+// Func is not set.
+var ErrUnexpectedCall = errors.New("unexpected call")
+
+func unexpected(mock, method string) error {
+	return fmt.Errorf("hypemanmock: %s.%s: %w", mock, method, ErrUnexpectedCall)
+}
+
+// FromJSON decodes body into a T as the client decodes a response, for
+// results with fields that are only set from JSON, such as whether a nullable
+// field was null. It panics if body does not decode.
+func FromJSON[T any](body string) T {
+	var v T
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		panic(fmt.Sprintf("hypemanmock: FromJSON: %v", err))
+	}
+	return v
+}
+
+// Call is a recorded method call.
+type Call struct {
+	Method string
+	// Args are the call's arguments, without the context and request options
+	Args []any
+}
+
+// TestingT is the subset of [testing.T] the assertion helpers use.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// Recorder records the calls made to a mock. It is safe for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (r *Recorder) record(method string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns the calls made so far, in order.
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.calls)
+}
+
+// CallsTo returns the calls made so far to method, in order.
+func (r *Recorder) CallsTo(method string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var calls []Call
+	for _, c := range r.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls made so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
+
+// AssertCalled reports an error to t unless method was called. If args are
+// given, some call must have had arguments deeply equal to them.
+func (r *Recorder) AssertCalled(t TestingT, method string, args ...any) bool {
+	t.Helper()
+	calls := r.CallsTo(method)
+	if len(calls) == 0 {
+		t.Errorf("expected a call to %s, got none", method)
+		return false
+	}
+	if len(args) == 0 {
+		return true
+	}
+	for _, c := range calls {
+		if reflect.DeepEqual(c.Args, args) {
+			return true
+		}
+	}
+	t.Errorf("expected a call to %s with arguments %#v, got calls with:\n%s", method, args, formatCalls(calls))
+	return false
+}
+
+// AssertNotCalled reports an error to t if method was called.
+func (r *Recorder) AssertNotCalled(t TestingT, method string) bool {
+	t.Helper()
+	if calls := r.CallsTo(method); len(calls) > 0 {
+		t.Errorf("expected no calls to %s, got %d:\n%s", method, len(calls), formatCalls(calls))
+		return false
+	}
+	return true
+}
+
+// AssertNumberOfCalls reports an error to t unless method was called n times.
+func (r *Recorder) AssertNumberOfCalls(t TestingT, method string, n int) bool {
+	t.Helper()
+	if calls := r.CallsTo(method); len(calls) != n {
+		t.Errorf("expected %d calls to %s, got %d", n, method, len(calls))
+		return false
+	}
+	return true
+}
+
+func formatCalls(calls []Call) string {
+	var s string
+	for _, c := range calls {
+		s += fmt.Sprintf("\t%#v\n", c.Args)
+	}
+	return s
+}
@@ -0,0 +1,148 @@
+package hypemanmock
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"testing"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeT records the errors assertion helpers report
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+// standbyAll is code under test that takes the service interfaces
+func standbyAll(ctx context.Context, api hypeman.API) error {
+	for inst, err := range api.Instances.ListIter(ctx, hypeman.InstanceListParams{State: hypeman.InstanceListParamsStateRunning}) {
+		if err != nil {
+			return err
+		}
+		if _, err := api.Instances.Standby(ctx, inst.ID, hypeman.InstanceStandbyParams{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestMockRecordsCalls tests that calls are recorded and passed to the Funcs
+func TestMockRecordsCalls(t *testing.T) {
+	m := New()
+	m.Instances.ListIterFunc = func(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Instance, error] {
+		return func(yield func(hypeman.Instance, error) bool) {
+			_ = yield(hypeman.Instance{ID: "a"}, nil) && yield(hypeman.Instance{ID: "b"}, nil)
+		}
+	}
+	m.Instances.StandbyFunc = func(ctx context.Context, id string, params hypeman.InstanceStandbyParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+		return &hypeman.Instance{ID: id, State: hypeman.InstanceStateStandby}, nil
+	}
+
+	require.NoError(t, standbyAll(t.Context(), m.API()))
+	assert.True(t, m.Instances.AssertNumberOfCalls(t, "Standby", 2))
+	assert.True(t, m.Instances.AssertCalled(t, "Standby", "b", hypeman.InstanceStandbyParams{}))
+	assert.True(t, m.Instances.AssertCalled(t, "ListIter"))
+	assert.True(t, m.Instances.AssertNotCalled(t, "Delete"))
+	assert.Equal(t, []Call{
+		{Method: "ListIter", Args: []any{hypeman.InstanceListParams{State: hypeman.InstanceListParamsStateRunning}}},
+		{Method: "Standby", Args: []any{"a", hypeman.InstanceStandbyParams{}}},
+		{Method: "Standby", Args: []any{"b", hypeman.InstanceStandbyParams{}}},
+	}, m.Instances.Calls())
+
+	m.Instances.Reset()
+	assert.Empty(t, m.Instances.Calls())
+}
+
+// TestMockAssertionFailures tests that the assertion helpers report mismatches
+func TestMockAssertionFailures(t *testing.T) {
+	m := &Snapshots{}
+	m.DeleteFunc = func(ctx context.Context, id string, opts ...option.RequestOption) error { return nil }
+	require.NoError(t, m.Delete(t.Context(), "snap_1"))
+
+	ft := &fakeT{}
+	assert.False(t, m.AssertCalled(ft, "Delete", "snap_2"))
+	assert.False(t, m.AssertCalled(ft, "Get"))
+	assert.False(t, m.AssertNotCalled(ft, "Delete"))
+	assert.False(t, m.AssertNumberOfCalls(ft, "Delete", 2))
+	require.Len(t, ft.errors, 4)
+	assert.Contains(t, ft.errors[0], `"snap_1"`)
+	assert.Equal(t, "expected a call to Get, got none", ft.errors[1])
+}
+
+// TestMockUnexpectedCall tests the results of methods whose Func is not set
+func TestMockUnexpectedCall(t *testing.T) {
+	m := New()
+	ctx := t.Context()
+
+	_, err := m.Instances.Get(ctx, "inst_1")
+	assert.ErrorIs(t, err, ErrUnexpectedCall)
+	assert.EqualError(t, err, "hypemanmock: Instances.Get: unexpected call")
+	assert.ErrorIs(t, m.Volumes.Delete(ctx, "vol_1"), ErrUnexpectedCall)
+
+	stream := m.Builds.EventsStreaming(ctx, "build_1", hypeman.BuildEventsParams{})
+	assert.False(t, stream.Next())
+	assert.ErrorIs(t, stream.Err(), ErrUnexpectedCall)
+	require.NoError(t, stream.Close())
+
+	for _, err := range m.Devices.ListIter(ctx, hypeman.DeviceListParams{}) {
+		assert.ErrorIs(t, err, ErrUnexpectedCall)
+	}
+	m.Devices.AssertCalled(t, "ListIter", hypeman.DeviceListParams{})
+}
+
+// TestClientAPI tests that a client's API shares its services
+func TestClientAPI(t *testing.T) {
+	client := hypeman.NewClient(option.WithBaseURL("http://127.0.0.1:0"), option.WithAPIKey("test"))
+	api := client.API()
+	assert.Same(t, &client.Instances, api.Instances)
+	assert.Same(t, &client.Instances.Snapshots, api.InstanceSnapshots)
+	assert.Same(t, &client.Builds, api.Builds)
+}
+
+// TestFromJSON tests that decoded results keep the JSON metadata of nullable fields
+func TestFromJSON(t *testing.T) {
+	list := FromJSON[[]hypeman.Instance](`[{"id":"a","exit_code":null},{"id":"b","exit_code":0}]`)
+	require.Len(t, list, 2)
+	assert.False(t, list[0].JSON.ExitCode.Valid())
+	assert.True(t, list[1].JSON.ExitCode.Valid())
+
+	assert.Panics(t, func() { FromJSON[hypeman.Instance](`[`) })
+}
+
+// TestStream tests that streams yield their values and end with the channel,
+// on close or with the context's error
+func TestStream(t *testing.T) {
+	stream := StreamOf(hypeman.BuildEvent{Type: hypeman.BuildEventTypeLog, Content: "step 1"}, hypeman.BuildEvent{Type: hypeman.BuildEventTypeHeartbeat})
+	var types []hypeman.BuildEventType
+	for stream.Next() {
+		types = append(types, stream.Current().Type)
+	}
+	require.NoError(t, stream.Err())
+	assert.Equal(t, []hypeman.BuildEventType{hypeman.BuildEventTypeLog, hypeman.BuildEventTypeHeartbeat}, types)
+
+	events := make(chan string, 1)
+	events <- "line"
+	lines := Stream(t.Context(), events)
+	require.True(t, lines.Next())
+	assert.Equal(t, "line", lines.Current())
+	require.NoError(t, lines.Close())
+	events <- "after close"
+	assert.False(t, lines.Next())
+	require.NoError(t, lines.Err())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	lines = Stream(ctx, make(chan string))
+	assert.False(t, lines.Next())
+	assert.ErrorIs(t, lines.Err(), context.Canceled)
+}
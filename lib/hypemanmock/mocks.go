@@ -0,0 +1,718 @@
+// Code generated by gen.go. DO NOT EDIT.
+
+package hypemanmock
+
+import (
+	"context"
+	"io"
+	"iter"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/kernel/hypeman-go/packages/ssestream"
+)
+
+// API holds a mock for each service of a [hypeman.API].
+type API struct {
+	Health                   *Health
+	Images                   *Images
+	Instances                *Instances
+	InstanceAutoStandby      *InstanceAutoStandby
+	InstanceVolumes          *InstanceVolumes
+	InstanceSnapshots        *InstanceSnapshots
+	InstanceSnapshotSchedule *InstanceSnapshotSchedule
+	Snapshots                *Snapshots
+	Volumes                  *Volumes
+	Devices                  *Devices
+	Ingresses                *Ingresses
+	Resources                *Resources
+	Builds                   *Builds
+}
+
+// New returns an API with a mock for each service, none of which has any
+// Funcs set.
+func New() *API {
+	return &API{
+		Health:                   &Health{},
+		Images:                   &Images{},
+		Instances:                &Instances{},
+		InstanceAutoStandby:      &InstanceAutoStandby{},
+		InstanceVolumes:          &InstanceVolumes{},
+		InstanceSnapshots:        &InstanceSnapshots{},
+		InstanceSnapshotSchedule: &InstanceSnapshotSchedule{},
+		Snapshots:                &Snapshots{},
+		Volumes:                  &Volumes{},
+		Devices:                  &Devices{},
+		Ingresses:                &Ingresses{},
+		Resources:                &Resources{},
+		Builds:                   &Builds{},
+	}
+}
+
+// API returns the mocks as a [hypeman.API].
+func (m *API) API() hypeman.API {
+	return hypeman.API{
+		Health:                   m.Health,
+		Images:                   m.Images,
+		Instances:                m.Instances,
+		InstanceAutoStandby:      m.InstanceAutoStandby,
+		InstanceVolumes:          m.InstanceVolumes,
+		InstanceSnapshots:        m.InstanceSnapshots,
+		InstanceSnapshotSchedule: m.InstanceSnapshotSchedule,
+		Snapshots:                m.Snapshots,
+		Volumes:                  m.Volumes,
+		Devices:                  m.Devices,
+		Ingresses:                m.Ingresses,
+		Resources:                m.Resources,
+		Builds:                   m.Builds,
+	}
+}
+
+// Health is a mock [hypeman.HealthAPI].
+type Health struct {
+	Recorder
+
+	CheckFunc func(ctx context.Context, opts ...option.RequestOption) (*hypeman.HealthCheckResponse, error)
+}
+
+var _ hypeman.HealthAPI = (*Health)(nil)
+
+func (m *Health) Check(ctx context.Context, opts ...option.RequestOption) (*hypeman.HealthCheckResponse, error) {
+	m.record("Check")
+	if m.CheckFunc == nil {
+		return nil, unexpected("Health", "Check")
+	}
+	return m.CheckFunc(ctx, opts...)
+}
+
+// Images is a mock [hypeman.ImagesAPI].
+type Images struct {
+	Recorder
+
+	DeleteFunc   func(ctx context.Context, id string, opts ...option.RequestOption) error
+	GetFunc      func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Image, error)
+	ListFunc     func(ctx context.Context, params hypeman.ImageListParams, opts ...option.RequestOption) (*[]hypeman.Image, error)
+	ListIterFunc func(ctx context.Context, params hypeman.ImageListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Image, error]
+	NewFunc      func(ctx context.Context, params hypeman.ImageNewParams, opts ...option.RequestOption) (*hypeman.Image, error)
+}
+
+var _ hypeman.ImagesAPI = (*Images)(nil)
+
+func (m *Images) Delete(ctx context.Context, id string, opts ...option.RequestOption) error {
+	m.record("Delete", id)
+	if m.DeleteFunc == nil {
+		return unexpected("Images", "Delete")
+	}
+	return m.DeleteFunc(ctx, id, opts...)
+}
+
+func (m *Images) Get(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Image, error) {
+	m.record("Get", id)
+	if m.GetFunc == nil {
+		return nil, unexpected("Images", "Get")
+	}
+	return m.GetFunc(ctx, id, opts...)
+}
+
+func (m *Images) List(ctx context.Context, params hypeman.ImageListParams, opts ...option.RequestOption) (*[]hypeman.Image, error) {
+	m.record("List", params)
+	if m.ListFunc == nil {
+		return nil, unexpected("Images", "List")
+	}
+	return m.ListFunc(ctx, params, opts...)
+}
+
+func (m *Images) ListIter(ctx context.Context, params hypeman.ImageListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Image, error] {
+	m.record("ListIter", params)
+	if m.ListIterFunc == nil {
+		return func(yield func(hypeman.Image, error) bool) {
+			yield(hypeman.Image{}, unexpected("Images", "ListIter"))
+		}
+	}
+	return m.ListIterFunc(ctx, params, opts...)
+}
+
+func (m *Images) New(ctx context.Context, params hypeman.ImageNewParams, opts ...option.RequestOption) (*hypeman.Image, error) {
+	m.record("New", params)
+	if m.NewFunc == nil {
+		return nil, unexpected("Images", "New")
+	}
+	return m.NewFunc(ctx, params, opts...)
+}
+
+// Instances is a mock [hypeman.InstancesAPI].
+type Instances struct {
+	Recorder
+
+	DeleteFunc        func(ctx context.Context, id string, opts ...option.RequestOption) error
+	ForkFunc          func(ctx context.Context, id string, params hypeman.InstanceForkParams, opts ...option.RequestOption) (*hypeman.Instance, error)
+	GetFunc           func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Instance, error)
+	ListFunc          func(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) (*[]hypeman.Instance, error)
+	ListIterFunc      func(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Instance, error]
+	LogsStreamingFunc func(ctx context.Context, id string, params hypeman.InstanceLogsParams, opts ...option.RequestOption) *ssestream.Stream[string]
+	NewFunc           func(ctx context.Context, params hypeman.InstanceNewParams, opts ...option.RequestOption) (*hypeman.Instance, error)
+	RestoreFunc       func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Instance, error)
+	StandbyFunc       func(ctx context.Context, id string, params hypeman.InstanceStandbyParams, opts ...option.RequestOption) (*hypeman.Instance, error)
+	StartFunc         func(ctx context.Context, id string, params hypeman.InstanceStartParams, opts ...option.RequestOption) (*hypeman.Instance, error)
+	StatFunc          func(ctx context.Context, id string, params hypeman.InstanceStatParams, opts ...option.RequestOption) (*hypeman.PathInfo, error)
+	StatsFunc         func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.InstanceStats, error)
+	StopFunc          func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Instance, error)
+	UpdateFunc        func(ctx context.Context, id string, params hypeman.InstanceUpdateParams, opts ...option.RequestOption) (*hypeman.Instance, error)
+	WaitFunc          func(ctx context.Context, id string, params hypeman.InstanceWaitParams, opts ...option.RequestOption) (*hypeman.WaitForStateResponse, error)
+}
+
+var _ hypeman.InstancesAPI = (*Instances)(nil)
+
+func (m *Instances) Delete(ctx context.Context, id string, opts ...option.RequestOption) error {
+	m.record("Delete", id)
+	if m.DeleteFunc == nil {
+		return unexpected("Instances", "Delete")
+	}
+	return m.DeleteFunc(ctx, id, opts...)
+}
+
+func (m *Instances) Fork(ctx context.Context, id string, params hypeman.InstanceForkParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Fork", id, params)
+	if m.ForkFunc == nil {
+		return nil, unexpected("Instances", "Fork")
+	}
+	return m.ForkFunc(ctx, id, params, opts...)
+}
+
+func (m *Instances) Get(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Get", id)
+	if m.GetFunc == nil {
+		return nil, unexpected("Instances", "Get")
+	}
+	return m.GetFunc(ctx, id, opts...)
+}
+
+func (m *Instances) List(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) (*[]hypeman.Instance, error) {
+	m.record("List", params)
+	if m.ListFunc == nil {
+		return nil, unexpected("Instances", "List")
+	}
+	return m.ListFunc(ctx, params, opts...)
+}
+
+func (m *Instances) ListIter(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Instance, error] {
+	m.record("ListIter", params)
+	if m.ListIterFunc == nil {
+		return func(yield func(hypeman.Instance, error) bool) {
+			yield(hypeman.Instance{}, unexpected("Instances", "ListIter"))
+		}
+	}
+	return m.ListIterFunc(ctx, params, opts...)
+}
+
+func (m *Instances) LogsStreaming(ctx context.Context, id string, params hypeman.InstanceLogsParams, opts ...option.RequestOption) *ssestream.Stream[string] {
+	m.record("LogsStreaming", id, params)
+	if m.LogsStreamingFunc == nil {
+		return ssestream.NewStream[string](nil, unexpected("Instances", "LogsStreaming"))
+	}
+	return m.LogsStreamingFunc(ctx, id, params, opts...)
+}
+
+func (m *Instances) New(ctx context.Context, params hypeman.InstanceNewParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("New", params)
+	if m.NewFunc == nil {
+		return nil, unexpected("Instances", "New")
+	}
+	return m.NewFunc(ctx, params, opts...)
+}
+
+func (m *Instances) Restore(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Restore", id)
+	if m.RestoreFunc == nil {
+		return nil, unexpected("Instances", "Restore")
+	}
+	return m.RestoreFunc(ctx, id, opts...)
+}
+
+func (m *Instances) Standby(ctx context.Context, id string, params hypeman.InstanceStandbyParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Standby", id, params)
+	if m.StandbyFunc == nil {
+		return nil, unexpected("Instances", "Standby")
+	}
+	return m.StandbyFunc(ctx, id, params, opts...)
+}
+
+func (m *Instances) Start(ctx context.Context, id string, params hypeman.InstanceStartParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Start", id, params)
+	if m.StartFunc == nil {
+		return nil, unexpected("Instances", "Start")
+	}
+	return m.StartFunc(ctx, id, params, opts...)
+}
+
+func (m *Instances) Stat(ctx context.Context, id string, params hypeman.InstanceStatParams, opts ...option.RequestOption) (*hypeman.PathInfo, error) {
+	m.record("Stat", id, params)
+	if m.StatFunc == nil {
+		return nil, unexpected("Instances", "Stat")
+	}
+	return m.StatFunc(ctx, id, params, opts...)
+}
+
+func (m *Instances) Stats(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.InstanceStats, error) {
+	m.record("Stats", id)
+	if m.StatsFunc == nil {
+		return nil, unexpected("Instances", "Stats")
+	}
+	return m.StatsFunc(ctx, id, opts...)
+}
+
+func (m *Instances) Stop(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Stop", id)
+	if m.StopFunc == nil {
+		return nil, unexpected("Instances", "Stop")
+	}
+	return m.StopFunc(ctx, id, opts...)
+}
+
+func (m *Instances) Update(ctx context.Context, id string, params hypeman.InstanceUpdateParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Update", id, params)
+	if m.UpdateFunc == nil {
+		return nil, unexpected("Instances", "Update")
+	}
+	return m.UpdateFunc(ctx, id, params, opts...)
+}
+
+func (m *Instances) Wait(ctx context.Context, id string, params hypeman.InstanceWaitParams, opts ...option.RequestOption) (*hypeman.WaitForStateResponse, error) {
+	m.record("Wait", id, params)
+	if m.WaitFunc == nil {
+		return nil, unexpected("Instances", "Wait")
+	}
+	return m.WaitFunc(ctx, id, params, opts...)
+}
+
+// InstanceAutoStandby is a mock [hypeman.InstanceAutoStandbyAPI].
+type InstanceAutoStandby struct {
+	Recorder
+
+	StatusFunc func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.AutoStandbyStatus, error)
+}
+
+var _ hypeman.InstanceAutoStandbyAPI = (*InstanceAutoStandby)(nil)
+
+func (m *InstanceAutoStandby) Status(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.AutoStandbyStatus, error) {
+	m.record("Status", id)
+	if m.StatusFunc == nil {
+		return nil, unexpected("InstanceAutoStandby", "Status")
+	}
+	return m.StatusFunc(ctx, id, opts...)
+}
+
+// InstanceVolumes is a mock [hypeman.InstanceVolumesAPI].
+type InstanceVolumes struct {
+	Recorder
+
+	AttachFunc func(ctx context.Context, id string, params hypeman.InstanceVolumeAttachParams, opts ...option.RequestOption) (*hypeman.Instance, error)
+	DetachFunc func(ctx context.Context, id string, params hypeman.InstanceVolumeDetachParams, opts ...option.RequestOption) (*hypeman.Instance, error)
+}
+
+var _ hypeman.InstanceVolumesAPI = (*InstanceVolumes)(nil)
+
+func (m *InstanceVolumes) Attach(ctx context.Context, id string, params hypeman.InstanceVolumeAttachParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Attach", id, params)
+	if m.AttachFunc == nil {
+		return nil, unexpected("InstanceVolumes", "Attach")
+	}
+	return m.AttachFunc(ctx, id, params, opts...)
+}
+
+func (m *InstanceVolumes) Detach(ctx context.Context, id string, params hypeman.InstanceVolumeDetachParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Detach", id, params)
+	if m.DetachFunc == nil {
+		return nil, unexpected("InstanceVolumes", "Detach")
+	}
+	return m.DetachFunc(ctx, id, params, opts...)
+}
+
+// InstanceSnapshots is a mock [hypeman.InstanceSnapshotsAPI].
+type InstanceSnapshots struct {
+	Recorder
+
+	NewFunc     func(ctx context.Context, id string, params hypeman.InstanceSnapshotNewParams, opts ...option.RequestOption) (*hypeman.Snapshot, error)
+	RestoreFunc func(ctx context.Context, id string, params hypeman.InstanceSnapshotRestoreParams, opts ...option.RequestOption) (*hypeman.Instance, error)
+}
+
+var _ hypeman.InstanceSnapshotsAPI = (*InstanceSnapshots)(nil)
+
+func (m *InstanceSnapshots) New(ctx context.Context, id string, params hypeman.InstanceSnapshotNewParams, opts ...option.RequestOption) (*hypeman.Snapshot, error) {
+	m.record("New", id, params)
+	if m.NewFunc == nil {
+		return nil, unexpected("InstanceSnapshots", "New")
+	}
+	return m.NewFunc(ctx, id, params, opts...)
+}
+
+func (m *InstanceSnapshots) Restore(ctx context.Context, id string, params hypeman.InstanceSnapshotRestoreParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Restore", id, params)
+	if m.RestoreFunc == nil {
+		return nil, unexpected("InstanceSnapshots", "Restore")
+	}
+	return m.RestoreFunc(ctx, id, params, opts...)
+}
+
+// InstanceSnapshotSchedule is a mock [hypeman.InstanceSnapshotScheduleAPI].
+type InstanceSnapshotSchedule struct {
+	Recorder
+
+	DeleteFunc func(ctx context.Context, id string, opts ...option.RequestOption) error
+	GetFunc    func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.SnapshotSchedule, error)
+	UpdateFunc func(ctx context.Context, id string, params hypeman.InstanceSnapshotScheduleUpdateParams, opts ...option.RequestOption) (*hypeman.SnapshotSchedule, error)
+}
+
+var _ hypeman.InstanceSnapshotScheduleAPI = (*InstanceSnapshotSchedule)(nil)
+
+func (m *InstanceSnapshotSchedule) Delete(ctx context.Context, id string, opts ...option.RequestOption) error {
+	m.record("Delete", id)
+	if m.DeleteFunc == nil {
+		return unexpected("InstanceSnapshotSchedule", "Delete")
+	}
+	return m.DeleteFunc(ctx, id, opts...)
+}
+
+func (m *InstanceSnapshotSchedule) Get(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.SnapshotSchedule, error) {
+	m.record("Get", id)
+	if m.GetFunc == nil {
+		return nil, unexpected("InstanceSnapshotSchedule", "Get")
+	}
+	return m.GetFunc(ctx, id, opts...)
+}
+
+func (m *InstanceSnapshotSchedule) Update(ctx context.Context, id string, params hypeman.InstanceSnapshotScheduleUpdateParams, opts ...option.RequestOption) (*hypeman.SnapshotSchedule, error) {
+	m.record("Update", id, params)
+	if m.UpdateFunc == nil {
+		return nil, unexpected("InstanceSnapshotSchedule", "Update")
+	}
+	return m.UpdateFunc(ctx, id, params, opts...)
+}
+
+// Snapshots is a mock [hypeman.SnapshotsAPI].
+type Snapshots struct {
+	Recorder
+
+	DeleteFunc   func(ctx context.Context, id string, opts ...option.RequestOption) error
+	ForkFunc     func(ctx context.Context, id string, params hypeman.SnapshotForkParams, opts ...option.RequestOption) (*hypeman.Instance, error)
+	GetFunc      func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Snapshot, error)
+	ListFunc     func(ctx context.Context, params hypeman.SnapshotListParams, opts ...option.RequestOption) (*[]hypeman.Snapshot, error)
+	ListIterFunc func(ctx context.Context, params hypeman.SnapshotListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Snapshot, error]
+}
+
+var _ hypeman.SnapshotsAPI = (*Snapshots)(nil)
+
+func (m *Snapshots) Delete(ctx context.Context, id string, opts ...option.RequestOption) error {
+	m.record("Delete", id)
+	if m.DeleteFunc == nil {
+		return unexpected("Snapshots", "Delete")
+	}
+	return m.DeleteFunc(ctx, id, opts...)
+}
+
+func (m *Snapshots) Fork(ctx context.Context, id string, params hypeman.SnapshotForkParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+	m.record("Fork", id, params)
+	if m.ForkFunc == nil {
+		return nil, unexpected("Snapshots", "Fork")
+	}
+	return m.ForkFunc(ctx, id, params, opts...)
+}
+
+func (m *Snapshots) Get(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Snapshot, error) {
+	m.record("Get", id)
+	if m.GetFunc == nil {
+		return nil, unexpected("Snapshots", "Get")
+	}
+	return m.GetFunc(ctx, id, opts...)
+}
+
+func (m *Snapshots) List(ctx context.Context, params hypeman.SnapshotListParams, opts ...option.RequestOption) (*[]hypeman.Snapshot, error) {
+	m.record("List", params)
+	if m.ListFunc == nil {
+		return nil, unexpected("Snapshots", "List")
+	}
+	return m.ListFunc(ctx, params, opts...)
+}
+
+func (m *Snapshots) ListIter(ctx context.Context, params hypeman.SnapshotListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Snapshot, error] {
+	m.record("ListIter", params)
+	if m.ListIterFunc == nil {
+		return func(yield func(hypeman.Snapshot, error) bool) {
+			yield(hypeman.Snapshot{}, unexpected("Snapshots", "ListIter"))
+		}
+	}
+	return m.ListIterFunc(ctx, params, opts...)
+}
+
+// Volumes is a mock [hypeman.VolumesAPI].
+type Volumes struct {
+	Recorder
+
+	DeleteFunc         func(ctx context.Context, id string, opts ...option.RequestOption) error
+	GetFunc            func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Volume, error)
+	ListFunc           func(ctx context.Context, params hypeman.VolumeListParams, opts ...option.RequestOption) (*[]hypeman.Volume, error)
+	ListIterFunc       func(ctx context.Context, params hypeman.VolumeListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Volume, error]
+	NewFunc            func(ctx context.Context, params hypeman.VolumeNewParams, opts ...option.RequestOption) (*hypeman.Volume, error)
+	NewFromArchiveFunc func(ctx context.Context, body io.Reader, params hypeman.VolumeNewFromArchiveParams, opts ...option.RequestOption) (*hypeman.Volume, error)
+}
+
+var _ hypeman.VolumesAPI = (*Volumes)(nil)
+
+func (m *Volumes) Delete(ctx context.Context, id string, opts ...option.RequestOption) error {
+	m.record("Delete", id)
+	if m.DeleteFunc == nil {
+		return unexpected("Volumes", "Delete")
+	}
+	return m.DeleteFunc(ctx, id, opts...)
+}
+
+func (m *Volumes) Get(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Volume, error) {
+	m.record("Get", id)
+	if m.GetFunc == nil {
+		return nil, unexpected("Volumes", "Get")
+	}
+	return m.GetFunc(ctx, id, opts...)
+}
+
+func (m *Volumes) List(ctx context.Context, params hypeman.VolumeListParams, opts ...option.RequestOption) (*[]hypeman.Volume, error) {
+	m.record("List", params)
+	if m.ListFunc == nil {
+		return nil, unexpected("Volumes", "List")
+	}
+	return m.ListFunc(ctx, params, opts...)
+}
+
+func (m *Volumes) ListIter(ctx context.Context, params hypeman.VolumeListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Volume, error] {
+	m.record("ListIter", params)
+	if m.ListIterFunc == nil {
+		return func(yield func(hypeman.Volume, error) bool) {
+			yield(hypeman.Volume{}, unexpected("Volumes", "ListIter"))
+		}
+	}
+	return m.ListIterFunc(ctx, params, opts...)
+}
+
+func (m *Volumes) New(ctx context.Context, params hypeman.VolumeNewParams, opts ...option.RequestOption) (*hypeman.Volume, error) {
+	m.record("New", params)
+	if m.NewFunc == nil {
+		return nil, unexpected("Volumes", "New")
+	}
+	return m.NewFunc(ctx, params, opts...)
+}
+
+func (m *Volumes) NewFromArchive(ctx context.Context, body io.Reader, params hypeman.VolumeNewFromArchiveParams, opts ...option.RequestOption) (*hypeman.Volume, error) {
+	m.record("NewFromArchive", body, params)
+	if m.NewFromArchiveFunc == nil {
+		return nil, unexpected("Volumes", "NewFromArchive")
+	}
+	return m.NewFromArchiveFunc(ctx, body, params, opts...)
+}
+
+// Devices is a mock [hypeman.DevicesAPI].
+type Devices struct {
+	Recorder
+
+	DeleteFunc        func(ctx context.Context, id string, opts ...option.RequestOption) error
+	GetFunc           func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Device, error)
+	ListFunc          func(ctx context.Context, params hypeman.DeviceListParams, opts ...option.RequestOption) (*[]hypeman.Device, error)
+	ListAvailableFunc func(ctx context.Context, opts ...option.RequestOption) (*[]hypeman.AvailableDevice, error)
+	ListIterFunc      func(ctx context.Context, params hypeman.DeviceListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Device, error]
+	NewFunc           func(ctx context.Context, params hypeman.DeviceNewParams, opts ...option.RequestOption) (*hypeman.Device, error)
+}
+
+var _ hypeman.DevicesAPI = (*Devices)(nil)
+
+func (m *Devices) Delete(ctx context.Context, id string, opts ...option.RequestOption) error {
+	m.record("Delete", id)
+	if m.DeleteFunc == nil {
+		return unexpected("Devices", "Delete")
+	}
+	return m.DeleteFunc(ctx, id, opts...)
+}
+
+func (m *Devices) Get(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Device, error) {
+	m.record("Get", id)
+	if m.GetFunc == nil {
+		return nil, unexpected("Devices", "Get")
+	}
+	return m.GetFunc(ctx, id, opts...)
+}
+
+func (m *Devices) List(ctx context.Context, params hypeman.DeviceListParams, opts ...option.RequestOption) (*[]hypeman.Device, error) {
+	m.record("List", params)
+	if m.ListFunc == nil {
+		return nil, unexpected("Devices", "List")
+	}
+	return m.ListFunc(ctx, params, opts...)
+}
+
+func (m *Devices) ListAvailable(ctx context.Context, opts ...option.RequestOption) (*[]hypeman.AvailableDevice, error) {
+	m.record("ListAvailable")
+	if m.ListAvailableFunc == nil {
+		return nil, unexpected("Devices", "ListAvailable")
+	}
+	return m.ListAvailableFunc(ctx, opts...)
+}
+
+func (m *Devices) ListIter(ctx context.Context, params hypeman.DeviceListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Device, error] {
+	m.record("ListIter", params)
+	if m.ListIterFunc == nil {
+		return func(yield func(hypeman.Device, error) bool) {
+			yield(hypeman.Device{}, unexpected("Devices", "ListIter"))
+		}
+	}
+	return m.ListIterFunc(ctx, params, opts...)
+}
+
+func (m *Devices) New(ctx context.Context, params hypeman.DeviceNewParams, opts ...option.RequestOption) (*hypeman.Device, error) {
+	m.record("New", params)
+	if m.NewFunc == nil {
+		return nil, unexpected("Devices", "New")
+	}
+	return m.NewFunc(ctx, params, opts...)
+}
+
+// Ingresses is a mock [hypeman.IngressesAPI].
+type Ingresses struct {
+	Recorder
+
+	DeleteFunc   func(ctx context.Context, id string, opts ...option.RequestOption) error
+	GetFunc      func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Ingress, error)
+	ListFunc     func(ctx context.Context, params hypeman.IngressListParams, opts ...option.RequestOption) (*[]hypeman.Ingress, error)
+	ListIterFunc func(ctx context.Context, params hypeman.IngressListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Ingress, error]
+	NewFunc      func(ctx context.Context, params hypeman.IngressNewParams, opts ...option.RequestOption) (*hypeman.Ingress, error)
+}
+
+var _ hypeman.IngressesAPI = (*Ingresses)(nil)
+
+func (m *Ingresses) Delete(ctx context.Context, id string, opts ...option.RequestOption) error {
+	m.record("Delete", id)
+	if m.DeleteFunc == nil {
+		return unexpected("Ingresses", "Delete")
+	}
+	return m.DeleteFunc(ctx, id, opts...)
+}
+
+func (m *Ingresses) Get(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Ingress, error) {
+	m.record("Get", id)
+	if m.GetFunc == nil {
+		return nil, unexpected("Ingresses", "Get")
+	}
+	return m.GetFunc(ctx, id, opts...)
+}
+
+func (m *Ingresses) List(ctx context.Context, params hypeman.IngressListParams, opts ...option.RequestOption) (*[]hypeman.Ingress, error) {
+	m.record("List", params)
+	if m.ListFunc == nil {
+		return nil, unexpected("Ingresses", "List")
+	}
+	return m.ListFunc(ctx, params, opts...)
+}
+
+func (m *Ingresses) ListIter(ctx context.Context, params hypeman.IngressListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Ingress, error] {
+	m.record("ListIter", params)
+	if m.ListIterFunc == nil {
+		return func(yield func(hypeman.Ingress, error) bool) {
+			yield(hypeman.Ingress{}, unexpected("Ingresses", "ListIter"))
+		}
+	}
+	return m.ListIterFunc(ctx, params, opts...)
+}
+
+func (m *Ingresses) New(ctx context.Context, params hypeman.IngressNewParams, opts ...option.RequestOption) (*hypeman.Ingress, error) {
+	m.record("New", params)
+	if m.NewFunc == nil {
+		return nil, unexpected("Ingresses", "New")
+	}
+	return m.NewFunc(ctx, params, opts...)
+}
+
+// Resources is a mock [hypeman.ResourcesAPI].
+type Resources struct {
+	Recorder
+
+	GetFunc           func(ctx context.Context, opts ...option.RequestOption) (*hypeman.Resources, error)
+	ReclaimMemoryFunc func(ctx context.Context, params hypeman.ResourceReclaimMemoryParams, opts ...option.RequestOption) (*hypeman.MemoryReclaimResponse, error)
+}
+
+var _ hypeman.ResourcesAPI = (*Resources)(nil)
+
+func (m *Resources) Get(ctx context.Context, opts ...option.RequestOption) (*hypeman.Resources, error) {
+	m.record("Get")
+	if m.GetFunc == nil {
+		return nil, unexpected("Resources", "Get")
+	}
+	return m.GetFunc(ctx, opts...)
+}
+
+func (m *Resources) ReclaimMemory(ctx context.Context, params hypeman.ResourceReclaimMemoryParams, opts ...option.RequestOption) (*hypeman.MemoryReclaimResponse, error) {
+	m.record("ReclaimMemory", params)
+	if m.ReclaimMemoryFunc == nil {
+		return nil, unexpected("Resources", "ReclaimMemory")
+	}
+	return m.ReclaimMemoryFunc(ctx, params, opts...)
+}
+
+// Builds is a mock [hypeman.BuildsAPI].
+type Builds struct {
+	Recorder
+
+	CancelFunc          func(ctx context.Context, id string, opts ...option.RequestOption) error
+	EventsStreamingFunc func(ctx context.Context, id string, params hypeman.BuildEventsParams, opts ...option.RequestOption) *ssestream.Stream[hypeman.BuildEvent]
+	GetFunc             func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Build, error)
+	ListFunc            func(ctx context.Context, params hypeman.BuildListParams, opts ...option.RequestOption) (*[]hypeman.Build, error)
+	ListIterFunc        func(ctx context.Context, params hypeman.BuildListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Build, error]
+	NewFunc             func(ctx context.Context, params hypeman.BuildNewParams, opts ...option.RequestOption) (*hypeman.Build, error)
+}
+
+var _ hypeman.BuildsAPI = (*Builds)(nil)
+
+func (m *Builds) Cancel(ctx context.Context, id string, opts ...option.RequestOption) error {
+	m.record("Cancel", id)
+	if m.CancelFunc == nil {
+		return unexpected("Builds", "Cancel")
+	}
+	return m.CancelFunc(ctx, id, opts...)
+}
+
+func (m *Builds) EventsStreaming(ctx context.Context, id string, params hypeman.BuildEventsParams, opts ...option.RequestOption) *ssestream.Stream[hypeman.BuildEvent] {
+	m.record("EventsStreaming", id, params)
+	if m.EventsStreamingFunc == nil {
+		return ssestream.NewStream[hypeman.BuildEvent](nil, unexpected("Builds", "EventsStreaming"))
+	}
+	return m.EventsStreamingFunc(ctx, id, params, opts...)
+}
+
+func (m *Builds) Get(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Build, error) {
+	m.record("Get", id)
+	if m.GetFunc == nil {
+		return nil, unexpected("Builds", "Get")
+	}
+	return m.GetFunc(ctx, id, opts...)
+}
+
+func (m *Builds) List(ctx context.Context, params hypeman.BuildListParams, opts ...option.RequestOption) (*[]hypeman.Build, error) {
+	m.record("List", params)
+	if m.ListFunc == nil {
+		return nil, unexpected("Builds", "List")
+	}
+	return m.ListFunc(ctx, params, opts...)
+}
+
+func (m *Builds) ListIter(ctx context.Context, params hypeman.BuildListParams, opts ...option.RequestOption) iter.Seq2[hypeman.Build, error] {
+	m.record("ListIter", params)
+	if m.ListIterFunc == nil {
+		return func(yield func(hypeman.Build, error) bool) {
+			yield(hypeman.Build{}, unexpected("Builds", "ListIter"))
+		}
+	}
+	return m.ListIterFunc(ctx, params, opts...)
+}
+
+func (m *Builds) New(ctx context.Context, params hypeman.BuildNewParams, opts ...option.RequestOption) (*hypeman.Build, error) {
+	m.record("New", params)
+	if m.NewFunc == nil {
+		return nil, unexpected("Builds", "New")
+	}
+	return m.NewFunc(ctx, params, opts...)
+}
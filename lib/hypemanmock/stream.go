@@ -0,0 +1,80 @@
+package hypemanmock
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/kernel/hypeman-go/packages/ssestream"
+)
+
+// Stream returns a stream for the Func of a streaming method. It yields the
+// values received from events, encoded and decoded as they would be on the
+// wire, and ends when events is closed, when the stream is closed, or with
+// ctx's error when ctx is done:
+//
+//	m.Builds.EventsStreamingFunc = func(ctx context.Context, id string, params hypeman.BuildEventsParams, opts ...option.RequestOption) *ssestream.Stream[hypeman.BuildEvent] {
+//		return hypemanmock.Stream(ctx, events)
+//	}
+func Stream[T any](ctx context.Context, events <-chan T) *ssestream.Stream[T] {
+	return ssestream.NewStream[T](&chanDecoder[T]{ctx: ctx, events: events, closed: make(chan struct{})}, nil)
+}
+
+// StreamOf returns a stream for the Func of a streaming method that yields
+// values and then ends.
+func StreamOf[T any](values ...T) *ssestream.Stream[T] {
+	events := make(chan T, len(values))
+	for _, v := range values {
+		events <- v
+	}
+	close(events)
+	return Stream(context.Background(), events)
+}
+
+// chanDecoder is an ssestream.Decoder reading events from a channel
+type chanDecoder[T any] struct {
+	ctx       context.Context
+	events    <-chan T
+	closed    chan struct{}
+	closeOnce sync.Once
+	event     ssestream.Event
+	err       error
+}
+
+func (d *chanDecoder[T]) Next() bool {
+	if d.err != nil {
+		return false
+	}
+	select {
+	case <-d.closed:
+		return false
+	default:
+	}
+	select {
+	case <-d.closed:
+		return false
+	case <-d.ctx.Done():
+		d.err = d.ctx.Err()
+		return false
+	case v, ok := <-d.events:
+		if !ok {
+			return false
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			d.err = err
+			return false
+		}
+		d.event = ssestream.Event{Data: data}
+		return true
+	}
+}
+
+func (d *chanDecoder[T]) Event() ssestream.Event { return d.event }
+
+func (d *chanDecoder[T]) Err() error { return d.err }
+
+func (d *chanDecoder[T]) Close() error {
+	d.closeOnce.Do(func() { close(d.closed) })
+	return nil
+}
@@ -0,0 +1,211 @@
+package hypemantest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kernel/hypeman-go"
+)
+
+// maxBuildUpload bounds the source archive the server keeps in memory
+const maxBuildUpload = 32 << 20
+
+// CompleteBuild finishes a queued build, with Options.ManualBuilds. The build
+// fails with err's message if err is non-nil, and otherwise pushes its image.
+func (s *Server) CompleteBuild(id string, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.builds[id]
+	if !ok {
+		return fmt.Errorf("build %s not found", id)
+	}
+	if b.Status != hypeman.BuildStatusQueued {
+		return fmt.Errorf("build %s is %s, not queued", id, b.Status)
+	}
+	s.runBuild(b, "", err)
+	s.notify()
+	return nil
+}
+
+func (s *Server) createBuild(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxBuildUpload); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid form: %v", err)
+		return
+	}
+	if len(r.MultipartForm.File["source"]) == 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "source is required")
+		return
+	}
+	var tags map[string]string
+	if field := r.FormValue("tags"); field != "" {
+		if err := json.Unmarshal([]byte(field), &tags); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid tags: %v", err)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &build{
+		ID:        s.newID("build"),
+		Status:    hypeman.BuildStatusQueued,
+		CreatedAt: s.now(),
+		Tags:      tags,
+		imageName: r.FormValue("image_name"),
+	}
+	s.builds[b.ID] = b
+	// Respond with the build as queued even when it runs to completion at once
+	queued := *b
+	if !s.opts.ManualBuilds {
+		s.runBuild(b, r.FormValue("dockerfile"), nil)
+	}
+	s.notify()
+	writeJSON(w, http.StatusAccepted, queued)
+}
+
+// runBuild takes a queued build to completion, recording the events a real
+// build emits. The Dockerfile's instructions, if known, are logged as steps.
+// s.mu must be held.
+func (s *Server) runBuild(b *build, dockerfile string, err error) {
+	now := s.now()
+	b.StartedAt = ptr(now)
+	s.buildEvent(b, buildEvent{Type: hypeman.BuildEventTypeStatus, Status: hypeman.BuildStatusBuilding})
+	step := 0
+	for line := range strings.Lines(dockerfile) {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			step++
+			s.buildEvent(b, buildEvent{Type: hypeman.BuildEventTypeLog, Content: fmt.Sprintf("#%d %s", step, line)})
+		}
+	}
+
+	if err != nil {
+		s.buildEvent(b, buildEvent{Type: hypeman.BuildEventTypeLog, Content: "ERROR: " + err.Error()})
+		b.Error = ptr(err.Error())
+		s.finishBuild(b, hypeman.BuildStatusFailed)
+		return
+	}
+
+	s.buildEvent(b, buildEvent{Type: hypeman.BuildEventTypeStatus, Status: hypeman.BuildStatusPushing})
+	name := b.imageName
+	if name == "" {
+		name = "builds/" + b.ID
+	}
+	ref := s.Listener.Addr().String() + "/" + name
+	sum := sha256.Sum256([]byte(b.ID))
+	img := s.addImage(ref, b.Tags)
+	img.Digest = "sha256:" + hex.EncodeToString(sum[:])
+	b.ImageRef = ptr(ref)
+	b.ImageDigest = ptr(img.Digest)
+	s.finishBuild(b, hypeman.BuildStatusReady)
+}
+
+// finishBuild moves b to a terminal status. s.mu must be held.
+func (s *Server) finishBuild(b *build, status hypeman.BuildStatus) {
+	now := s.now()
+	b.Status = status
+	b.CompletedAt = ptr(now)
+	if b.StartedAt != nil {
+		b.DurationMs = ptr(now.Sub(*b.StartedAt).Milliseconds())
+	}
+	s.buildEvent(b, buildEvent{Type: hypeman.BuildEventTypeStatus, Status: status})
+}
+
+// buildEvent records an event for b's event stream. s.mu must be held.
+func (s *Server) buildEvent(b *build, event buildEvent) {
+	event.Timestamp = s.now()
+	if event.Type == hypeman.BuildEventTypeStatus {
+		b.Status = event.Status
+	}
+	b.events = append(b.events, event)
+}
+
+func (s *Server) listBuilds(w http.ResponseWriter, r *http.Request) {
+	tags := tagFilter(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []*build{}
+	for _, b := range sorted(s.builds) {
+		if matchTags(b.Tags, tags) {
+			list = append(list, b)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getBuild(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b := s.lookupBuild(w, r); b != nil {
+		writeJSON(w, http.StatusOK, b)
+	}
+}
+
+func (s *Server) cancelBuild(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.lookupBuild(w, r)
+	if b == nil {
+		return
+	}
+	if terminal(b.Status) {
+		writeError(w, http.StatusConflict, "invalid_state", "build %s is already %s", b.ID, b.Status)
+		return
+	}
+	s.finishBuild(b, hypeman.BuildStatusCancelled)
+	s.notify()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) buildEvents(w http.ResponseWriter, r *http.Request) {
+	follow := r.URL.Query().Get("follow") == "true"
+
+	s.mu.Lock()
+	b := s.lookupBuild(w, r)
+	s.mu.Unlock()
+	if b == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	next := 0
+	for {
+		s.mu.Lock()
+		events := b.events[next:]
+		done := terminal(b.Status)
+		changed := s.changed
+		s.mu.Unlock()
+
+		for _, event := range events {
+			next++
+			writeEvent(w, next, event)
+		}
+		w.(http.Flusher).Flush()
+		// The stream ends with the build, after its final status event
+		if !follow || done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// lookupBuild returns the build named by the request's id, or writes a 404
+// and returns nil. s.mu must be held.
+func (s *Server) lookupBuild(w http.ResponseWriter, r *http.Request) *build {
+	id := r.PathValue("id")
+	b, ok := s.builds[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "build %s not found", id)
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,264 @@
+package hypemantest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kernel/hypeman-go"
+)
+
+// cpChunkSize is the size of the binary messages file contents are sent in
+const cpChunkSize = 32 * 1024
+
+// filesystem is an instance's guest filesystem, keyed by clean absolute path.
+type filesystem struct {
+	files map[string]*file
+}
+
+type file struct {
+	data  []byte
+	mode  fs.FileMode
+	dir   bool
+	mtime time.Time
+}
+
+func newFilesystem(now time.Time) *filesystem {
+	return &filesystem{files: map[string]*file{"/": {dir: true, mode: 0o755, mtime: now}}}
+}
+
+// clone returns a deep copy of f, as forks and snapshots take
+func (f *filesystem) clone() *filesystem {
+	files := make(map[string]*file, len(f.files))
+	for name, entry := range f.files {
+		c := *entry
+		c.data = slices.Clone(entry.data)
+		files[name] = &c
+	}
+	return &filesystem{files: files}
+}
+
+func (f *filesystem) lookup(name string) (*file, bool) {
+	entry, ok := f.files[cleanPath(name)]
+	return entry, ok
+}
+
+// mkdirAll creates a directory and its parents
+func (f *filesystem) mkdirAll(name string, mode fs.FileMode, now time.Time) error {
+	name = cleanPath(name)
+	if entry, ok := f.files[name]; ok {
+		if !entry.dir {
+			return fmt.Errorf("%s: not a directory", name)
+		}
+		return nil
+	}
+	if err := f.mkdirAll(path.Dir(name), 0o755, now); err != nil {
+		return err
+	}
+	f.files[name] = &file{dir: true, mode: mode.Perm(), mtime: now}
+	return nil
+}
+
+func (f *filesystem) writeFile(name string, data []byte, mode fs.FileMode, now time.Time) error {
+	name = cleanPath(name)
+	if entry, ok := f.files[name]; ok && entry.dir {
+		return fmt.Errorf("%s: is a directory", name)
+	}
+	if err := f.mkdirAll(path.Dir(name), 0o755, now); err != nil {
+		return err
+	}
+	f.files[name] = &file{data: slices.Clone(data), mode: mode.Perm(), mtime: now}
+	return nil
+}
+
+func (f *filesystem) readFile(name string) ([]byte, error) {
+	entry, ok := f.lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	if entry.dir {
+		return nil, fmt.Errorf("%s: is a directory", name)
+	}
+	return slices.Clone(entry.data), nil
+}
+
+// walk returns name and everything below it, parents before children
+func (f *filesystem) walk(name string) []string {
+	name = cleanPath(name)
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	var names []string
+	for _, p := range slices.Sorted(maps.Keys(f.files)) {
+		if p == name || strings.HasPrefix(p, prefix) {
+			names = append(names, p)
+		}
+	}
+	return names
+}
+
+// size is the total size of the files' contents
+func (f *filesystem) size() int64 {
+	var n int64
+	for _, entry := range f.files {
+		n += int64(len(entry.data))
+	}
+	return n
+}
+
+func cleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// The cp endpoint copies one file or directory per connection, speaking the
+// protocol of lib.CpToInstance and lib.CpFromInstance.
+
+type cpRequest struct {
+	Direction string `json:"direction"`
+	GuestPath string `json:"guest_path"`
+	IsDir     bool   `json:"is_dir"`
+	Mode      uint32 `json:"mode"`
+}
+
+type cpMessage struct {
+	Type         string `json:"type"`
+	Path         string `json:"path,omitempty"`
+	Message      string `json:"message,omitempty"`
+	Mode         uint32 `json:"mode,omitempty"`
+	IsDir        bool   `json:"is_dir,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	Mtime        int64  `json:"mtime,omitempty"`
+	Final        bool   `json:"final,omitempty"`
+	Success      bool   `json:"success,omitempty"`
+	BytesWritten int64  `json:"bytes_written,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{}
+
+func (s *Server) cp(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	inst := s.lookupInstance(w, r)
+	ok := inst != nil && checkState(w, inst, "copy files with", hypeman.InstanceStateRunning)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	var req cpRequest
+	if err := ws.ReadJSON(&req); err != nil {
+		return
+	}
+	switch req.Direction {
+	case "to":
+		s.cpTo(ws, inst, req)
+	case "from":
+		s.cpFrom(ws, inst, req)
+	default:
+		ws.WriteJSON(cpMessage{Type: "error", Message: fmt.Sprintf("invalid direction %q", req.Direction)})
+	}
+}
+
+// cpTo receives a file's contents, or a directory's end marker, and writes it
+// to the guest filesystem.
+func (s *Server) cpTo(ws *websocket.Conn, inst *instance, req cpRequest) {
+	var data []byte
+	for {
+		msgType, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if msgType == websocket.BinaryMessage {
+			data = append(data, msg...)
+			continue
+		}
+		var end cpMessage
+		if json.Unmarshal(msg, &end) == nil && end.Type == "end" {
+			break
+		}
+	}
+
+	mode := fs.FileMode(req.Mode)
+	s.mu.Lock()
+	var err error
+	if req.IsDir {
+		if mode == 0 {
+			mode = 0o755
+		}
+		err = inst.fs.mkdirAll(req.GuestPath, mode, s.now())
+	} else {
+		if mode == 0 {
+			mode = 0o644
+		}
+		err = inst.fs.writeFile(req.GuestPath, data, mode, s.now())
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		ws.WriteJSON(cpMessage{Type: "error", Message: err.Error(), Path: req.GuestPath})
+		return
+	}
+	ws.WriteJSON(cpMessage{Type: "result", Success: true, BytesWritten: int64(len(data))})
+}
+
+// cpFrom sends a file, or a directory and everything below it. Paths in
+// headers are relative to the parent of the requested path, so copying
+// /app/logs yields logs, logs/a.txt and so on.
+func (s *Server) cpFrom(ws *websocket.Conn, inst *instance, req cpRequest) {
+	s.mu.Lock()
+	root := cleanPath(req.GuestPath)
+	var entries []file
+	var names []string
+	for _, name := range inst.fs.walk(root) {
+		entry := *inst.fs.files[name]
+		entry.data = slices.Clone(entry.data)
+		entries = append(entries, entry)
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+
+	if len(entries) == 0 {
+		ws.WriteJSON(cpMessage{Type: "error", Message: "no such file or directory", Path: req.GuestPath})
+		return
+	}
+	parent := path.Dir(root)
+	for i, entry := range entries {
+		rel := strings.TrimPrefix(strings.TrimPrefix(names[i], parent), "/")
+		if rel == "" {
+			rel = "."
+		}
+		header := cpMessage{
+			Type:  "header",
+			Path:  rel,
+			Mode:  uint32(entry.mode.Perm()),
+			IsDir: entry.dir,
+			Size:  int64(len(entry.data)),
+			Mtime: entry.mtime.Unix(),
+		}
+		if err := ws.WriteJSON(header); err != nil {
+			return
+		}
+		if entry.dir {
+			continue
+		}
+		for chunk := range slices.Chunk(entry.data, cpChunkSize) {
+			if err := ws.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
+				return
+			}
+		}
+		if err := ws.WriteJSON(cpMessage{Type: "end"}); err != nil {
+			return
+		}
+	}
+	ws.WriteJSON(cpMessage{Type: "end", Final: true})
+}
@@ -0,0 +1,573 @@
+package hypemantest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+)
+
+const (
+	// maxWaitTimeout is the server's cap on a single Wait call
+	maxWaitTimeout = 5 * time.Minute
+	// defaultWaitTimeout applies when Wait is called without a timeout
+	defaultWaitTimeout = 60 * time.Second
+	// defaultLogTail is the number of lines returned when tail is not given
+	defaultLogTail = 100
+)
+
+type createInstanceRequest struct {
+	Name        string                     `json:"name"`
+	Image       string                     `json:"image"`
+	Vcpus       int64                      `json:"vcpus"`
+	Size        string                     `json:"size"`
+	HotplugSize string                     `json:"hotplug_size"`
+	OverlaySize string                     `json:"overlay_size"`
+	DiskIoBps   string                     `json:"disk_io_bps"`
+	Hypervisor  hypeman.InstanceHypervisor `json:"hypervisor"`
+	Env         map[string]string          `json:"env"`
+	Tags        map[string]string          `json:"tags"`
+	AutoStandby autoStandbyPolicy          `json:"auto_standby"`
+	Cmd         []string                   `json:"cmd"`
+	Entrypoint  []string                   `json:"entrypoint"`
+	Devices     []string                   `json:"devices"`
+	Volumes     []volumeMount              `json:"volumes"`
+	Network     struct {
+		Enabled *bool `json:"enabled"`
+	} `json:"network"`
+}
+
+func (s *Server) createInstance(w http.ResponseWriter, r *http.Request) {
+	var req createInstanceRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Image == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "image is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.checkInstanceName(w, req.Name) {
+		return
+	}
+	var devices []*device
+	for _, ref := range req.Devices {
+		dev := s.findDevice(ref)
+		if dev == nil {
+			writeError(w, http.StatusNotFound, "not_found", "device %s not found", ref)
+			return
+		}
+		if dev.AttachedTo != nil {
+			writeError(w, http.StatusConflict, "conflict", "device %s is attached to instance %s", ref, *dev.AttachedTo)
+			return
+		}
+		devices = append(devices, dev)
+	}
+	for _, mount := range req.Volumes {
+		if !s.checkAttach(w, mount.VolumeID, "", mount.Readonly) {
+			return
+		}
+	}
+
+	now := s.now()
+	inst := &instance{
+		ID:               s.newID("inst"),
+		Name:             req.Name,
+		Image:            req.Image,
+		CreatedAt:        now,
+		PhaseDurationsMs: map[string]int64{},
+		Env:              req.Env,
+		Tags:             req.Tags,
+		AutoStandby:      req.AutoStandby,
+		Vcpus:            req.Vcpus,
+		Size:             req.Size,
+		HotplugSize:      req.HotplugSize,
+		OverlaySize:      req.OverlaySize,
+		DiskIoBps:        req.DiskIoBps,
+		Hypervisor:       req.Hypervisor,
+		Network:          network{Enabled: req.Network.Enabled == nil || *req.Network.Enabled},
+		cmd:              req.Cmd,
+		entrypoint:       req.Entrypoint,
+		fs:               newFilesystem(now),
+		logs:             map[hypeman.InstanceLogsParamsSource][]string{},
+	}
+	if inst.Vcpus == 0 {
+		inst.Vcpus = 2
+	}
+	if inst.Size == "" {
+		inst.Size = "1GB"
+	}
+	if inst.Hypervisor == "" {
+		inst.Hypervisor = hypeman.InstanceHypervisorCloudHypervisor
+	}
+	s.assignNetwork(inst)
+	s.instances[inst.ID] = inst
+	for _, dev := range devices {
+		dev.AttachedTo = ptr(inst.ID)
+		dev.BoundToVfio = true
+		inst.devices = append(inst.devices, dev.ID)
+	}
+	for _, mount := range req.Volumes {
+		s.attach(inst, mount)
+	}
+
+	inst.setState(hypeman.InstanceStateCreated, now)
+	s.oplog(inst, "INFO", "instance created", "image", inst.Image)
+	s.enter(inst, hypeman.InstanceStateRunning)
+	s.notify()
+	writeJSON(w, http.StatusCreated, inst.view(s.now()))
+}
+
+func (s *Server) listInstances(w http.ResponseWriter, r *http.Request) {
+	state := hypeman.InstanceState(r.URL.Query().Get("state"))
+	tags := tagFilter(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	list := []instance{}
+	for _, inst := range sorted(s.instances) {
+		if (state == "" || inst.State == state) && matchTags(inst.Tags, tags) {
+			list = append(list, inst.view(now))
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getInstance(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if inst := s.lookupInstance(w, r); inst != nil {
+		writeJSON(w, http.StatusOK, inst.view(s.now()))
+	}
+}
+
+func (s *Server) updateInstance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Env         map[string]string  `json:"env"`
+		AutoStandby *autoStandbyPolicy `json:"auto_standby"`
+	}
+	if !decodeStrictBody(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil {
+		return
+	}
+	if req.Env != nil {
+		if inst.Env == nil {
+			inst.Env = map[string]string{}
+		}
+		maps.Copy(inst.Env, req.Env)
+	}
+	if req.AutoStandby != nil {
+		inst.AutoStandby = *req.AutoStandby
+	}
+	s.notify()
+	writeJSON(w, http.StatusOK, inst.view(s.now()))
+}
+
+func (s *Server) deleteInstance(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil {
+		return
+	}
+	for _, mount := range slices.Clone(inst.Volumes) {
+		s.detach(inst, mount.VolumeID)
+	}
+	for _, id := range inst.devices {
+		if dev, ok := s.devices[id]; ok {
+			dev.AttachedTo = nil
+		}
+	}
+	delete(s.instances, inst.ID)
+	s.notify()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) startInstance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cmd        []string `json:"cmd"`
+		Entrypoint []string `json:"entrypoint"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil || !checkState(w, inst, "start", hypeman.InstanceStateStopped, hypeman.InstanceStateCreated) {
+		return
+	}
+	if req.Cmd != nil {
+		inst.cmd = req.Cmd
+	}
+	if req.Entrypoint != nil {
+		inst.entrypoint = req.Entrypoint
+	}
+	s.enter(inst, hypeman.InstanceStateRunning)
+	s.notify()
+	writeJSON(w, http.StatusOK, inst.view(s.now()))
+}
+
+func (s *Server) stopInstance(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil || !checkState(w, inst, "stop", hypeman.InstanceStateRunning, hypeman.InstanceStateStandby) {
+		return
+	}
+	wasRunning := inst.State == hypeman.InstanceStateRunning
+	s.enter(inst, hypeman.InstanceStateStopped)
+	if wasRunning {
+		// A graceful shutdown exits cleanly
+		inst.ExitCode = ptr(int64(0))
+	}
+	s.notify()
+	writeJSON(w, http.StatusOK, inst.view(s.now()))
+}
+
+func (s *Server) standbyInstance(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil || !checkState(w, inst, "put in standby", hypeman.InstanceStateRunning) {
+		return
+	}
+	s.enter(inst, hypeman.InstanceStateStandby)
+	s.notify()
+	writeJSON(w, http.StatusOK, inst.view(s.now()))
+}
+
+func (s *Server) restoreInstance(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil || !checkState(w, inst, "restore", hypeman.InstanceStateStandby) {
+		return
+	}
+	s.enter(inst, hypeman.InstanceStateRunning)
+	s.notify()
+	writeJSON(w, http.StatusOK, inst.view(s.now()))
+}
+
+func (s *Server) forkInstance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string                `json:"name"`
+		FromRunning bool                  `json:"from_running"`
+		TargetState hypeman.InstanceState `json:"target_state"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src := s.lookupInstance(w, r)
+	if src == nil || !s.checkInstanceName(w, req.Name) || !checkTargetState(w, req.TargetState) {
+		return
+	}
+	allowed := []hypeman.InstanceState{hypeman.InstanceStateStopped, hypeman.InstanceStateStandby}
+	if req.FromRunning {
+		allowed = append(allowed, hypeman.InstanceStateRunning)
+	}
+	if !checkState(w, src, "fork", allowed...) {
+		return
+	}
+	target := req.TargetState
+	if target == "" {
+		target = src.State
+	}
+	fork := s.copyInstance(src, req.Name)
+	s.oplog(fork, "INFO", "instance forked", "source_instance_id", src.ID)
+	s.oplog(src, "INFO", "instance forked", "fork_instance_id", fork.ID)
+	s.enter(fork, target)
+	s.notify()
+	writeJSON(w, http.StatusCreated, fork.view(s.now()))
+}
+
+func (s *Server) waitInstance(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	target := hypeman.InstanceState(query.Get("state"))
+	if target == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "state is required")
+		return
+	}
+	timeout := defaultWaitTimeout
+	if t := query.Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid timeout %q", t)
+			return
+		}
+		timeout = min(d, maxWaitTimeout)
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	type waitResponse struct {
+		State      hypeman.InstanceState `json:"state"`
+		TimedOut   bool                  `json:"timed_out"`
+		StateError *string               `json:"state_error"`
+	}
+	for {
+		s.mu.Lock()
+		inst := s.lookupInstance(w, r)
+		if inst == nil {
+			s.mu.Unlock()
+			return
+		}
+		resp := waitResponse{State: inst.State, StateError: inst.StateError}
+		// Return early once the target can no longer be reached without intervention
+		exited := inst.State == hypeman.InstanceStateStopped && inst.ExitCode != nil && *inst.ExitCode != 0
+		changed := s.changed
+		s.mu.Unlock()
+
+		if resp.State == target || resp.State == hypeman.InstanceStateUnknown || exited {
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			resp.TimedOut = true
+			writeJSON(w, http.StatusOK, resp)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) instanceLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	source := hypeman.InstanceLogsParamsSource(query.Get("source"))
+	switch source {
+	case "":
+		source = hypeman.InstanceLogsParamsSourceApp
+	case hypeman.InstanceLogsParamsSourceApp, hypeman.InstanceLogsParamsSourceVmm, hypeman.InstanceLogsParamsSourceHypeman:
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid source %q", source)
+		return
+	}
+	tail := defaultLogTail
+	if t := query.Get("tail"); t != "" {
+		n, err := strconv.Atoi(t)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid tail %q", t)
+			return
+		}
+		tail = n
+	}
+	follow := query.Get("follow") == "true"
+
+	s.mu.Lock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil {
+		s.mu.Unlock()
+		return
+	}
+	// Event IDs are line numbers, so a reconnecting client resumes after the
+	// last line it received
+	next := max(len(inst.logs[source])-tail, 0)
+	if id, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		next = min(max(id, 0), len(inst.logs[source]))
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	for {
+		s.mu.Lock()
+		inst, ok := s.instances[r.PathValue("id")]
+		var lines []string
+		if ok {
+			lines = slices.Clone(inst.logs[source][next:])
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		for _, line := range lines {
+			next++
+			writeEvent(w, next, line)
+		}
+		w.(http.Flusher).Flush()
+		if !ok || !follow {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) statPath(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("path")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "path is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil || !checkState(w, inst, "stat a path in", hypeman.InstanceStateRunning) {
+		return
+	}
+	type pathInfo struct {
+		Exists bool  `json:"exists"`
+		IsDir  bool  `json:"is_dir"`
+		IsFile bool  `json:"is_file"`
+		Mode   int64 `json:"mode"`
+		Size   int64 `json:"size"`
+	}
+	f, ok := inst.fs.lookup(name)
+	if !ok {
+		writeJSON(w, http.StatusOK, pathInfo{})
+		return
+	}
+	writeJSON(w, http.StatusOK, pathInfo{
+		Exists: true,
+		IsDir:  f.dir,
+		IsFile: !f.dir,
+		Mode:   int64(f.mode.Perm()),
+		Size:   int64(len(f.data)),
+	})
+}
+
+// enter moves inst to state, as the end of a transition. s.mu must be held.
+func (s *Server) enter(inst *instance, state hypeman.InstanceState) {
+	now := s.now()
+	inst.setState(state, now)
+	switch state {
+	case hypeman.InstanceStateRunning:
+		if !inst.HasSnapshot {
+			inst.StartedAt = ptr(now)
+			inst.StoppedAt = nil
+			inst.ExitCode = nil
+			inst.ExitMessage = ""
+			inst.StateError = nil
+		}
+		inst.HasSnapshot = false
+		s.oplog(inst, "INFO", "instance running")
+	case hypeman.InstanceStateStandby:
+		inst.HasSnapshot = true
+		s.oplog(inst, "INFO", "instance in standby")
+	case hypeman.InstanceStateStopped:
+		inst.HasSnapshot = false
+		inst.StoppedAt = ptr(now)
+		s.oplog(inst, "INFO", "instance stopped")
+	}
+}
+
+// copyInstance creates a new instance with src's configuration and a copy of
+// its filesystem, in the Created state. Volumes and devices are not carried
+// over. s.mu must be held.
+func (s *Server) copyInstance(src *instance, name string) *instance {
+	now := s.now()
+	inst := &instance{
+		ID:               s.newID("inst"),
+		Name:             name,
+		Image:            src.Image,
+		CreatedAt:        now,
+		PhaseDurationsMs: map[string]int64{},
+		Env:              maps.Clone(src.Env),
+		Tags:             maps.Clone(src.Tags),
+		Vcpus:            src.Vcpus,
+		Size:             src.Size,
+		HotplugSize:      src.HotplugSize,
+		OverlaySize:      src.OverlaySize,
+		DiskIoBps:        src.DiskIoBps,
+		Hypervisor:       src.Hypervisor,
+		Network:          network{Enabled: src.Network.Enabled},
+		cmd:              slices.Clone(src.cmd),
+		entrypoint:       slices.Clone(src.entrypoint),
+		fs:               src.fs.clone(),
+		logs:             map[hypeman.InstanceLogsParamsSource][]string{},
+	}
+	s.assignNetwork(inst)
+	inst.setState(hypeman.InstanceStateCreated, now)
+	s.instances[inst.ID] = inst
+	return inst
+}
+
+// assignNetwork gives inst an address if networking is enabled. s.mu must be held.
+func (s *Server) assignNetwork(inst *instance) {
+	if !inst.Network.Enabled {
+		return
+	}
+	n := s.seq
+	inst.Network.IP = ptr(fmt.Sprintf("10.100.%d.%d", n/250, n%250+2))
+	inst.Network.Mac = ptr(fmt.Sprintf("02:00:00:00:%02x:%02x", n/256%256, n%256))
+	inst.Network.Name = "default"
+}
+
+// lookupInstance returns the instance named by the request's id, or writes a
+// 404 and returns nil. s.mu must be held.
+func (s *Server) lookupInstance(w http.ResponseWriter, r *http.Request) *instance {
+	id := r.PathValue("id")
+	inst, ok := s.instances[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "instance %s not found", id)
+		return nil
+	}
+	return inst
+}
+
+// checkInstanceName validates a new instance name, writing a 400 or 409 if
+// it is invalid or taken. s.mu must be held.
+func (s *Server) checkInstanceName(w http.ResponseWriter, name string) bool {
+	if !namePattern.MatchString(name) {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid name %q: use lowercase letters, digits and dashes", name)
+		return false
+	}
+	for _, inst := range s.instances {
+		if inst.Name == name {
+			writeError(w, http.StatusConflict, "already_exists", "instance named %s already exists", name)
+			return false
+		}
+	}
+	return true
+}
+
+// checkState writes a 409 unless inst is in one of states
+func checkState(w http.ResponseWriter, inst *instance, action string, states ...hypeman.InstanceState) bool {
+	if slices.Contains(states, inst.State) {
+		return true
+	}
+	writeError(w, http.StatusConflict, "invalid_state", "cannot %s instance %s in state %s", action, inst.ID, inst.State)
+	return false
+}
+
+// checkTargetState writes a 400 unless state is empty or a valid fork or restore target
+func checkTargetState(w http.ResponseWriter, state hypeman.InstanceState) bool {
+	switch state {
+	case "", hypeman.InstanceStateStopped, hypeman.InstanceStateStandby, hypeman.InstanceStateRunning:
+		return true
+	}
+	writeError(w, http.StatusBadRequest, "bad_request", "invalid target state %q", state)
+	return false
+}
+
+// writeEvent writes one server-sent event with a JSON data payload
+func writeEvent(w http.ResponseWriter, id int, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, data)
+}
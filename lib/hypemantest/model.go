@@ -0,0 +1,225 @@
+package hypemantest
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+)
+
+// The types below are the server's records and their JSON encoding. They are
+// separate from the SDK's response types so that nullable fields are sent as
+// null, as the real server does, rather than as zero values.
+
+type instance struct {
+	ID                string                     `json:"id"`
+	Name              string                     `json:"name"`
+	Image             string                     `json:"image"`
+	State             hypeman.InstanceState      `json:"state"`
+	CreatedAt         time.Time                  `json:"created_at"`
+	StartedAt         *time.Time                 `json:"started_at"`
+	StoppedAt         *time.Time                 `json:"stopped_at"`
+	ExitCode          *int64                     `json:"exit_code"`
+	ExitMessage       string                     `json:"exit_message,omitempty"`
+	StateError        *string                    `json:"state_error"`
+	HasSnapshot       bool                       `json:"has_snapshot"`
+	CurrentPhase      string                     `json:"current_phase"`
+	CurrentPhaseSince time.Time                  `json:"current_phase_since"`
+	PhaseDurationsMs  map[string]int64           `json:"phase_durations_ms"`
+	Env               map[string]string          `json:"env"`
+	Tags              map[string]string          `json:"tags"`
+	AutoStandby       autoStandbyPolicy          `json:"auto_standby"`
+	Vcpus             int64                      `json:"vcpus"`
+	Size              string                     `json:"size"`
+	HotplugSize       string                     `json:"hotplug_size,omitempty"`
+	OverlaySize       string                     `json:"overlay_size,omitempty"`
+	DiskIoBps         string                     `json:"disk_io_bps,omitempty"`
+	Hypervisor        hypeman.InstanceHypervisor `json:"hypervisor"`
+	Network           network                    `json:"network"`
+	Volumes           []volumeMount              `json:"volumes"`
+
+	cmd        []string
+	entrypoint []string
+	devices    []string // IDs of attached devices
+	fs         *filesystem
+	logs       map[hypeman.InstanceLogsParamsSource][]string
+}
+
+type autoStandbyPolicy struct {
+	Enabled                bool     `json:"enabled"`
+	IdleTimeout            string   `json:"idle_timeout,omitempty"`
+	IgnoreDestinationPorts []int64  `json:"ignore_destination_ports,omitempty"`
+	IgnoreSourceCidrs      []string `json:"ignore_source_cidrs,omitempty"`
+}
+
+type network struct {
+	Enabled bool    `json:"enabled"`
+	IP      *string `json:"ip"`
+	Mac     *string `json:"mac"`
+	Name    string  `json:"name,omitempty"`
+}
+
+type volumeMount struct {
+	VolumeID  string `json:"volume_id"`
+	MountPath string `json:"mount_path"`
+	Readonly  bool   `json:"readonly"`
+}
+
+// setState moves inst to state, accruing the time spent in the previous phase.
+func (inst *instance) setState(state hypeman.InstanceState, now time.Time) {
+	inst.accrue(now)
+	inst.State = state
+	inst.CurrentPhase = strings.ToLower(string(state))
+	inst.CurrentPhaseSince = now
+}
+
+// accrue adds the time spent in the current phase up to now to its duration.
+func (inst *instance) accrue(now time.Time) {
+	if inst.CurrentPhase != "" {
+		inst.PhaseDurationsMs[inst.CurrentPhase] += now.Sub(inst.CurrentPhaseSince).Milliseconds()
+	}
+	inst.CurrentPhaseSince = now
+}
+
+// view returns a copy of inst to encode, with phase durations up to now.
+func (inst *instance) view(now time.Time) instance {
+	v := *inst
+	v.PhaseDurationsMs = maps.Clone(inst.PhaseDurationsMs)
+	if v.CurrentPhase != "" {
+		v.PhaseDurationsMs[v.CurrentPhase] += now.Sub(v.CurrentPhaseSince).Milliseconds()
+	}
+	v.Volumes = slices.Clone(inst.Volumes)
+	return v
+}
+
+type snapshot struct {
+	ID                 string                           `json:"id"`
+	Name               *string                          `json:"name"`
+	Kind               hypeman.SnapshotKind             `json:"kind"`
+	CreatedAt          time.Time                        `json:"created_at"`
+	SizeBytes          int64                            `json:"size_bytes"`
+	SourceHypervisor   hypeman.InstanceHypervisor       `json:"source_hypervisor"`
+	SourceInstanceID   string                           `json:"source_instance_id"`
+	SourceInstanceName string                           `json:"source_instance_name"`
+	CompressionState   hypeman.SnapshotCompressionState `json:"compression_state"`
+	Tags               map[string]string                `json:"tags"`
+
+	source *instance // copy of the source instance when the snapshot was taken
+}
+
+type volume struct {
+	ID          string             `json:"id"`
+	Name        string             `json:"name"`
+	SizeGB      int64              `json:"size_gb"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Attachments []volumeAttachment `json:"attachments"`
+	Tags        map[string]string  `json:"tags"`
+}
+
+type volumeAttachment struct {
+	InstanceID string `json:"instance_id"`
+	MountPath  string `json:"mount_path"`
+	Readonly   bool   `json:"readonly"`
+}
+
+type ingress struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	CreatedAt time.Time         `json:"created_at"`
+	Rules     []ingressRule     `json:"rules"`
+	Tags      map[string]string `json:"tags"`
+}
+
+type ingressRule struct {
+	Match struct {
+		Hostname string `json:"hostname"`
+		Port     int64  `json:"port,omitempty"`
+	} `json:"match"`
+	Target struct {
+		Instance string `json:"instance"`
+		Port     int64  `json:"port"`
+	} `json:"target"`
+	RedirectHTTP bool `json:"redirect_http"`
+	Tls          bool `json:"tls"`
+}
+
+type image struct {
+	Name      string              `json:"name"`
+	Digest    string              `json:"digest"`
+	Status    hypeman.ImageStatus `json:"status"`
+	CreatedAt time.Time           `json:"created_at"`
+	SizeBytes *int64              `json:"size_bytes"`
+	Error     *string             `json:"error"`
+	Tags      map[string]string   `json:"tags"`
+}
+
+type device struct {
+	ID          string             `json:"id"`
+	Name        string             `json:"name"`
+	Type        hypeman.DeviceType `json:"type"`
+	PciAddress  string             `json:"pci_address"`
+	VendorID    string             `json:"vendor_id"`
+	DeviceID    string             `json:"device_id"`
+	IommuGroup  int64              `json:"iommu_group"`
+	BoundToVfio bool               `json:"bound_to_vfio"`
+	AttachedTo  *string            `json:"attached_to"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Tags        map[string]string  `json:"tags"`
+}
+
+type availableDevice struct {
+	PciAddress    string  `json:"pci_address"`
+	VendorID      string  `json:"vendor_id"`
+	DeviceID      string  `json:"device_id"`
+	IommuGroup    int64   `json:"iommu_group"`
+	CurrentDriver *string `json:"current_driver"`
+	VendorName    string  `json:"vendor_name,omitempty"`
+	DeviceName    string  `json:"device_name,omitempty"`
+}
+
+type build struct {
+	ID            string              `json:"id"`
+	Status        hypeman.BuildStatus `json:"status"`
+	CreatedAt     time.Time           `json:"created_at"`
+	StartedAt     *time.Time          `json:"started_at"`
+	CompletedAt   *time.Time          `json:"completed_at"`
+	DurationMs    *int64              `json:"duration_ms"`
+	Error         *string             `json:"error"`
+	ImageDigest   *string             `json:"image_digest"`
+	ImageRef      *string             `json:"image_ref"`
+	QueuePosition *int64              `json:"queue_position"`
+	Tags          map[string]string   `json:"tags"`
+
+	imageName string
+	events    []buildEvent
+}
+
+type buildEvent struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Type      hypeman.BuildEventType `json:"type"`
+	Content   string                 `json:"content,omitempty"`
+	Status    hypeman.BuildStatus    `json:"status,omitempty"`
+}
+
+// terminal reports whether a build in status will not change again
+func terminal(status hypeman.BuildStatus) bool {
+	switch status {
+	case hypeman.BuildStatusReady, hypeman.BuildStatusFailed, hypeman.BuildStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// matchTags reports whether tags has every key/value pair in filter
+func matchTags(tags, filter map[string]string) bool {
+	for k, v := range filter {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,453 @@
+package hypemantest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/kernel/hypeman-go"
+)
+
+type createVolumeRequest struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	SizeGB int64             `json:"size_gb"`
+	Tags   map[string]string `json:"tags"`
+}
+
+func (s *Server) createVolume(w http.ResponseWriter, r *http.Request) {
+	var req createVolumeRequest
+	if decodeBody(w, r, &req) {
+		s.addVolume(w, req)
+	}
+}
+
+// createVolumeFromArchive accepts and discards the archive, since volume
+// contents are not modelled.
+func (s *Server) createVolumeFromArchive(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	size, err := strconv.ParseInt(query.Get("size_gb"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid size_gb %q", query.Get("size_gb"))
+		return
+	}
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "read archive: %v", err)
+		return
+	}
+	s.addVolume(w, createVolumeRequest{ID: query.Get("id"), Name: query.Get("name"), SizeGB: size, Tags: tagFilter(r)})
+}
+
+func (s *Server) addVolume(w http.ResponseWriter, req createVolumeRequest) {
+	if req.Name == "" || req.SizeGB <= 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "name and a positive size_gb are required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, vol := range s.volumes {
+		if vol.ID == req.ID || vol.Name == req.Name {
+			writeError(w, http.StatusConflict, "already_exists", "volume %s already exists", vol.ID)
+			return
+		}
+	}
+	vol := &volume{
+		ID:          req.ID,
+		Name:        req.Name,
+		SizeGB:      req.SizeGB,
+		CreatedAt:   s.now(),
+		Attachments: []volumeAttachment{},
+		Tags:        req.Tags,
+	}
+	if vol.ID == "" {
+		vol.ID = s.newID("vol")
+	}
+	s.volumes[vol.ID] = vol
+	writeJSON(w, http.StatusCreated, vol)
+}
+
+func (s *Server) listVolumes(w http.ResponseWriter, r *http.Request) {
+	tags := tagFilter(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []*volume{}
+	for _, vol := range sorted(s.volumes) {
+		if matchTags(vol.Tags, tags) {
+			list = append(list, vol)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getVolume(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := r.PathValue("id")
+	vol, ok := s.volumes[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "volume %s not found", id)
+		return
+	}
+	writeJSON(w, http.StatusOK, vol)
+}
+
+func (s *Server) deleteVolume(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := r.PathValue("id")
+	vol, ok := s.volumes[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "volume %s not found", id)
+		return
+	}
+	if len(vol.Attachments) > 0 {
+		writeError(w, http.StatusConflict, "conflict", "volume %s is attached to instance %s", id, vol.Attachments[0].InstanceID)
+		return
+	}
+	delete(s.volumes, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) attachVolume(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MountPath string `json:"mount_path"`
+		Readonly  bool   `json:"readonly"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.MountPath == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "mount_path is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil || !s.checkAttach(w, r.PathValue("volumeId"), inst.ID, req.Readonly) {
+		return
+	}
+	s.attach(inst, volumeMount{VolumeID: r.PathValue("volumeId"), MountPath: req.MountPath, Readonly: req.Readonly})
+	s.oplog(inst, "INFO", "volume attached", "volume_id", r.PathValue("volumeId"))
+	writeJSON(w, http.StatusOK, inst.view(s.now()))
+}
+
+func (s *Server) detachVolume(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil {
+		return
+	}
+	id := r.PathValue("volumeId")
+	if !slices.ContainsFunc(inst.Volumes, func(m volumeMount) bool { return m.VolumeID == id }) {
+		writeError(w, http.StatusNotFound, "not_found", "volume %s is not attached to instance %s", id, inst.ID)
+		return
+	}
+	s.detach(inst, id)
+	s.oplog(inst, "INFO", "volume detached", "volume_id", id)
+	writeJSON(w, http.StatusOK, inst.view(s.now()))
+}
+
+// checkAttach writes a 404 or 409 unless the volume exists and can be
+// mounted by the instance: a volume is either mounted read-write by one
+// instance or read-only by any number. s.mu must be held.
+func (s *Server) checkAttach(w http.ResponseWriter, volumeID, instanceID string, readonly bool) bool {
+	vol, ok := s.volumes[volumeID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "volume %s not found", volumeID)
+		return false
+	}
+	for _, a := range vol.Attachments {
+		if a.InstanceID == instanceID {
+			writeError(w, http.StatusConflict, "conflict", "volume %s is already attached to instance %s", volumeID, instanceID)
+			return false
+		}
+		if !a.Readonly || !readonly {
+			writeError(w, http.StatusConflict, "conflict", "volume %s is attached to instance %s", volumeID, a.InstanceID)
+			return false
+		}
+	}
+	return true
+}
+
+// attach mounts a volume that checkAttach accepted. s.mu must be held.
+func (s *Server) attach(inst *instance, mount volumeMount) {
+	inst.Volumes = append(inst.Volumes, mount)
+	vol := s.volumes[mount.VolumeID]
+	vol.Attachments = append(vol.Attachments, volumeAttachment{InstanceID: inst.ID, MountPath: mount.MountPath, Readonly: mount.Readonly})
+}
+
+// detach unmounts a volume from inst. s.mu must be held.
+func (s *Server) detach(inst *instance, volumeID string) {
+	inst.Volumes = slices.DeleteFunc(inst.Volumes, func(m volumeMount) bool { return m.VolumeID == volumeID })
+	if vol, ok := s.volumes[volumeID]; ok {
+		vol.Attachments = slices.DeleteFunc(vol.Attachments, func(a volumeAttachment) bool { return a.InstanceID == inst.ID })
+	}
+}
+
+func (s *Server) createIngress(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name  string            `json:"name"`
+		Rules []ingressRule     `json:"rules"`
+		Tags  map[string]string `json:"tags"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Name == "" || len(req.Rules) == 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "name and at least one rule are required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ing := range s.ingresses {
+		if ing.Name == req.Name {
+			writeError(w, http.StatusConflict, "already_exists", "ingress named %s already exists", req.Name)
+			return
+		}
+		for _, rule := range ing.Rules {
+			for _, newRule := range req.Rules {
+				if rule.Match == newRule.Match {
+					writeError(w, http.StatusConflict, "conflict", "hostname %s is already routed by ingress %s", rule.Match.Hostname, ing.ID)
+					return
+				}
+			}
+		}
+	}
+	ing := &ingress{
+		ID:        s.newID("ing"),
+		Name:      req.Name,
+		CreatedAt: s.now(),
+		Rules:     req.Rules,
+		Tags:      req.Tags,
+	}
+	s.ingresses[ing.ID] = ing
+	writeJSON(w, http.StatusCreated, ing)
+}
+
+func (s *Server) listIngresses(w http.ResponseWriter, r *http.Request) {
+	tags := tagFilter(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []*ingress{}
+	for _, ing := range sorted(s.ingresses) {
+		if matchTags(ing.Tags, tags) {
+			list = append(list, ing)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getIngress(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ing := s.findIngress(r.PathValue("id")); ing != nil {
+		writeJSON(w, http.StatusOK, ing)
+		return
+	}
+	writeError(w, http.StatusNotFound, "not_found", "ingress %s not found", r.PathValue("id"))
+}
+
+func (s *Server) deleteIngress(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ing := s.findIngress(r.PathValue("id")); ing != nil {
+		delete(s.ingresses, ing.ID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeError(w, http.StatusNotFound, "not_found", "ingress %s not found", r.PathValue("id"))
+}
+
+// findIngress looks an ingress up by ID or name. s.mu must be held.
+func (s *Server) findIngress(ref string) *ingress {
+	for _, ing := range s.ingresses {
+		if ing.ID == ref || ing.Name == ref {
+			return ing
+		}
+	}
+	return nil
+}
+
+func (s *Server) createImage(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string            `json:"name"`
+		Tags map[string]string `json:"tags"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "name is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if img, ok := s.images[req.Name]; ok {
+		writeJSON(w, http.StatusOK, img)
+		return
+	}
+	img := *s.addImage(req.Name, req.Tags)
+	// The pull completes instantly, but like the real server the response
+	// reports the pull as queued
+	img.Status = hypeman.ImageStatusPending
+	writeJSON(w, http.StatusAccepted, img)
+}
+
+func (s *Server) listImages(w http.ResponseWriter, r *http.Request) {
+	tags := tagFilter(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []*image{}
+	for _, img := range s.images {
+		if matchTags(img.Tags, tags) {
+			list = append(list, img)
+		}
+	}
+	slices.SortFunc(list, func(a, b *image) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getImage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := r.PathValue("name")
+	img, ok := s.images[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "image %s not found", name)
+		return
+	}
+	writeJSON(w, http.StatusOK, img)
+}
+
+func (s *Server) deleteImage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := r.PathValue("name")
+	if _, ok := s.images[name]; !ok {
+		writeError(w, http.StatusNotFound, "not_found", "image %s not found", name)
+		return
+	}
+	delete(s.images, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addImage records a ready image. s.mu must be held.
+func (s *Server) addImage(name string, tags map[string]string) *image {
+	sum := sha256.Sum256([]byte(name))
+	img := &image{
+		Name:      name,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Status:    hypeman.ImageStatusReady,
+		CreatedAt: s.now(),
+		SizeBytes: ptr(int64(64 << 20)),
+		Tags:      tags,
+	}
+	s.images[name] = img
+	return img
+}
+
+func (s *Server) createDevice(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PciAddress string            `json:"pci_address"`
+		Name       string            `json:"name"`
+		Tags       map[string]string `json:"tags"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.available, func(d availableDevice) bool { return d.PciAddress == req.PciAddress })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "no device at PCI address %s", req.PciAddress)
+		return
+	}
+	for _, dev := range s.devices {
+		if dev.PciAddress == req.PciAddress || (req.Name != "" && dev.Name == req.Name) {
+			writeError(w, http.StatusConflict, "already_exists", "device %s is already registered", dev.ID)
+			return
+		}
+	}
+	avail := s.available[i]
+	dev := &device{
+		ID:         s.newID("dev"),
+		Name:       req.Name,
+		Type:       hypeman.DeviceTypePci,
+		PciAddress: avail.PciAddress,
+		VendorID:   avail.VendorID,
+		DeviceID:   avail.DeviceID,
+		IommuGroup: avail.IommuGroup,
+		CreatedAt:  s.now(),
+		Tags:       req.Tags,
+	}
+	// NVIDIA's vendor ID; other GPUs are registered as plain PCI devices
+	if avail.VendorID == "10de" {
+		dev.Type = hypeman.DeviceTypeGPU
+	}
+	s.devices[dev.ID] = dev
+	writeJSON(w, http.StatusCreated, dev)
+}
+
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request) {
+	tags := tagFilter(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []*device{}
+	for _, dev := range sorted(s.devices) {
+		if matchTags(dev.Tags, tags) {
+			list = append(list, dev)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) listAvailableDevices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, append([]availableDevice{}, s.available...))
+}
+
+func (s *Server) getDevice(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dev := s.findDevice(r.PathValue("id")); dev != nil {
+		writeJSON(w, http.StatusOK, dev)
+		return
+	}
+	writeError(w, http.StatusNotFound, "not_found", "device %s not found", r.PathValue("id"))
+}
+
+func (s *Server) deleteDevice(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dev := s.findDevice(r.PathValue("id"))
+	if dev == nil {
+		writeError(w, http.StatusNotFound, "not_found", "device %s not found", r.PathValue("id"))
+		return
+	}
+	if dev.AttachedTo != nil {
+		writeError(w, http.StatusConflict, "conflict", "device %s is attached to instance %s", dev.ID, *dev.AttachedTo)
+		return
+	}
+	delete(s.devices, dev.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findDevice looks a device up by ID or name. s.mu must be held.
+func (s *Server) findDevice(ref string) *device {
+	for _, dev := range s.devices {
+		if dev.ID == ref || (dev.Name != "" && dev.Name == ref) {
+			return dev
+		}
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+// Package hypemantest provides an in-process fake of the Hypeman API for
+// tests. It keeps instances, snapshots, volumes, ingresses, images, builds and
+// devices in memory and moves them through the same state transitions as the
+// real server, so orchestration code can be exercised with the real client.
+//
+// Example:
+//
+//	srv := hypemantest.NewServer(hypemantest.Options{})
+//	defer srv.Close()
+//	client := srv.Client()
+//	inst, _ := client.Instances.New(ctx, hypeman.InstanceNewParams{Name: "web", Image: "nginx:alpine"})
+//	srv.Crash(inst.ID, 137, "killed")
+package hypemantest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/option"
+)
+
+// Options configures a Server
+type Options struct {
+	// Optional: API key clients must send as a bearer token. When empty, any key is accepted.
+	APIKey string
+	// Optional: leave new builds queued until CompleteBuild is called, instead
+	// of completing them as soon as they are created
+	ManualBuilds bool
+}
+
+// Server is a fake Hypeman API server. It is safe for concurrent use.
+//
+// Instances start Running as soon as they are created, images are pulled
+// instantly and builds complete as soon as they are created (see
+// Options.ManualBuilds). Images do not need to be created before instances
+// use them. Each instance has an in-memory guest filesystem, reachable through
+// Stat and the cp endpoint, which is copied by forks and snapshots.
+type Server struct {
+	*httptest.Server
+	opts Options
+	now  func() time.Time
+
+	mu        sync.Mutex
+	changed   chan struct{} // closed and replaced whenever state changes
+	seq       int
+	instances map[string]*instance
+	snapshots map[string]*snapshot
+	volumes   map[string]*volume
+	ingresses map[string]*ingress
+	images    map[string]*image
+	devices   map[string]*device
+	available []availableDevice
+	builds    map[string]*build
+}
+
+// NewServer starts a Server. Close it when done.
+func NewServer(opts Options) *Server {
+	s := &Server{
+		opts:      opts,
+		now:       time.Now,
+		changed:   make(chan struct{}),
+		instances: map[string]*instance{},
+		snapshots: map[string]*snapshot{},
+		volumes:   map[string]*volume{},
+		ingresses: map[string]*ingress{},
+		images:    map[string]*image{},
+		devices:   map[string]*device{},
+		builds:    map[string]*build{},
+	}
+	s.Server = httptest.NewUnstartedServer(s.handler())
+	s.Start()
+	return s
+}
+
+// Client returns a client for the server with retries disabled. opts are
+// applied after the defaults.
+func (s *Server) Client(opts ...option.RequestOption) *hypeman.Client {
+	apiKey := s.opts.APIKey
+	if apiKey == "" {
+		apiKey = "test"
+	}
+	defaults := []option.RequestOption{
+		option.WithBaseURL(s.URL),
+		option.WithAPIKey(apiKey),
+		option.WithMaxRetries(0),
+	}
+	client := hypeman.NewClient(append(defaults, opts...)...)
+	return &client
+}
+
+// AddImage adds a ready image, as if it had been pulled.
+func (s *Server) AddImage(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addImage(name, nil)
+}
+
+// AddAvailableDevice makes a host PCI device available for registration
+// with Devices.New.
+func (s *Server) AddAvailableDevice(dev hypeman.AvailableDevice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := availableDevice{
+		PciAddress: dev.PciAddress,
+		VendorID:   dev.VendorID,
+		DeviceID:   dev.DeviceID,
+		IommuGroup: dev.IommuGroup,
+		VendorName: dev.VendorName,
+		DeviceName: dev.DeviceName,
+	}
+	if dev.CurrentDriver != "" {
+		d.CurrentDriver = ptr(dev.CurrentDriver)
+	}
+	s.available = append(s.available, d)
+}
+
+// Crash stops a running instance as if its guest exited with code, e.g. 137
+// with message "killed by signal 9" or "OOM killed".
+func (s *Server) Crash(id string, code int, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst, ok := s.instances[id]
+	if !ok {
+		return fmt.Errorf("instance %s not found", id)
+	}
+	if inst.State != hypeman.InstanceStateRunning {
+		return fmt.Errorf("instance %s is %s, not Running", id, inst.State)
+	}
+	now := s.now()
+	inst.setState(hypeman.InstanceStateStopped, now)
+	inst.StoppedAt = ptr(now)
+	inst.ExitCode = ptr(int64(code))
+	inst.ExitMessage = message
+	s.oplog(inst, "ERROR", "instance exited", "exit_code", code)
+	s.notify()
+	return nil
+}
+
+// WriteLog appends lines to an instance's log for source, waking any
+// followers.
+func (s *Server) WriteLog(id string, source hypeman.InstanceLogsParamsSource, lines ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst, ok := s.instances[id]
+	if !ok {
+		return fmt.Errorf("instance %s not found", id)
+	}
+	inst.logs[source] = append(inst.logs[source], lines...)
+	s.notify()
+	return nil
+}
+
+// WriteFile writes a file to an instance's guest filesystem, creating its
+// parent directories.
+func (s *Server) WriteFile(id, name string, data []byte, mode fs.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst, ok := s.instances[id]
+	if !ok {
+		return fmt.Errorf("instance %s not found", id)
+	}
+	return inst.fs.writeFile(name, data, mode, s.now())
+}
+
+// ReadFile reads a file from an instance's guest filesystem.
+func (s *Server) ReadFile(id, name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst, ok := s.instances[id]
+	if !ok {
+		return nil, fmt.Errorf("instance %s not found", id)
+	}
+	return inst.fs.readFile(name)
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	mux.HandleFunc("POST /instances", s.createInstance)
+	mux.HandleFunc("GET /instances", s.listInstances)
+	mux.HandleFunc("GET /instances/{id}", s.getInstance)
+	mux.HandleFunc("PATCH /instances/{id}", s.updateInstance)
+	mux.HandleFunc("DELETE /instances/{id}", s.deleteInstance)
+	mux.HandleFunc("POST /instances/{id}/start", s.startInstance)
+	mux.HandleFunc("POST /instances/{id}/stop", s.stopInstance)
+	mux.HandleFunc("POST /instances/{id}/standby", s.standbyInstance)
+	mux.HandleFunc("POST /instances/{id}/restore", s.restoreInstance)
+	mux.HandleFunc("POST /instances/{id}/fork", s.forkInstance)
+	mux.HandleFunc("GET /instances/{id}/wait", s.waitInstance)
+	mux.HandleFunc("GET /instances/{id}/logs", s.instanceLogs)
+	mux.HandleFunc("GET /instances/{id}/stat", s.statPath)
+	mux.HandleFunc("GET /instances/{id}/cp", s.cp)
+	mux.HandleFunc("POST /instances/{id}/volumes/{volumeId}", s.attachVolume)
+	mux.HandleFunc("DELETE /instances/{id}/volumes/{volumeId}", s.detachVolume)
+	mux.HandleFunc("POST /instances/{id}/snapshots", s.createSnapshot)
+	mux.HandleFunc("POST /instances/{id}/snapshots/{snapshotId}/restore", s.restoreSnapshot)
+
+	mux.HandleFunc("GET /snapshots", s.listSnapshots)
+	mux.HandleFunc("GET /snapshots/{snapshotId}", s.getSnapshot)
+	mux.HandleFunc("DELETE /snapshots/{snapshotId}", s.deleteSnapshot)
+	mux.HandleFunc("POST /snapshots/{snapshotId}/fork", s.forkSnapshot)
+
+	mux.HandleFunc("POST /volumes", s.createVolume)
+	mux.HandleFunc("POST /volumes/from-archive", s.createVolumeFromArchive)
+	mux.HandleFunc("GET /volumes", s.listVolumes)
+	mux.HandleFunc("GET /volumes/{id}", s.getVolume)
+	mux.HandleFunc("DELETE /volumes/{id}", s.deleteVolume)
+
+	mux.HandleFunc("POST /ingresses", s.createIngress)
+	mux.HandleFunc("GET /ingresses", s.listIngresses)
+	mux.HandleFunc("GET /ingresses/{id}", s.getIngress)
+	mux.HandleFunc("DELETE /ingresses/{id}", s.deleteIngress)
+
+	mux.HandleFunc("POST /images", s.createImage)
+	mux.HandleFunc("GET /images", s.listImages)
+	mux.HandleFunc("GET /images/{name...}", s.getImage)
+	mux.HandleFunc("DELETE /images/{name...}", s.deleteImage)
+
+	mux.HandleFunc("POST /devices", s.createDevice)
+	mux.HandleFunc("GET /devices", s.listDevices)
+	mux.HandleFunc("GET /devices/available", s.listAvailableDevices)
+	mux.HandleFunc("GET /devices/{id}", s.getDevice)
+	mux.HandleFunc("DELETE /devices/{id}", s.deleteDevice)
+
+	mux.HandleFunc("POST /builds", s.createBuild)
+	mux.HandleFunc("GET /builds", s.listBuilds)
+	mux.HandleFunc("GET /builds/{id}", s.getBuild)
+	mux.HandleFunc("DELETE /builds/{id}", s.cancelBuild)
+	mux.HandleFunc("GET /builds/{id}/events", s.buildEvents)
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found", "no route for %s %s", r.Method, r.URL.Path)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.APIKey != "" && r.Header.Get("Authorization") != "Bearer "+s.opts.APIKey {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid API key")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// notify wakes everything waiting for a state change. s.mu must be held.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// newID returns a unique ID with prefix. s.mu must be held.
+func (s *Server) newID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s_%d", prefix, s.seq)
+}
+
+// oplog appends a line to the instance's hypeman operations log, in the same
+// slog text format as the real server. s.mu must be held.
+func (s *Server) oplog(inst *instance, level, msg string, args ...any) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "time=%s level=%s msg=%q instance_id=%s", s.now().UTC().Format(time.RFC3339Nano), level, msg, inst.ID)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %s=%v", args[i], args[i+1])
+	}
+	source := hypeman.InstanceLogsParamsSourceHypeman
+	inst.logs[source] = append(inst.logs[source], b.String())
+}
+
+// namePattern is the rule the server applies to instance and snapshot names
+var namePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error body with the code the SDK's error sentinels match on
+func writeError(w http.ResponseWriter, status int, code, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"code": code, "message": fmt.Sprintf(format, args...)})
+}
+
+// decodeBody decodes a JSON request body into v, treating an empty body as
+// an empty object. It writes a 400 response and reports false on failure.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid request body: %v", err)
+		return false
+	}
+	return true
+}
+
+// decodeStrictBody is decodeBody for endpoints that, like the real server's,
+// reject fields they do not accept.
+func decodeStrictBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid request body: %v", err)
+		return false
+	}
+	return true
+}
+
+// tagFilter reads a tags[key]=value filter from the query
+func tagFilter(r *http.Request) map[string]string {
+	filter := map[string]string{}
+	for key, values := range r.URL.Query() {
+		if tag, ok := strings.CutPrefix(key, "tags["); ok && strings.HasSuffix(tag, "]") && len(values) > 0 {
+			filter[strings.TrimSuffix(tag, "]")] = values[0]
+		}
+	}
+	return filter
+}
+
+// sorted returns the values of m in creation order, since IDs end in an
+// increasing sequence number.
+func sorted[T any](m map[string]*T) []*T {
+	keys := slices.SortedFunc(maps.Keys(m), func(a, b string) int {
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+		return strings.Compare(a, b)
+	})
+	values := make([]*T, len(keys))
+	for i, k := range keys {
+		values[i] = m[k]
+	}
+	return values
+}
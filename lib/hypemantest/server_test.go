@@ -0,0 +1,372 @@
+package hypemantest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestInstanceLifecycle tests start, stop, standby and restore transitions and their errors
+func TestInstanceLifecycle(t *testing.T) {
+	srv := NewServer(Options{})
+	defer srv.Close()
+	client := srv.Client()
+	ctx := t.Context()
+
+	inst, err := client.Instances.New(ctx, hypeman.InstanceNewParams{Name: "web", Image: "nginx:alpine", Tags: map[string]string{"team": "a"}})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateRunning, inst.State)
+	assert.False(t, inst.JSON.ExitCode.Valid())
+	assert.NotEmpty(t, inst.Network.IP)
+
+	_, err = client.Instances.New(ctx, hypeman.InstanceNewParams{Name: "web", Image: "nginx:alpine"})
+	assert.ErrorIs(t, err, hypeman.ErrConflict)
+	_, err = client.Instances.Get(ctx, "missing")
+	assert.ErrorIs(t, err, hypeman.ErrNotFound)
+
+	inst, err = client.Instances.Standby(ctx, inst.ID, hypeman.InstanceStandbyParams{})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateStandby, inst.State)
+	assert.True(t, inst.HasSnapshot)
+	_, err = client.Instances.Start(ctx, inst.ID, hypeman.InstanceStartParams{})
+	assert.ErrorIs(t, err, hypeman.ErrInvalidState)
+
+	inst, err = client.Instances.Restore(ctx, inst.ID)
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateRunning, inst.State)
+	assert.False(t, inst.HasSnapshot)
+
+	inst, err = client.Instances.Stop(ctx, inst.ID)
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateStopped, inst.State)
+	assert.Equal(t, lib.ExitReasonClean, lib.DiagnoseExit(inst).Reason)
+	assert.Contains(t, inst.PhaseDurationsMs, "standby")
+
+	inst, err = client.Instances.Start(ctx, inst.ID, hypeman.InstanceStartParams{})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateRunning, inst.State)
+	assert.False(t, inst.JSON.ExitCode.Valid())
+
+	list, err := client.Instances.List(ctx, hypeman.InstanceListParams{Tags: map[string]string{"team": "a"}})
+	require.NoError(t, err)
+	require.Len(t, *list, 1)
+	list, err = client.Instances.List(ctx, hypeman.InstanceListParams{State: hypeman.InstanceListParamsStateStopped})
+	require.NoError(t, err)
+	assert.Empty(t, *list)
+
+	require.NoError(t, client.Instances.Delete(ctx, inst.ID))
+	_, err = client.Instances.Get(ctx, inst.ID)
+	assert.ErrorIs(t, err, hypeman.ErrNotFound)
+}
+
+// TestUpdateInstance tests that updates apply env and auto_standby and reject
+// fields the API does not accept
+func TestUpdateInstance(t *testing.T) {
+	srv := NewServer(Options{})
+	defer srv.Close()
+	client := srv.Client()
+	ctx := t.Context()
+
+	inst, err := client.Instances.New(ctx, hypeman.InstanceNewParams{Name: "web", Image: "nginx:alpine", Env: map[string]string{"A": "1"}, Tags: map[string]string{"team": "a"}})
+	require.NoError(t, err)
+
+	inst, err = client.Instances.Update(ctx, inst.ID, hypeman.InstanceUpdateParams{
+		Env:         map[string]string{"B": "2"},
+		AutoStandby: hypeman.AutoStandbyPolicyParam{Enabled: hypeman.Bool(true), IdleTimeout: hypeman.String("5m")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"A": "1", "B": "2"}, inst.Env)
+	assert.True(t, inst.AutoStandby.Enabled)
+	assert.Equal(t, "5m", inst.AutoStandby.IdleTimeout)
+
+	_, err = client.Instances.Update(ctx, inst.ID, hypeman.InstanceUpdateParams{}, option.WithJSONSet("tags", map[string]string{"team": "b"}))
+	var apiErr *hypeman.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	inst, err = client.Instances.Get(ctx, inst.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "a"}, inst.Tags)
+}
+
+// TestForkAndSnapshots tests that forks and snapshots carry the guest filesystem and pick the documented default states
+func TestForkAndSnapshots(t *testing.T) {
+	srv := NewServer(Options{})
+	defer srv.Close()
+	client := srv.Client()
+	ctx := t.Context()
+
+	src, err := client.Instances.New(ctx, hypeman.InstanceNewParams{Name: "src", Image: "alpine"})
+	require.NoError(t, err)
+	require.NoError(t, srv.WriteFile(src.ID, "/data/state", []byte("v1"), 0o644))
+
+	_, err = client.Instances.Fork(ctx, src.ID, hypeman.InstanceForkParams{Name: "copy"})
+	assert.ErrorIs(t, err, hypeman.ErrInvalidState, "forking a running instance needs from_running")
+	fork, err := client.Instances.Fork(ctx, src.ID, hypeman.InstanceForkParams{Name: "copy", FromRunning: hypeman.Bool(true)})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateRunning, fork.State)
+	data, err := srv.ReadFile(fork.ID, "/data/state")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+
+	snap, err := client.Instances.Snapshots.New(ctx, src.ID, hypeman.InstanceSnapshotNewParams{Kind: hypeman.SnapshotKindStandby, Name: hypeman.String("before")})
+	require.NoError(t, err)
+	assert.Equal(t, src.ID, snap.SourceInstanceID)
+	_, err = client.Instances.Snapshots.New(ctx, src.ID, hypeman.InstanceSnapshotNewParams{Kind: hypeman.SnapshotKindStopped})
+	assert.ErrorIs(t, err, hypeman.ErrInvalidState)
+
+	require.NoError(t, srv.WriteFile(src.ID, "/data/state", []byte("v2"), 0o644))
+	restored, err := client.Instances.Snapshots.Restore(ctx, snap.ID, hypeman.InstanceSnapshotRestoreParams{ID: src.ID})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateRunning, restored.State)
+	data, err = srv.ReadFile(src.ID, "/data/state")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+
+	forked, err := client.Snapshots.Fork(ctx, snap.ID, hypeman.SnapshotForkParams{Name: "from-snap", TargetState: hypeman.SnapshotForkParamsTargetStateStandby})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateStandby, forked.State)
+	_, err = client.Snapshots.Fork(ctx, snap.ID, hypeman.SnapshotForkParams{Name: "other", TargetHypervisor: hypeman.SnapshotForkParamsTargetHypervisorQemu})
+	assert.Error(t, err, "standby snapshots keep their hypervisor")
+
+	snaps, err := client.Snapshots.List(ctx, hypeman.SnapshotListParams{SourceInstanceID: hypeman.String(src.ID)})
+	require.NoError(t, err)
+	require.Len(t, *snaps, 1)
+	assert.Equal(t, "before", (*snaps)[0].Name)
+}
+
+// TestWait tests that Wait returns on the target state, on a crash and on timeout
+func TestWait(t *testing.T) {
+	srv := NewServer(Options{})
+	defer srv.Close()
+	client := srv.Client()
+	ctx := t.Context()
+
+	inst, err := client.Instances.New(ctx, hypeman.InstanceNewParams{Name: "worker", Image: "alpine"})
+	require.NoError(t, err)
+
+	res, err := client.Instances.Wait(ctx, inst.ID, hypeman.InstanceWaitParams{State: hypeman.InstanceWaitParamsStateStandby, Timeout: hypeman.String("10ms")})
+	require.NoError(t, err)
+	assert.True(t, res.TimedOut)
+	assert.Equal(t, hypeman.WaitForStateResponseStateRunning, res.State)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		srv.Crash(inst.ID, 137, "OOM killed")
+	}()
+	_, err = lib.WaitFor(ctx, &client.Instances, inst.ID, []hypeman.InstanceState{hypeman.InstanceStateStandby}, lib.WaitForOptions{PollInterval: time.Millisecond})
+	assert.ErrorIs(t, err, lib.ErrOOMKilled)
+
+	_, err = client.Instances.Start(ctx, inst.ID, hypeman.InstanceStartParams{})
+	require.NoError(t, err)
+	_, err = client.Instances.Standby(ctx, inst.ID, hypeman.InstanceStandbyParams{})
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		client.Instances.Restore(context.Background(), inst.ID)
+	}()
+	res, err = client.Instances.Wait(ctx, inst.ID, hypeman.InstanceWaitParams{State: hypeman.InstanceWaitParamsStateRunning, Timeout: hypeman.String("5s")})
+	require.NoError(t, err)
+	assert.False(t, res.TimedOut)
+	assert.Equal(t, hypeman.WaitForStateResponseStateRunning, res.State)
+}
+
+// TestLogs tests tailing and following instance logs, and the operations log
+func TestLogs(t *testing.T) {
+	srv := NewServer(Options{})
+	defer srv.Close()
+	client := srv.Client()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	inst, err := client.Instances.New(ctx, hypeman.InstanceNewParams{Name: "app", Image: "alpine"})
+	require.NoError(t, err)
+	require.NoError(t, srv.WriteLog(inst.ID, hypeman.InstanceLogsParamsSourceApp, "one", "two", "three"))
+
+	stream := client.Instances.LogsStreaming(ctx, inst.ID, hypeman.InstanceLogsParams{Tail: hypeman.Int(2), Follow: hypeman.Bool(true)})
+	defer stream.Close()
+	var lines []string
+	for len(lines) < 3 && stream.Next() {
+		lines = append(lines, stream.Current())
+		if len(lines) == 2 {
+			require.NoError(t, srv.WriteLog(inst.ID, hypeman.InstanceLogsParamsSourceApp, "four"))
+		}
+	}
+	require.NoError(t, stream.Err())
+	assert.Equal(t, []string{"two", "three", "four"}, lines)
+
+	ops := client.Instances.LogsStreaming(ctx, inst.ID, hypeman.InstanceLogsParams{Source: hypeman.InstanceLogsParamsSourceHypeman})
+	defer ops.Close()
+	var text []string
+	for ops.Next() {
+		text = append(text, ops.Current())
+	}
+	require.NoError(t, ops.Err())
+	require.Len(t, text, 2)
+	assert.True(t, strings.HasPrefix(text[1], "time="))
+	assert.Contains(t, text[1], `msg="instance running"`)
+}
+
+// TestBuilds tests automatic and manual build completion and the event stream
+func TestBuilds(t *testing.T) {
+	srv := NewServer(Options{ManualBuilds: true})
+	defer srv.Close()
+	client := srv.Client()
+	ctx := t.Context()
+
+	b, err := client.Builds.New(ctx, hypeman.BuildNewParams{
+		Source:     strings.NewReader("archive"),
+		Dockerfile: hypeman.String("FROM alpine\nRUN make\n"),
+		ImageName:  hypeman.String("team/app"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.BuildStatusQueued, b.Status)
+
+	stream := client.Builds.EventsStreaming(ctx, b.ID, hypeman.BuildEventsParams{Follow: hypeman.Bool(true)})
+	defer stream.Close()
+	go srv.CompleteBuild(b.ID, errors.New("make: no rule"))
+	var events []hypeman.BuildEvent
+	for stream.Next() {
+		events = append(events, stream.Current())
+	}
+	require.NoError(t, stream.Err())
+	require.NotEmpty(t, events)
+	last := events[len(events)-1]
+	assert.Equal(t, hypeman.BuildStatusFailed, last.Status)
+
+	b, err = client.Builds.Get(ctx, b.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "make: no rule", b.Error)
+
+	auto := NewServer(Options{})
+	defer auto.Close()
+	client = auto.Client()
+	b, err = client.Builds.New(ctx, hypeman.BuildNewParams{
+		Source:     strings.NewReader("archive"),
+		Dockerfile: hypeman.String("FROM alpine\nRUN make\n"),
+	})
+	require.NoError(t, err)
+	b, err = client.Builds.Get(ctx, b.ID)
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.BuildStatusReady, b.Status)
+	img, err := client.Images.Get(ctx, b.ImageRef)
+	require.NoError(t, err)
+	assert.Equal(t, b.ImageDigest, img.Digest)
+	assert.ErrorIs(t, client.Builds.Cancel(ctx, b.ID), hypeman.ErrInvalidState)
+
+	events = nil
+	for event, err := range client.Builds.EventsStreaming(ctx, b.ID, hypeman.BuildEventsParams{}).All() {
+		require.NoError(t, err)
+		events = append(events, event)
+	}
+	var logs []string
+	for _, event := range events {
+		if event.Type == hypeman.BuildEventTypeLog {
+			logs = append(logs, event.Content)
+		}
+	}
+	assert.Equal(t, []string{"#1 FROM alpine", "#2 RUN make"}, logs)
+}
+
+// TestResources tests volume, device and ingress bookkeeping and conflicts
+func TestResources(t *testing.T) {
+	srv := NewServer(Options{})
+	defer srv.Close()
+	srv.AddAvailableDevice(hypeman.AvailableDevice{PciAddress: "0000:a2:00.0", VendorID: "10de", DeviceID: "2330"})
+	client := srv.Client()
+	ctx := t.Context()
+
+	vol, err := client.Volumes.New(ctx, hypeman.VolumeNewParams{Name: "data", SizeGB: 10})
+	require.NoError(t, err)
+	_, err = client.Devices.New(ctx, hypeman.DeviceNewParams{PciAddress: "0000:ff:00.0"})
+	assert.ErrorIs(t, err, hypeman.ErrNotFound)
+	dev, err := client.Devices.New(ctx, hypeman.DeviceNewParams{PciAddress: "0000:a2:00.0", Name: hypeman.String("gpu0")})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.DeviceTypeGPU, dev.Type)
+
+	inst, err := client.Instances.New(ctx, hypeman.InstanceNewParams{
+		Name:    "db",
+		Image:   "postgres",
+		Devices: []string{"gpu0"},
+		Volumes: []hypeman.VolumeMountParam{{VolumeID: vol.ID, MountPath: "/var/lib/postgresql"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, inst.Volumes, 1)
+
+	vol, err = client.Volumes.Get(ctx, vol.ID)
+	require.NoError(t, err)
+	require.Len(t, vol.Attachments, 1)
+	assert.Equal(t, inst.ID, vol.Attachments[0].InstanceID)
+	assert.ErrorIs(t, client.Volumes.Delete(ctx, vol.ID), hypeman.ErrConflict)
+	assert.ErrorIs(t, client.Devices.Delete(ctx, dev.ID), hypeman.ErrConflict)
+
+	other, err := client.Instances.New(ctx, hypeman.InstanceNewParams{Name: "reader", Image: "alpine"})
+	require.NoError(t, err)
+	_, err = client.Instances.Volumes.Attach(ctx, vol.ID, hypeman.InstanceVolumeAttachParams{ID: other.ID, MountPath: "/data"})
+	assert.ErrorIs(t, err, hypeman.ErrConflict, "a volume mounted read-write is exclusive")
+
+	require.NoError(t, client.Instances.Delete(ctx, inst.ID))
+	_, err = client.Instances.Volumes.Attach(ctx, vol.ID, hypeman.InstanceVolumeAttachParams{ID: other.ID, MountPath: "/data"})
+	require.NoError(t, err)
+	dev, err = client.Devices.Get(ctx, dev.ID)
+	require.NoError(t, err)
+	assert.False(t, dev.JSON.AttachedTo.Valid() && dev.AttachedTo != "")
+
+	rule := hypeman.IngressRuleParam{
+		Match:  hypeman.IngressMatchParam{Hostname: "app.example.com"},
+		Target: hypeman.IngressTargetParam{Instance: "reader", Port: 8080},
+	}
+	ing, err := client.Ingresses.New(ctx, hypeman.IngressNewParams{Name: "app", Rules: []hypeman.IngressRuleParam{rule}})
+	require.NoError(t, err)
+	assert.Equal(t, "app.example.com", ing.Rules[0].Match.Hostname)
+	_, err = client.Ingresses.New(ctx, hypeman.IngressNewParams{Name: "app2", Rules: []hypeman.IngressRuleParam{rule}})
+	assert.ErrorIs(t, err, hypeman.ErrConflict)
+}
+
+// TestCp tests copying files and directories to and from an instance
+func TestCp(t *testing.T) {
+	srv := NewServer(Options{APIKey: "secret"})
+	defer srv.Close()
+	client := srv.Client()
+	ctx := t.Context()
+
+	inst, err := client.Instances.New(ctx, hypeman.InstanceNewParams{Name: "box", Image: "alpine"})
+	require.NoError(t, err)
+
+	local := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "src", "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "src", "sub", "a.txt"), []byte("hello"), 0o600))
+
+	cfg := lib.CpConfig{BaseURL: srv.URL, APIKey: "secret"}
+	require.NoError(t, lib.CpToInstance(ctx, cfg, lib.CpToInstanceOptions{InstanceID: inst.ID, SrcPath: filepath.Join(local, "src"), DstPath: "/app"}))
+	data, err := srv.ReadFile(inst.ID, "/app/sub/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	info, err := client.Instances.Stat(ctx, inst.ID, hypeman.InstanceStatParams{Path: "/app/sub/a.txt"})
+	require.NoError(t, err)
+	assert.True(t, info.IsFile)
+	assert.Equal(t, int64(0o600), info.Mode)
+
+	out := t.TempDir()
+	require.NoError(t, lib.CpFromInstance(ctx, cfg, lib.CpFromInstanceOptions{InstanceID: inst.ID, SrcPath: "/app", DstPath: out}))
+	data, err = os.ReadFile(filepath.Join(out, "app", "sub", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	err = lib.CpFromInstance(ctx, cfg, lib.CpFromInstanceOptions{InstanceID: inst.ID, SrcPath: "/missing", DstPath: out})
+	assert.ErrorContains(t, err, "no such file")
+
+	_, err = srv.Client(option.WithAPIKey("wrong")).Instances.Get(ctx, inst.ID)
+	assert.ErrorIs(t, err, hypeman.ErrUnauthorized)
+}
@@ -0,0 +1,209 @@
+package hypemantest
+
+import (
+	"net/http"
+
+	"github.com/kernel/hypeman-go"
+)
+
+func (s *Server) createSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind hypeman.SnapshotKind `json:"kind"`
+		Name string               `json:"name"`
+		Tags map[string]string    `json:"tags"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil {
+		return
+	}
+	// Standby snapshots capture memory, so they need a running or suspended guest
+	switch req.Kind {
+	case hypeman.SnapshotKindStandby:
+		if !checkState(w, inst, "take a Standby snapshot of", hypeman.InstanceStateRunning, hypeman.InstanceStateStandby) {
+			return
+		}
+	case hypeman.SnapshotKindStopped:
+		if !checkState(w, inst, "take a Stopped snapshot of", hypeman.InstanceStateStopped) {
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid snapshot kind %q", req.Kind)
+		return
+	}
+	snap := &snapshot{
+		ID:                 s.newID("snap"),
+		Kind:               req.Kind,
+		CreatedAt:          s.now(),
+		SizeBytes:          inst.fs.size(),
+		SourceHypervisor:   inst.Hypervisor,
+		SourceInstanceID:   inst.ID,
+		SourceInstanceName: inst.Name,
+		CompressionState:   hypeman.SnapshotCompressionStateNone,
+		Tags:               req.Tags,
+	}
+	source := inst.view(s.now())
+	source.fs = inst.fs.clone()
+	snap.source = &source
+	if req.Name != "" {
+		if !namePattern.MatchString(req.Name) {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid name %q: use lowercase letters, digits and dashes", req.Name)
+			return
+		}
+		for _, other := range s.snapshots {
+			if other.Name != nil && *other.Name == req.Name {
+				writeError(w, http.StatusConflict, "already_exists", "snapshot named %s already exists", req.Name)
+				return
+			}
+		}
+		snap.Name = ptr(req.Name)
+	}
+	s.snapshots[snap.ID] = snap
+	s.oplog(inst, "INFO", "snapshot created", "snapshot_id", snap.ID, "kind", snap.Kind)
+	writeJSON(w, http.StatusCreated, snap)
+}
+
+func (s *Server) restoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TargetHypervisor hypeman.InstanceHypervisor `json:"target_hypervisor"`
+		TargetState      hypeman.InstanceState      `json:"target_state"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.lookupInstance(w, r)
+	if inst == nil || !checkTargetState(w, req.TargetState) {
+		return
+	}
+	snap := s.lookupSnapshot(w, r)
+	if snap == nil || !checkHypervisorOverride(w, snap, req.TargetHypervisor) {
+		return
+	}
+	inst.fs = snap.source.fs.clone()
+	if req.TargetHypervisor != "" {
+		inst.Hypervisor = req.TargetHypervisor
+	}
+	s.oplog(inst, "INFO", "snapshot restored", "snapshot_id", snap.ID)
+	s.enterFromSnapshot(inst, snap, req.TargetState)
+	s.notify()
+	writeJSON(w, http.StatusOK, inst.view(s.now()))
+}
+
+func (s *Server) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tags := tagFilter(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []*snapshot{}
+	for _, snap := range sorted(s.snapshots) {
+		if name := query.Get("name"); name != "" && (snap.Name == nil || *snap.Name != name) {
+			continue
+		}
+		if id := query.Get("source_instance_id"); id != "" && snap.SourceInstanceID != id {
+			continue
+		}
+		if kind := query.Get("kind"); kind != "" && string(snap.Kind) != kind {
+			continue
+		}
+		if matchTags(snap.Tags, tags) {
+			list = append(list, snap)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getSnapshot(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if snap := s.lookupSnapshot(w, r); snap != nil {
+		writeJSON(w, http.StatusOK, snap)
+	}
+}
+
+func (s *Server) deleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if snap := s.lookupSnapshot(w, r); snap != nil {
+		delete(s.snapshots, snap.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) forkSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name             string                     `json:"name"`
+		TargetHypervisor hypeman.InstanceHypervisor `json:"target_hypervisor"`
+		TargetState      hypeman.InstanceState      `json:"target_state"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := s.lookupSnapshot(w, r)
+	if snap == nil || !s.checkInstanceName(w, req.Name) || !checkTargetState(w, req.TargetState) || !checkHypervisorOverride(w, snap, req.TargetHypervisor) {
+		return
+	}
+	fork := s.copyInstance(snap.source, req.Name)
+	if req.TargetHypervisor != "" {
+		fork.Hypervisor = req.TargetHypervisor
+	}
+	s.oplog(fork, "INFO", "instance forked", "snapshot_id", snap.ID)
+	s.enterFromSnapshot(fork, snap, req.TargetState)
+	s.notify()
+	writeJSON(w, http.StatusCreated, fork.view(s.now()))
+}
+
+// enterFromSnapshot moves inst to target, or to the default state for the
+// snapshot's kind: Running for Standby snapshots and Stopped for Stopped
+// ones. s.mu must be held.
+func (s *Server) enterFromSnapshot(inst *instance, snap *snapshot, target hypeman.InstanceState) {
+	if target == "" {
+		target = hypeman.InstanceStateStopped
+		if snap.Kind == hypeman.SnapshotKindStandby {
+			target = hypeman.InstanceStateRunning
+		}
+	}
+	inst.ExitCode = nil
+	inst.ExitMessage = ""
+	inst.StateError = nil
+	// Resuming a Standby snapshot continues the captured guest rather than booting it
+	inst.HasSnapshot = snap.Kind == hypeman.SnapshotKindStandby
+	if inst.HasSnapshot {
+		inst.StartedAt = snap.source.StartedAt
+		inst.StoppedAt = nil
+	}
+	s.enter(inst, target)
+}
+
+// lookupSnapshot returns the snapshot named by the request's snapshotId, or
+// writes a 404 and returns nil. s.mu must be held.
+func (s *Server) lookupSnapshot(w http.ResponseWriter, r *http.Request) *snapshot {
+	id := r.PathValue("snapshotId")
+	snap, ok := s.snapshots[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "snapshot %s not found", id)
+		return nil
+	}
+	return snap
+}
+
+// checkHypervisorOverride writes a 400 if a hypervisor override is given for
+// a Standby snapshot, whose memory image only its original hypervisor can load
+func checkHypervisorOverride(w http.ResponseWriter, snap *snapshot, hypervisor hypeman.InstanceHypervisor) bool {
+	if hypervisor == "" || hypervisor == snap.SourceHypervisor || snap.Kind != hypeman.SnapshotKindStandby {
+		return true
+	}
+	writeError(w, http.StatusBadRequest, "bad_request", "standby snapshot %s must be restored with %s", snap.ID, snap.SourceHypervisor)
+	return false
+}
@@ -0,0 +1,146 @@
+package logs
+
+import (
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/kernel/hypeman-go"
+)
+
+// Filter reports whether a record should be delivered
+type Filter func(rec Record) bool
+
+// MinLevel keeps records at level or above. Records without a recognizable
+// level are kept, since dropping them would hide plain-text errors.
+func MinLevel(level Level) Filter {
+	return func(rec Record) bool {
+		return rec.Level == LevelUnknown || rec.Level >= level
+	}
+}
+
+// FieldEquals keeps records whose field key has the given value, compared as text.
+func FieldEquals(key, value string) Filter {
+	return func(rec Record) bool {
+		v, ok := rec.Fields[key]
+		return ok && fmt.Sprint(v) == value
+	}
+}
+
+// HasField keeps records that carry field key.
+func HasField(key string) Filter {
+	return func(rec Record) bool {
+		_, ok := rec.Fields[key]
+		return ok
+	}
+}
+
+// MessageContains keeps records whose message contains substr.
+func MessageContains(substr string) Filter {
+	return func(rec Record) bool {
+		return strings.Contains(rec.Message, substr)
+	}
+}
+
+// LineSource is a stream of log lines, such as the *ssestream.Stream[string]
+// returned by Instances.LogsStreaming.
+type LineSource interface {
+	Next() bool
+	Current() string
+	Err() error
+	Close() error
+}
+
+// DecoderOptions configures a Decoder
+type DecoderOptions struct {
+	// Optional: the log source being decoded, used to pick default parsers (defaults to app)
+	Source hypeman.InstanceLogsParamsSource
+	// Optional: parsers to try in order (defaults to DefaultParsers(Source))
+	Parsers []Parser
+	// Optional: records are only delivered if every filter keeps them
+	Filters []Filter
+}
+
+// Decoder turns a stream of log lines into records, skipping records rejected
+// by its filters.
+type Decoder struct {
+	src     LineSource
+	opts    DecoderOptions
+	current Record
+}
+
+// NewDecoder returns a Decoder reading lines from src.
+//
+// Example:
+//
+//	stream := client.Instances.LogsStreaming(ctx, id, hypeman.InstanceLogsParams{Follow: hypeman.Bool(true)})
+//	dec := logs.NewDecoder(stream, logs.DecoderOptions{
+//	    Filters: []logs.Filter{logs.MinLevel(logs.LevelWarn)},
+//	})
+//	defer dec.Close()
+//	for dec.Next() {
+//	    rec := dec.Current()
+//	    fmt.Println(rec.Time, rec.Level, rec.Message)
+//	}
+//	if err := dec.Err(); err != nil {
+//	    return err
+//	}
+func NewDecoder(src LineSource, opts DecoderOptions) *Decoder {
+	if opts.Parsers == nil {
+		opts.Parsers = DefaultParsers(opts.Source)
+	}
+	return &Decoder{src: src, opts: opts}
+}
+
+// Next advances to the next record that passes the filters, and reports
+// false when the underlying stream ends.
+func (d *Decoder) Next() bool {
+	for d.src.Next() {
+		rec := ParseLine(d.src.Current(), d.opts.Parsers...)
+		if d.keep(rec) {
+			d.current = rec
+			return true
+		}
+	}
+	return false
+}
+
+// Current returns the record read by the last call to Next.
+func (d *Decoder) Current() Record {
+	return d.current
+}
+
+// Err returns the error that ended the underlying stream, if any.
+func (d *Decoder) Err() error {
+	return d.src.Err()
+}
+
+// Close closes the underlying stream.
+func (d *Decoder) Close() error {
+	return d.src.Close()
+}
+
+// All returns an iterator over the remaining records. The decoder is closed
+// when iteration stops, and a stream error is yielded once at the end.
+func (d *Decoder) All() iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		defer d.Close()
+		for d.Next() {
+			if !yield(d.Current(), nil) {
+				return
+			}
+		}
+		if err := d.Err(); err != nil {
+			yield(Record{}, err)
+		}
+	}
+}
+
+func (d *Decoder) keep(rec Record) bool {
+	for _, f := range d.opts.Filters {
+		if !f(rec) {
+			return false
+		}
+	}
+	return true
+}
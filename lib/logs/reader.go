@@ -0,0 +1,149 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/option"
+)
+
+// ErrReaderClosed is returned by Read once the reader has been closed,
+// including by a Read that was blocked waiting for a line.
+var ErrReaderClosed = errors.New("read from closed log reader")
+
+// NewReader returns the lines of src as a byte stream, each terminated by
+// exactly one "\n" whatever line ending the server sent. Read returns io.EOF
+// when src ends cleanly and src's error otherwise. Close closes src and may be
+// called from another goroutine to unblock a pending Read.
+//
+// Example:
+//
+//	r := logs.NewReader(client.Instances.LogsStreaming(ctx, id, hypeman.InstanceLogsParams{}))
+//	defer r.Close()
+//	scanner := bufio.NewScanner(r)
+//	for scanner.Scan() {
+//	    fmt.Println(scanner.Text())
+//	}
+func NewReader(src LineSource) io.ReadCloser {
+	return &lineReader{src: src}
+}
+
+// Open starts streaming the logs of an instance and returns them as an
+// io.ReadCloser, as described by NewReader. Request errors are returned from
+// the first Read.
+func Open(ctx context.Context, instances hypeman.InstancesAPI, id string, params hypeman.InstanceLogsParams, opts ...option.RequestOption) io.ReadCloser {
+	return NewReader(instances.LogsStreaming(ctx, id, params, opts...))
+}
+
+type lineReader struct {
+	src     LineSource
+	pending []byte // rest of the current line
+	closed  atomic.Bool
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	if r.closed.Load() {
+		return 0, ErrReaderClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(r.pending) == 0 {
+		if !r.src.Next() {
+			if r.closed.Load() {
+				return 0, ErrReaderClosed
+			}
+			if err := r.src.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		r.pending = append(r.pending[:0], strings.TrimRight(r.src.Current(), "\r\n")...)
+		r.pending = append(r.pending, '\n')
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *lineReader) Close() error {
+	r.closeOnce.Do(func() {
+		r.closed.Store(true)
+		r.closeErr = r.src.Close()
+	})
+	return r.closeErr
+}
+
+// Ship copies the logs of an instance to w until the stream ends or ctx is
+// cancelled, in which case it returns nil. Each line is written with a single
+// Write call, so writers that rotate files, such as RotatingFile, never split
+// a line. Set params.Follow to keep shipping new lines as they are written.
+//
+// Example:
+//
+//	f, err := logs.NewRotatingFile("/var/log/worker.log", logs.RotatingFileOptions{
+//	    MaxSize: 100 << 20,
+//	    MaxAge:  24 * time.Hour,
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	defer f.Close()
+//	err = logs.Ship(ctx, &client.Instances, id, f, hypeman.InstanceLogsParams{Follow: hypeman.Bool(true)})
+func Ship(ctx context.Context, instances hypeman.InstancesAPI, id string, w io.Writer, params hypeman.InstanceLogsParams, opts ...option.RequestOption) error {
+	stream := instances.LogsStreaming(ctx, id, params, opts...)
+	defer stream.Close()
+
+	var line []byte
+	for stream.Next() {
+		line = append(line[:0], strings.TrimRight(stream.Current(), "\r\n")...)
+		line = append(line, '\n')
+		if _, err := w.Write(line); err != nil {
+			return fmt.Errorf("write log line: %w", err)
+		}
+	}
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("stream logs of %s: %w", id, err)
+	}
+	return nil
+}
+
+// ShipToHandler decodes the logs of an instance with the default parsers for
+// params.Source and passes each record to h as an slog.Record, with an
+// "instance" attribute holding id, until the stream ends or ctx is cancelled,
+// in which case it returns nil. Records below the level h is enabled for are
+// skipped.
+//
+// Example:
+//
+//	h := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})
+//	err := logs.ShipToHandler(ctx, &client.Instances, id, h, hypeman.InstanceLogsParams{Follow: hypeman.Bool(true)})
+func ShipToHandler(ctx context.Context, instances hypeman.InstancesAPI, id string, h slog.Handler, params hypeman.InstanceLogsParams, opts ...option.RequestOption) error {
+	dec := NewDecoder(instances.LogsStreaming(ctx, id, params, opts...), DecoderOptions{Source: params.Source})
+	defer dec.Close()
+
+	h = h.WithAttrs([]slog.Attr{slog.String("instance", id)})
+	for dec.Next() {
+		rec := dec.Current()
+		if !h.Enabled(ctx, rec.Level.SlogLevel()) {
+			continue
+		}
+		if err := h.Handle(ctx, rec.SlogRecord()); err != nil {
+			return fmt.Errorf("handle log record: %w", err)
+		}
+	}
+	if err := dec.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("stream logs of %s: %w", id, err)
+	}
+	return nil
+}
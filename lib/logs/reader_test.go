@@ -0,0 +1,140 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib/hypemantest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReader tests newline normalization, small reads and end of stream
+func TestReader(t *testing.T) {
+	r := NewReader(&fakeLines{lines: []string{"one\n", "two\r\n", "", "three"}})
+	data, err := io.ReadAll(iotestOneByte{r})
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n\nthree\n", string(data))
+
+	src := &fakeLines{lines: []string{"a"}, err: errors.New("stream reset")}
+	r = NewReader(src)
+	scanner := bufio.NewScanner(r)
+	require.True(t, scanner.Scan())
+	assert.Equal(t, "a", scanner.Text())
+	assert.False(t, scanner.Scan())
+	assert.EqualError(t, scanner.Err(), "stream reset")
+
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Close())
+	assert.True(t, src.closed)
+	_, err = r.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, ErrReaderClosed)
+}
+
+// iotestOneByte reads one byte at a time
+type iotestOneByte struct{ r io.Reader }
+
+func (o iotestOneByte) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+// newLogServer starts a fake server with a running instance whose app log
+// holds lines, and returns a client for it and the instance's ID
+func newLogServer(t *testing.T, lines ...string) (*hypeman.Client, string) {
+	srv := hypemantest.NewServer(hypemantest.Options{})
+	t.Cleanup(srv.Close)
+	client := srv.Client()
+	inst, err := client.Instances.New(t.Context(), hypeman.InstanceNewParams{Name: "worker", Image: "alpine"})
+	require.NoError(t, err)
+	require.NoError(t, srv.WriteLog(inst.ID, hypeman.InstanceLogsParamsSourceApp, lines...))
+	return client, inst.ID
+}
+
+// TestOpen_CloseUnblocksRead tests closing a reader while a Read waits for lines
+func TestOpen_CloseUnblocksRead(t *testing.T) {
+	client, id := newLogServer(t, "hello")
+
+	r := Open(t.Context(), &client.Instances, id, hypeman.InstanceLogsParams{Follow: hypeman.Bool(true)})
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(buf[:n]))
+
+	done := make(chan error)
+	go func() {
+		_, err := r.Read(buf)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, r.Close())
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrReaderClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read was not unblocked by Close")
+	}
+}
+
+// TestShip tests shipping logs into a rotating file without splitting lines
+func TestShip(t *testing.T) {
+	client, id := newLogServer(t, "line 1", "line 2", "line 3", "line 4", "line 5")
+
+	path := filepath.Join(t.TempDir(), "logs", "worker.log")
+	f, err := NewRotatingFile(path, RotatingFileOptions{MaxSize: 15})
+	require.NoError(t, err)
+	require.NoError(t, Ship(t.Context(), &client.Instances, id, f, hypeman.InstanceLogsParams{}))
+	require.NoError(t, f.Close())
+
+	var all []string
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), entry.Name()))
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(data), 15)
+		assert.True(t, strings.HasSuffix(string(data), "\n"), "files end on a line boundary")
+		all = append(all, strings.Fields(strings.ReplaceAll(string(data), "line ", ""))...)
+	}
+	assert.Len(t, entries, 3)
+	assert.ElementsMatch(t, []string{"1", "2", "3", "4", "5"}, all)
+}
+
+// TestShipToHandler tests decoding logs into slog records and level filtering
+func TestShipToHandler(t *testing.T) {
+	client, id := newLogServer(t,
+		`{"time":"2025-01-02T03:04:05Z","level":"debug","msg":"cache miss"}`,
+		`{"time":"2025-01-02T03:04:06Z","level":"error","msg":"request failed","status":502,"path":"/api"}`,
+		`level=warn msg="slow query" ms=1200`,
+	)
+
+	var buf bytes.Buffer
+	h := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+	require.NoError(t, ShipToHandler(t.Context(), &client.Instances, id, h, hypeman.InstanceLogsParams{}))
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		records = append(records, rec)
+	}
+	require.Len(t, records, 2)
+	assert.Equal(t, map[string]any{
+		"time": "2025-01-02T03:04:06Z", "level": "ERROR", "msg": "request failed",
+		"instance": id, "path": "/api", "status": float64(502),
+	}, records[0])
+	assert.Equal(t, "WARN", records[1]["level"])
+	assert.Equal(t, "1200", records[1]["ms"])
+}
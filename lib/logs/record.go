@@ -0,0 +1,322 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+)
+
+// Level is the severity of a log record
+type Level int
+
+const (
+	LevelUnknown Level = iota // the line did not carry a recognizable level
+	LevelTrace
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelTrace:
+		return "TRACE"
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	case LevelFatal:
+		return "FATAL"
+	}
+	return "UNKNOWN"
+}
+
+// SlogLevel returns the slog level closest to l. Trace is four below
+// slog.LevelDebug, fatal four above slog.LevelError, and unknown is
+// slog.LevelInfo.
+func (l Level) SlogLevel() slog.Level {
+	switch l {
+	case LevelTrace:
+		return slog.LevelDebug - 4
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	case LevelFatal:
+		return slog.LevelError + 4
+	}
+	return slog.LevelInfo
+}
+
+// ParseLevel parses a level name such as "info", "WARNING" or "err", or a
+// numeric pino/bunyan level such as 30.
+func ParseLevel(s string) Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "trace":
+		return LevelTrace
+	case "debug", "dbug":
+		return LevelDebug
+	case "info", "information", "notice":
+		return LevelInfo
+	case "warn", "warning":
+		return LevelWarn
+	case "error", "err", "eror":
+		return LevelError
+	case "fatal", "panic", "critical", "crit", "emerg", "alert":
+		return LevelFatal
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return numericLevel(float64(n))
+	}
+	return LevelUnknown
+}
+
+// numericLevel maps pino/bunyan numeric levels
+func numericLevel(n float64) Level {
+	switch {
+	case n >= 60:
+		return LevelFatal
+	case n >= 50:
+		return LevelError
+	case n >= 40:
+		return LevelWarn
+	case n >= 30:
+		return LevelInfo
+	case n >= 20:
+		return LevelDebug
+	case n >= 10:
+		return LevelTrace
+	}
+	return LevelUnknown
+}
+
+// Record is a decoded log line
+type Record struct {
+	Time    time.Time      // Zero if the line carried no timestamp
+	Level   Level          // LevelUnknown if the line carried no level
+	Message string         // The whole line if it could not be parsed
+	Fields  map[string]any // Remaining key/value pairs
+	Raw     string         // The line as received
+}
+
+// SlogRecord converts r to an slog.Record with r's fields as attributes,
+// sorted by key. Records without a time are given the current time.
+func (r Record) SlogRecord() slog.Record {
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	rec := slog.NewRecord(t, r.Level.SlogLevel(), r.Message, 0)
+	for _, key := range slices.Sorted(maps.Keys(r.Fields)) {
+		rec.AddAttrs(slog.Any(key, r.Fields[key]))
+	}
+	return rec
+}
+
+// Parser decodes one log format. Parse reports false if line is not in the
+// format, so that the next parser can be tried.
+type Parser interface {
+	Parse(line string) (Record, bool)
+}
+
+// ParserFunc adapts a function to a Parser
+type ParserFunc func(line string) (Record, bool)
+
+func (f ParserFunc) Parse(line string) (Record, bool) { return f(line) }
+
+var (
+	// JSONParser decodes JSON object lines, as written by slog, zap, zerolog,
+	// pino, bunyan and similar loggers.
+	JSONParser Parser = ParserFunc(parseJSON)
+	// LogfmtParser decodes key=value lines, as written by logfmt and slog's
+	// text handler.
+	LogfmtParser Parser = ParserFunc(parseLogfmt)
+	// HypemanParser decodes the hypeman operations log, which is written in
+	// slog's text format with time, level and msg keys on every line.
+	HypemanParser Parser = ParserFunc(parseHypeman)
+)
+
+// DefaultParsers returns the parsers to try for a log source: JSON then logfmt
+// for application logs, the operations format for hypeman logs, and none for
+// VMM logs, whose lines are kept as plain messages.
+func DefaultParsers(source hypeman.InstanceLogsParamsSource) []Parser {
+	switch source {
+	case hypeman.InstanceLogsParamsSourceHypeman:
+		return []Parser{HypemanParser, LogfmtParser}
+	case hypeman.InstanceLogsParamsSourceVmm:
+		return nil
+	}
+	return []Parser{JSONParser, LogfmtParser}
+}
+
+// ParseLine decodes line with the first parser that accepts it. Lines no
+// parser accepts become a Record whose Message is the line.
+func ParseLine(line string, parsers ...Parser) Record {
+	line = strings.TrimRight(line, "\r\n")
+	for _, p := range parsers {
+		if rec, ok := p.Parse(line); ok {
+			rec.Raw = line
+			return rec
+		}
+	}
+	return Record{Message: line, Raw: line}
+}
+
+var (
+	timeKeys    = []string{"time", "ts", "timestamp", "@timestamp", "t"}
+	levelKeys   = []string{"level", "lvl", "severity", "loglevel"}
+	messageKeys = []string{"msg", "message", "@message"}
+)
+
+// recordFromFields builds a Record, moving well-known keys out of fields.
+func recordFromFields(fields map[string]any) Record {
+	rec := Record{Fields: fields}
+	for _, key := range timeKeys {
+		if v, ok := fields[key]; ok {
+			if t, ok := parseTime(v); ok {
+				rec.Time = t
+				delete(fields, key)
+				break
+			}
+		}
+	}
+	for _, key := range levelKeys {
+		if v, ok := fields[key]; ok {
+			switch v := v.(type) {
+			case string:
+				rec.Level = ParseLevel(v)
+			case float64:
+				rec.Level = numericLevel(v)
+			}
+			delete(fields, key)
+			break
+		}
+	}
+	for _, key := range messageKeys {
+		if v, ok := fields[key]; ok {
+			rec.Message = fmt.Sprint(v)
+			delete(fields, key)
+			break
+		}
+	}
+	return rec
+}
+
+// parseTime accepts RFC 3339 strings and Unix timestamps in seconds or milliseconds.
+func parseTime(v any) (time.Time, bool) {
+	switch v := v.(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return parseTime(f)
+		}
+	case float64:
+		if v > 1e12 {
+			return time.UnixMilli(int64(v)), true
+		}
+		sec := int64(v)
+		return time.Unix(sec, int64((v-float64(sec))*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+func parseJSON(line string) (Record, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return Record{}, false
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(trimmed), &fields); err != nil {
+		return Record{}, false
+	}
+	return recordFromFields(fields), true
+}
+
+func parseLogfmt(line string) (Record, bool) {
+	pairs, ok := splitLogfmt(line)
+	if !ok {
+		return Record{}, false
+	}
+	fields := make(map[string]any, len(pairs))
+	for _, kv := range pairs {
+		fields[kv[0]] = kv[1]
+	}
+	return recordFromFields(fields), true
+}
+
+func parseHypeman(line string) (Record, bool) {
+	pairs, ok := splitLogfmt(line)
+	if !ok || len(pairs) < 3 || pairs[0][0] != "time" || pairs[1][0] != "level" || pairs[2][0] != "msg" {
+		return Record{}, false
+	}
+	return parseLogfmt(line)
+}
+
+// splitLogfmt splits a line of key=value pairs. Values may be double quoted
+// with Go escapes. It reports false unless the whole line is pairs, so plain
+// text that merely contains an "=" is not mistaken for logfmt.
+func splitLogfmt(line string) ([][2]string, bool) {
+	var pairs [][2]string
+	rest := strings.TrimSpace(line)
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 || !isLogfmtKey(rest[:eq]) {
+			return nil, false
+		}
+		key := rest[:eq]
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, false
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+			if rest != "" && rest[0] != ' ' {
+				return nil, false
+			}
+		} else {
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			value = rest[:end]
+			if strings.ContainsRune(value, '"') {
+				return nil, false
+			}
+			rest = rest[end:]
+		}
+		pairs = append(pairs, [2]string{key, value})
+		rest = strings.TrimLeft(rest, " ")
+	}
+	return pairs, len(pairs) > 0
+}
+
+// isLogfmtKey reports whether key only uses characters loggers emit in keys
+func isLogfmtKey(key string) bool {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_.-@/", r)) {
+			return false
+		}
+	}
+	return true
+}
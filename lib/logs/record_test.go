@@ -0,0 +1,138 @@
+package logs
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseLine_JSON tests slog and pino style JSON lines
+func TestParseLine_JSON(t *testing.T) {
+	rec := ParseLine(`{"time":"2025-01-02T03:04:05.5Z","level":"WARN","msg":"slow request","path":"/api","status":200}`, JSONParser)
+	assert.Equal(t, time.Date(2025, 1, 2, 3, 4, 5, 5e8, time.UTC), rec.Time)
+	assert.Equal(t, LevelWarn, rec.Level)
+	assert.Equal(t, "slow request", rec.Message)
+	assert.Equal(t, map[string]any{"path": "/api", "status": float64(200)}, rec.Fields)
+
+	rec = ParseLine(`{"level":50,"time":1735787045123,"msg":"boom","pid":1}`+"\n", JSONParser)
+	assert.Equal(t, LevelError, rec.Level)
+	assert.Equal(t, int64(1735787045123), rec.Time.UnixMilli())
+	assert.Equal(t, "boom", rec.Message)
+	assert.Equal(t, `{"level":50,"time":1735787045123,"msg":"boom","pid":1}`, rec.Raw)
+}
+
+// TestParseLine_Logfmt tests key=value lines with quoted values
+func TestParseLine_Logfmt(t *testing.T) {
+	rec := ParseLine(`ts=1735787045 lvl=info msg="listening on \"0.0.0.0:80\"" port=80 user.id=7`, LogfmtParser)
+	assert.Equal(t, int64(1735787045), rec.Time.Unix())
+	assert.Equal(t, LevelInfo, rec.Level)
+	assert.Equal(t, `listening on "0.0.0.0:80"`, rec.Message)
+	assert.Equal(t, map[string]any{"port": "80", "user.id": "7"}, rec.Fields)
+}
+
+// TestParseLine_Hypeman tests the operations log format
+func TestParseLine_Hypeman(t *testing.T) {
+	line := `time=2025-01-02T03:04:05.000Z level=INFO msg="instance started" instance=abc duration_ms=812`
+	rec := ParseLine(line, DefaultParsers(hypeman.InstanceLogsParamsSourceHypeman)...)
+	assert.Equal(t, LevelInfo, rec.Level)
+	assert.Equal(t, "instance started", rec.Message)
+	assert.Equal(t, "abc", rec.Fields["instance"])
+	assert.False(t, rec.Time.IsZero())
+
+	_, ok := HypemanParser.Parse(`level=INFO msg=x`)
+	assert.False(t, ok, "the operations format always leads with time, level and msg")
+}
+
+// TestParseLine_Plain tests that unparseable lines are kept as messages
+func TestParseLine_Plain(t *testing.T) {
+	for _, line := range []string{
+		"Starting server...",
+		"retrying with timeout=5s in 3s",
+		`{"truncated": `,
+		`key="unterminated`,
+	} {
+		rec := ParseLine(line, DefaultParsers(hypeman.InstanceLogsParamsSourceApp)...)
+		assert.Equal(t, line, rec.Message)
+		assert.Equal(t, LevelUnknown, rec.Level)
+		assert.Nil(t, rec.Fields)
+	}
+
+	rec := ParseLine(`{"level":"error","msg":"x"}`, DefaultParsers(hypeman.InstanceLogsParamsSourceVmm)...)
+	assert.Equal(t, LevelUnknown, rec.Level, "VMM lines are not decoded")
+}
+
+// TestParseLevel tests level names and numbers
+func TestParseLevel(t *testing.T) {
+	assert.Equal(t, LevelWarn, ParseLevel("WARNING"))
+	assert.Equal(t, LevelError, ParseLevel("err"))
+	assert.Equal(t, LevelFatal, ParseLevel("panic"))
+	assert.Equal(t, LevelDebug, ParseLevel("20"))
+	assert.Equal(t, LevelUnknown, ParseLevel("loud"))
+	assert.Equal(t, "WARN", LevelWarn.String())
+	assert.Equal(t, slog.LevelWarn, LevelWarn.SlogLevel())
+	assert.Equal(t, slog.LevelInfo, LevelUnknown.SlogLevel())
+	assert.Less(t, LevelTrace.SlogLevel(), slog.LevelDebug)
+	assert.Greater(t, LevelFatal.SlogLevel(), slog.LevelError)
+}
+
+// fakeLines is a LineSource over a fixed set of lines
+type fakeLines struct {
+	lines  []string
+	err    error
+	i      int
+	closed bool
+}
+
+func (f *fakeLines) Next() bool {
+	if f.i >= len(f.lines) {
+		return false
+	}
+	f.i++
+	return true
+}
+
+func (f *fakeLines) Current() string { return f.lines[f.i-1] }
+func (f *fakeLines) Err() error      { return f.err }
+func (f *fakeLines) Close() error    { f.closed = true; return nil }
+
+// TestDecoder tests decoding with level and field filters
+func TestDecoder(t *testing.T) {
+	src := &fakeLines{lines: []string{
+		`{"level":"debug","msg":"tick","component":"db"}`,
+		`{"level":"warn","msg":"slow query","component":"db"}`,
+		`{"level":"error","msg":"disk full","component":"fs"}`,
+		`panic: runtime error`,
+		`{"level":"error","msg":"connection lost","component":"db"}`,
+	}, err: errors.New("stream reset")}
+
+	dec := NewDecoder(src, DecoderOptions{
+		Filters: []Filter{MinLevel(LevelWarn), FieldEquals("component", "db")},
+	})
+
+	var got []string
+	var streamErr error
+	for rec, err := range dec.All() {
+		if err != nil {
+			streamErr = err
+			continue
+		}
+		got = append(got, rec.Message)
+	}
+	assert.Equal(t, []string{"slow query", "connection lost"}, got)
+	assert.EqualError(t, streamErr, "stream reset")
+	assert.True(t, src.closed)
+
+	// Lines without a level pass MinLevel
+	dec = NewDecoder(&fakeLines{lines: src.lines}, DecoderOptions{Filters: []Filter{MinLevel(LevelError)}})
+	var messages []string
+	for dec.Next() {
+		messages = append(messages, dec.Current().Message)
+	}
+	require.NoError(t, dec.Err())
+	assert.Equal(t, []string{"disk full", "panic: runtime error", "connection lost"}, messages)
+}
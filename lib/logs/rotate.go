@@ -0,0 +1,234 @@
+package logs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat stamps rotated files; it sorts chronologically as text
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotateRetryDelay is how long writes go to the current file after it could
+// not be moved aside, before rotating is tried again
+const rotateRetryDelay = time.Minute
+
+// RotatingFileOptions configures a RotatingFile
+type RotatingFileOptions struct {
+	// Optional: rotate before a write would grow the file past this many bytes (0 disables)
+	MaxSize int64
+	// Optional: rotate before a write once the current file has been open this long (0 disables)
+	MaxAge time.Duration
+	// Optional: number of rotated files to keep, deleting the oldest (0 keeps all)
+	MaxBackups int
+	// Optional: delete rotated files older than this (0 keeps them regardless of age)
+	MaxBackupAge time.Duration
+}
+
+// RotatingFile is an io.WriteCloser that appends to a file and moves it aside
+// when it grows too large or too old. Rotated files are kept next to it as
+// <name>-<UTC timestamp><ext>, e.g. worker-2025-01-02T03-04-05.000.log.
+//
+// A single Write is never split across files, so writing one line per call
+// keeps lines intact. If the file cannot be moved aside, writes keep going to
+// it and rotating is tried again a minute later. It is safe for concurrent
+// use.
+type RotatingFile struct {
+	path   string
+	opts   RotatingFileOptions
+	now    func() time.Time
+	rename func(oldpath, newpath string) error
+
+	mu          sync.Mutex
+	file        *os.File
+	size        int64
+	opened      time.Time
+	rotateAfter time.Time // no rotation before this, after a failed one
+}
+
+// NewRotatingFile opens path for appending, creating it and its directory if
+// needed. An existing file is appended to, and its age counts from now.
+func NewRotatingFile(path string, opts RotatingFileOptions) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create log directory: %w", err)
+	}
+	f := &RotatingFile{path: path, opts: opts, now: time.Now, rename: os.Rename}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p to the current file, rotating first if a limit is reached.
+// If rotating fails, p is still written to whichever file is open, and the
+// rotation error is returned along with the result of the write.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if f.size > 0 && f.due(int64(len(p))) {
+		rotateErr = f.rotate()
+		if f.file == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, errors.Join(rotateErr, err)
+}
+
+// Rotate moves the current file aside and starts a new one, e.g. in response
+// to SIGHUP. An empty file is not rotated.
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	if f.size == 0 {
+		return nil
+	}
+	return f.rotate()
+}
+
+// Close closes the current file. Later writes return os.ErrClosed.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// due reports whether writing n more bytes requires a rotation. f.mu must be held.
+func (f *RotatingFile) due(n int64) bool {
+	if f.now().Before(f.rotateAfter) {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+n > f.opts.MaxSize {
+		return true
+	}
+	return f.opts.MaxAge > 0 && f.now().Sub(f.opened) >= f.opts.MaxAge
+}
+
+// open opens f.path for appending. f.mu must be held or f not yet shared.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.now()
+	return nil
+}
+
+// rotate renames the current file to a backup, opens a new one and prunes
+// old backups. If the file cannot be renamed it is reopened, and rotation is
+// put off for rotateRetryDelay. f.file is nil afterwards only if no file
+// could be opened. f.mu must be held.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		f.rotateAfter = f.now().Add(rotateRetryDelay)
+		return errors.Join(fmt.Errorf("close log file: %w", err), f.open())
+	}
+
+	// Stamps are bumped past existing backups so names stay unique and ordered
+	stamp := f.now().UTC().Truncate(time.Millisecond)
+	for {
+		_, err := os.Lstat(f.backupPath(stamp))
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		stamp = stamp.Add(time.Millisecond)
+	}
+	if err := f.rename(f.path, f.backupPath(stamp)); err != nil {
+		// Keep appending to the current file rather than losing later writes
+		f.rotateAfter = f.now().Add(rotateRetryDelay)
+		return errors.Join(fmt.Errorf("rotate log file: %w", err), f.open())
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.prune()
+}
+
+func (f *RotatingFile) backupPath(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// prune deletes the backups beyond MaxBackups and older than MaxBackupAge.
+func (f *RotatingFile) prune() error {
+	if f.opts.MaxBackups <= 0 && f.opts.MaxBackupAge <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, b := range backups {
+		tooMany := f.opts.MaxBackups > 0 && i >= f.opts.MaxBackups
+		tooOld := f.opts.MaxBackupAge > 0 && f.now().Sub(b.rotated) > f.opts.MaxBackupAge
+		if tooMany || tooOld {
+			if err := os.Remove(b.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("remove old log file: %w", err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type backupFile struct {
+	path    string
+	rotated time.Time
+}
+
+// backups lists the rotated files of f.path, newest first.
+func (f *RotatingFile) backups() ([]backupFile, error) {
+	dir := filepath.Dir(f.path)
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(filepath.Base(f.path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list log files: %w", err)
+	}
+	var backups []backupFile
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, ext)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: filepath.Join(dir, entry.Name()), rotated: t})
+	}
+	slices.SortFunc(backups, func(a, b backupFile) int { return b.rotated.Compare(a.rotated) })
+	return backups, nil
+}
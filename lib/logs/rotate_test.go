@@ -0,0 +1,103 @@
+package logs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRotatingFile tests age based rotation and pruning of backups
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	f, err := NewRotatingFile(path, RotatingFileOptions{MaxAge: time.Hour, MaxBackups: 2})
+	require.NoError(t, err)
+	f.now = func() time.Time { return now }
+	f.opened = now
+
+	for i := range 4 {
+		_, err := fmt.Fprintf(f, "write %d\n", i)
+		require.NoError(t, err)
+		now = now.Add(time.Hour)
+	}
+	require.NoError(t, f.Rotate())
+	require.NoError(t, f.Rotate(), "rotating an empty file is a no-op")
+	require.NoError(t, f.Close())
+	_, err = f.Write([]byte("late\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"app-2025-01-02T06-04-05.000.log", "app-2025-01-02T07-04-05.000.log", "app.log"}, names)
+
+	data, err := os.ReadFile(filepath.Join(dir, "app-2025-01-02T07-04-05.000.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "write 3\n", string(data))
+
+	// Backups older than MaxBackupAge are removed on the next rotation
+	now = now.Add(time.Hour)
+	f, err = NewRotatingFile(path, RotatingFileOptions{MaxBackupAge: 90 * time.Minute})
+	require.NoError(t, err)
+	f.now = func() time.Time { return now }
+	_, err = f.Write([]byte("x\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Rotate())
+	require.NoError(t, f.Close())
+	backups, err := f.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	assert.Equal(t, now, backups[0].rotated)
+}
+
+// TestRotatingFile_RenameFails tests that lines are still written when the
+// file cannot be moved aside, and that rotating is only retried after a delay
+func TestRotatingFile_RenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	f, err := NewRotatingFile(path, RotatingFileOptions{MaxSize: 4})
+	require.NoError(t, err)
+	f.now = func() time.Time { return now }
+	renames := 0
+	f.rename = func(oldpath, newpath string) error {
+		renames++
+		return errors.New("file busy")
+	}
+
+	for _, line := range []string{"a\n", "b\n", "c\n", "d\n"} {
+		n, err := f.Write([]byte(line))
+		assert.Equal(t, 2, n)
+		if line == "c\n" {
+			assert.ErrorContains(t, err, "file busy")
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, 1, renames, "rotating is not retried on every write")
+
+	now = now.Add(rotateRetryDelay)
+	f.rename = os.Rename
+	_, err = f.Write([]byte("e\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "e\n", string(data))
+	backups, err := f.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	data, err = os.ReadFile(backups[0].path)
+	require.NoError(t, err)
+	assert.Equal(t, "a\nb\nc\nd\n", string(data))
+}
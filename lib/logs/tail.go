@@ -0,0 +1,410 @@
+// Package logs provides helpers for consuming instance logs: following many
+// instances at once, decoding structured lines, and piping logs to writers,
+// files and slog handlers.
+package logs
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib/watch"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/kernel/hypeman-go/packages/param"
+)
+
+// defaultSources are the log sources followed when TailOptions.Sources is empty
+var defaultSources = []hypeman.InstanceLogsParamsSource{
+	hypeman.InstanceLogsParamsSourceApp,
+	hypeman.InstanceLogsParamsSourceVmm,
+	hypeman.InstanceLogsParamsSourceHypeman,
+}
+
+// defaultInterval is how often instances are listed by default
+const defaultInterval = 5 * time.Second
+
+// restartReplayLines is how many lines are read again when a stream that
+// ended is followed again, to find the lines written since
+const restartReplayLines = 1000
+
+// seenLines is how many of the last lines forwarded from each stream are kept
+// to find where the stream left off. Log lines carry no timestamps or
+// sequence numbers, so this is matched by text alone.
+const seenLines = 5
+
+// prefixColors are the ANSI colors used for instance name prefixes
+var prefixColors = []string{"31", "32", "33", "34", "35", "36", "91", "92", "93", "94", "95", "96"}
+
+// Line is a single log line from one instance and source
+type Line struct {
+	InstanceID   string
+	InstanceName string
+	Source       hypeman.InstanceLogsParamsSource
+	Text         string
+	Received     time.Time
+}
+
+// TailOptions configures Tail
+type TailOptions struct {
+	// Optional: only follow instances carrying all of these tags (defaults to all instances)
+	Selector map[string]string
+	// Optional: log sources to follow for each instance (defaults to app, vmm and hypeman)
+	Sources []hypeman.InstanceLogsParamsSource
+	// Optional: number of existing lines to print from each stream when an
+	// instance is first seen (defaults to the server's default). Streams that
+	// end, because the instance stopped or the connection dropped, are
+	// followed again from where they left off once the instance is seen
+	// running: up to their last 1000 lines are read again and the ones already
+	// forwarded are dropped. Log lines carry no timestamps or sequence numbers,
+	// so those are found by the text of the last few lines forwarded. If those
+	// lines repeat, e.g. heartbeats or blank lines, lines written in between
+	// can be dropped, or old ones forwarded again.
+	TailLines int64
+	// Optional: how often to look for new and deleted instances, and for
+	// streams to follow again (defaults to 5s)
+	Interval time.Duration
+	// Optional: disable ANSI colors in prefixes
+	NoColor bool
+	// Optional: called with every line instead of writing it to the output
+	OnLine func(line Line)
+	// Optional: called when listing instances or following a stream fails
+	OnError func(err error)
+	// Optional: request options for each log stream, e.g. option.WithStreamReconnect
+	RequestOptions []option.RequestOption
+}
+
+// Tail follows the logs of every instance matching opts.Selector and writes
+// them to w as they arrive, one line at a time, each prefixed with the
+// instance name in a color derived from it and the log source. Instances that
+// start matching are picked up, and deleted ones are dropped, as they are
+// noticed. Tail blocks until ctx is cancelled and then returns nil.
+//
+// Example:
+//
+//	err := logs.Tail(ctx, &client.Instances, os.Stdout, logs.TailOptions{
+//	    Selector: map[string]string{"pool": "workers"},
+//	})
+func Tail(ctx context.Context, instances hypeman.InstancesAPI, w io.Writer, opts TailOptions) error {
+	if len(opts.Sources) == 0 {
+		opts.Sources = defaultSources
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t := &tailer{
+		ctx:       ctx,
+		instances: instances,
+		opts:      opts,
+		lines:     make(chan Line, 64),
+		followers: map[string]*follower{},
+		seen:      map[streamKey][]string{},
+	}
+
+	// Resyncing on every poll re-delivers running instances whose streams
+	// dropped without any watched field changing
+	inf := watch.NewInformer(instances, watch.Options{
+		Params:         hypeman.InstanceListParams{Tags: opts.Selector},
+		Interval:       opts.Interval,
+		ResyncInterval: opts.Interval,
+		OnEvent:        t.handle,
+		OnError:        opts.OnError,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		inf.Run(ctx)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			<-done
+			t.wg.Wait()
+			return nil
+		case line := <-t.lines:
+			if opts.OnLine != nil {
+				opts.OnLine(line)
+				continue
+			}
+			if _, err := io.WriteString(w, FormatLine(line, !opts.NoColor)); err != nil {
+				cancel()
+				<-done
+				t.wg.Wait()
+				return fmt.Errorf("write log line: %w", err)
+			}
+		}
+	}
+}
+
+// FormatLine renders a line as "<name> <source> <text>\n", coloring the
+// instance name when color is true.
+func FormatLine(line Line, color bool) string {
+	name := line.InstanceName
+	if name == "" {
+		name = line.InstanceID
+	}
+	if color {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		code := prefixColors[h.Sum32()%uint32(len(prefixColors))]
+		return fmt.Sprintf("\x1b[%sm%s\x1b[0m \x1b[2m%s\x1b[0m %s\n", code, name, line.Source, line.Text)
+	}
+	return fmt.Sprintf("%s %s %s\n", name, line.Source, line.Text)
+}
+
+// tailer tracks the log streams followed for each matching instance
+type tailer struct {
+	ctx       context.Context
+	instances hypeman.InstancesAPI
+	opts      TailOptions
+	lines     chan Line
+	wg        sync.WaitGroup
+
+	mu        sync.Mutex
+	followers map[string]*follower
+	seen      map[streamKey][]string // the last lines forwarded from ended streams
+}
+
+// streamKey identifies the stream of one source of an instance
+type streamKey struct {
+	instanceID string
+	source     hypeman.InstanceLogsParamsSource
+}
+
+// follower is the set of streams for one instance
+type follower struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	active map[hypeman.InstanceLogsParamsSource]bool // sources with a stream running
+}
+
+// handle starts and stops streams as instances come and go.
+func (t *tailer) handle(event watch.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	inst := event.Instance
+	switch event.Type {
+	case watch.Added:
+		tail := param.Opt[int64]{}
+		if t.opts.TailLines > 0 {
+			tail = hypeman.Int(t.opts.TailLines)
+		}
+		t.follow(inst, tail, false)
+	case watch.Updated:
+		// Streams end when an instance stops or their connection drops; follow
+		// them again while it is running, picking up after the lines that were
+		// already forwarded
+		if inst.State == hypeman.InstanceStateRunning {
+			t.follow(inst, param.Opt[int64]{}, true)
+		}
+	case watch.Deleted:
+		if f := t.followers[inst.ID]; f != nil {
+			f.cancel()
+			delete(t.followers, inst.ID)
+		}
+		for _, source := range t.opts.Sources {
+			delete(t.seen, streamKey{inst.ID, source})
+		}
+	}
+}
+
+// follow starts a stream for each source of inst that has none running. With
+// resume set, each stream first catches up on the lines written since the
+// source's last stream ended. t.mu must be held.
+func (t *tailer) follow(inst hypeman.Instance, tail param.Opt[int64], resume bool) {
+	f := t.followers[inst.ID]
+	if f == nil {
+		ctx, cancel := context.WithCancel(t.ctx)
+		f = &follower{ctx: ctx, cancel: cancel, active: map[hypeman.InstanceLogsParamsSource]bool{}}
+		t.followers[inst.ID] = f
+	}
+
+	for _, source := range t.opts.Sources {
+		if f.active[source] {
+			continue
+		}
+		f.active[source] = true
+		ctx := f.ctx
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+
+			s := &sourceStream{tailer: t, follower: f, inst: inst, source: source}
+			defer s.ended()
+			params := hypeman.InstanceLogsParams{
+				Follow: hypeman.Bool(true),
+				Tail:   tail,
+				Source: source,
+			}
+			var after []string
+			if resume {
+				var err error
+				after, err = s.catchUp(ctx)
+				switch {
+				case ctx.Err() != nil:
+					return
+				case err != nil:
+					// Follow from new lines only rather than replay old ones
+					s.reportError(ctx, err)
+					params.Tail = hypeman.Int(0)
+				default:
+					params.Tail = hypeman.Int(restartReplayLines)
+				}
+			}
+			s.follow(ctx, params, after)
+		}()
+	}
+}
+
+// sourceStream forwards the lines of one source of an instance
+type sourceStream struct {
+	tailer   *tailer
+	follower *follower
+	inst     hypeman.Instance
+	source   hypeman.InstanceLogsParamsSource
+	recent   []string // the last seenLines lines forwarded
+}
+
+// catchUp forwards the lines written since the source's last stream ended. It
+// reads the last restartReplayLines lines again and drops those up to the last
+// lines that stream forwarded, or none if they are not among them. It returns
+// the last lines it read, which follow skips up to.
+func (s *sourceStream) catchUp(ctx context.Context) ([]string, error) {
+	t := s.tailer
+	t.mu.Lock()
+	s.recent = slices.Clone(t.seen[streamKey{s.inst.ID, s.source}])
+	t.mu.Unlock()
+
+	params := hypeman.InstanceLogsParams{Tail: hypeman.Int(restartReplayLines), Source: s.source}
+	stream := t.instances.LogsStreaming(ctx, s.inst.ID, params, t.opts.RequestOptions...)
+	var lines []string
+	for stream.Next() {
+		lines = append(lines, strings.TrimRight(stream.Current(), "\r\n"))
+	}
+	err := stream.Err()
+	stream.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if i := lastIndex(lines, s.recent); i >= 0 {
+		start = i + len(s.recent)
+	}
+	if !s.forward(ctx, lines[start:]...) {
+		return nil, ctx.Err()
+	}
+	return lines[max(0, len(lines)-seenLines):], nil
+}
+
+// follow forwards a log stream to t.lines until it ends or ctx is cancelled.
+// When after is set, lines are held until after has been seen and those up to
+// it are dropped; if after is not among the first restartReplayLines lines
+// the held lines are forwarded.
+func (s *sourceStream) follow(ctx context.Context, params hypeman.InstanceLogsParams, after []string) {
+	t := s.tailer
+	stream := t.instances.LogsStreaming(ctx, s.inst.ID, params, t.opts.RequestOptions...)
+	defer stream.Close()
+
+	var held []string
+	for stream.Next() {
+		text := strings.TrimRight(stream.Current(), "\r\n")
+		if len(after) == 0 {
+			if !s.forward(ctx, text) {
+				return
+			}
+			continue
+		}
+		held = append(held, text)
+		switch {
+		case hasSuffix(held, after):
+			held, after = nil, nil
+		case len(held) >= restartReplayLines:
+			if !s.forward(ctx, held...) {
+				return
+			}
+			held, after = nil, nil
+		}
+	}
+	if !s.forward(ctx, held...) {
+		return
+	}
+	if err := stream.Err(); err != nil {
+		s.reportError(ctx, err)
+	}
+}
+
+// forward sends lines to t.lines, returning false if ctx ended first.
+func (s *sourceStream) forward(ctx context.Context, texts ...string) bool {
+	for _, text := range texts {
+		line := Line{
+			InstanceID:   s.inst.ID,
+			InstanceName: s.inst.Name,
+			Source:       s.source,
+			Text:         text,
+			Received:     time.Now(),
+		}
+		select {
+		case s.tailer.lines <- line:
+		case <-ctx.Done():
+			return false
+		}
+		if len(s.recent) == seenLines {
+			s.recent = s.recent[1:]
+		}
+		s.recent = append(s.recent, text)
+	}
+	return true
+}
+
+// ended marks the stream as no longer running and records the last lines it
+// forwarded, for the next stream of the source, unless the instance has been
+// dropped since.
+func (s *sourceStream) ended() {
+	t := s.tailer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.followers[s.inst.ID] != s.follower {
+		return
+	}
+	s.follower.active[s.source] = false
+	if len(s.recent) > 0 {
+		t.seen[streamKey{s.inst.ID, s.source}] = s.recent
+	}
+}
+
+func (s *sourceStream) reportError(ctx context.Context, err error) {
+	if ctx.Err() == nil && s.tailer.opts.OnError != nil {
+		s.tailer.opts.OnError(fmt.Errorf("follow %s logs of %s: %w", s.source, s.inst.ID, err))
+	}
+}
+
+// lastIndex returns the index of the last occurrence of seq in lines, or -1
+// if seq is empty or does not occur.
+func lastIndex(lines, seq []string) int {
+	if len(seq) == 0 {
+		return -1
+	}
+	for i := len(lines) - len(seq); i >= 0; i-- {
+		if slices.Equal(lines[i:i+len(seq)], seq) {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasSuffix reports whether lines ends with seq.
+func hasSuffix(lines, seq []string) bool {
+	return len(lines) >= len(seq) && slices.Equal(lines[len(lines)-len(seq):], seq)
+}
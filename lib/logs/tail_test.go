@@ -0,0 +1,300 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib/hypemanmock"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/kernel/hypeman-go/packages/ssestream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLogs is a mock of the instances service that lists a replaceable set
+// of instances and streams two log lines per instance and source, then holds
+// the stream open until the client leaves.
+type fakeLogs struct {
+	*hypemanmock.Instances
+	mu        sync.Mutex
+	instances string
+	closed    map[string]bool // instance IDs whose streams were closed by the client
+}
+
+func newFakeLogs(instances string) *fakeLogs {
+	f := &fakeLogs{Instances: hypemanmock.New().Instances, instances: instances, closed: map[string]bool{}}
+	f.ListFunc = func(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) (*[]hypeman.Instance, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		list := hypemanmock.FromJSON[[]hypeman.Instance](f.instances)
+		return &list, nil
+	}
+	f.LogsStreamingFunc = func(ctx context.Context, id string, params hypeman.InstanceLogsParams, opts ...option.RequestOption) *ssestream.Stream[string] {
+		lines := make(chan string, 2)
+		for i := 1; i <= 2; i++ {
+			lines <- fmt.Sprintf("%s %s line %d\n", id, params.Source, i)
+		}
+		go func() {
+			<-ctx.Done()
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.closed[id] = true
+		}()
+		return hypemanmock.Stream(ctx, lines)
+	}
+	return f
+}
+
+func (f *fakeLogs) setInstances(body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instances = body
+}
+
+func (f *fakeLogs) wasClosed(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed[id]
+}
+
+// lineCollector gathers lines from concurrent callbacks
+type lineCollector struct {
+	mu    sync.Mutex
+	lines []Line
+}
+
+func (c *lineCollector) add(line Line) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, line)
+}
+
+func (c *lineCollector) count(instanceID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, line := range c.lines {
+		if line.InstanceID == instanceID {
+			n++
+		}
+	}
+	return n
+}
+
+// TestTail tests following matching instances and picking up and dropping instances
+func TestTail(t *testing.T) {
+	instances := newFakeLogs(`[{"id":"a","name":"worker-a","state":"Running"}]`)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	var got lineCollector
+	done := make(chan error)
+	go func() {
+		done <- Tail(ctx, instances, nil, TailOptions{
+			Selector: map[string]string{"pool": "workers"},
+			Interval: 10 * time.Millisecond,
+			OnLine:   got.add,
+			OnError:  func(err error) { t.Errorf("unexpected error: %v", err) },
+		})
+	}()
+
+	// Two lines from each of the three default sources
+	require.Eventually(t, func() bool { return got.count("a") == 6 }, 5*time.Second, 5*time.Millisecond)
+
+	instances.setInstances(`[{"id":"b","name":"worker-b","state":"Running"}]`)
+	require.Eventually(t, func() bool { return got.count("b") == 6 }, 5*time.Second, 5*time.Millisecond)
+	require.Eventually(t, func() bool { return instances.wasClosed("a") }, 5*time.Second, 5*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	got.mu.Lock()
+	defer got.mu.Unlock()
+	line := got.lines[0]
+	assert.Equal(t, "worker-a", line.InstanceName)
+	assert.Equal(t, fmt.Sprintf("a %s line 1", line.Source), line.Text)
+	instances.AssertCalled(t, "List", hypeman.InstanceListParams{Tags: map[string]string{"pool": "workers"}})
+}
+
+// restartLogs is a mock of the instances service with one instance and an
+// append-only app log. Followed streams end when the instance stops, like the
+// real server's.
+type restartLogs struct {
+	*hypemanmock.Instances
+	mu      sync.Mutex
+	state   string
+	log     []string
+	changed chan struct{} // closed and replaced when state or log change
+	dropped chan struct{} // closed and replaced to end followed streams
+}
+
+func newRestartLogs(state string, log ...string) *restartLogs {
+	f := &restartLogs{Instances: hypemanmock.New().Instances, state: state, log: log, changed: make(chan struct{}), dropped: make(chan struct{})}
+	f.ListFunc = func(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) (*[]hypeman.Instance, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		list := []hypeman.Instance{{ID: "a", Name: "worker-a", State: hypeman.InstanceState(f.state)}}
+		return &list, nil
+	}
+	f.LogsStreamingFunc = func(ctx context.Context, id string, params hypeman.InstanceLogsParams, opts ...option.RequestOption) *ssestream.Stream[string] {
+		lines := make(chan string)
+		go f.stream(ctx, params, lines)
+		return hypemanmock.Stream(ctx, lines)
+	}
+	return f
+}
+
+func (f *restartLogs) stream(ctx context.Context, params hypeman.InstanceLogsParams, lines chan<- string) {
+	defer close(lines)
+	f.mu.Lock()
+	sent := max(0, len(f.log)-int(params.Tail.Or(int64(len(f.log)))))
+	dropped := f.dropped
+	f.mu.Unlock()
+
+	for {
+		f.mu.Lock()
+		unsent, state, changed := f.log[sent:], f.state, f.changed
+		sent = len(f.log)
+		f.mu.Unlock()
+		for _, line := range unsent {
+			select {
+			case lines <- line + "\n":
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !params.Follow.Or(false) || state != "Running" {
+			return
+		}
+		select {
+		case <-changed:
+		case <-dropped:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (f *restartLogs) update(state string, lines ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state = state
+	f.log = append(f.log, lines...)
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// disconnect ends the followed streams, as a dropped connection would, and
+// appends lines while none is open.
+func (f *restartLogs) disconnect(lines ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.dropped)
+	f.dropped = make(chan struct{})
+	f.log = append(f.log, lines...)
+}
+
+func (f *restartLogs) listCount() int {
+	return len(f.CallsTo("List"))
+}
+
+// TestTail_Restart tests that lines an instance writes before it is noticed
+// running again are forwarded once
+func TestTail_Restart(t *testing.T) {
+	instances := newRestartLogs("Running", "boot", "ready")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	var got lineCollector
+	done := make(chan error)
+	go func() {
+		done <- Tail(ctx, instances, nil, TailOptions{
+			Sources:  []hypeman.InstanceLogsParamsSource{hypeman.InstanceLogsParamsSourceApp},
+			Interval: 10 * time.Millisecond,
+			OnLine:   got.add,
+			OnError:  func(err error) { t.Errorf("unexpected error: %v", err) },
+		})
+	}()
+	require.Eventually(t, func() bool { return got.count("a") == 2 }, 5*time.Second, 5*time.Millisecond)
+
+	instances.update("Stopped", "crash")
+	require.Eventually(t, func() bool { return got.count("a") == 3 }, 5*time.Second, 5*time.Millisecond)
+	lists := instances.listCount()
+	require.Eventually(t, func() bool { return instances.listCount() > lists+1 }, 5*time.Second, 5*time.Millisecond)
+
+	// The new boot logs before the instance is seen running again
+	instances.update("Running", "boot", "panic: config missing")
+	require.Eventually(t, func() bool { return got.count("a") == 5 }, 5*time.Second, 5*time.Millisecond)
+	instances.update("Running", "retrying")
+	require.Eventually(t, func() bool { return got.count("a") == 6 }, 5*time.Second, 5*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	got.mu.Lock()
+	defer got.mu.Unlock()
+	var texts []string
+	for _, line := range got.lines {
+		texts = append(texts, line.Text)
+	}
+	assert.Equal(t, []string{"boot", "ready", "crash", "boot", "panic: config missing", "retrying"}, texts)
+}
+
+// TestTail_Dropped tests that a stream that ends while its instance keeps
+// running is followed again, and the lines written meanwhile are forwarded once
+func TestTail_Dropped(t *testing.T) {
+	instances := newRestartLogs("Running", "boot", "ready")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	var got lineCollector
+	done := make(chan error)
+	go func() {
+		done <- Tail(ctx, instances, nil, TailOptions{
+			Sources:  []hypeman.InstanceLogsParamsSource{hypeman.InstanceLogsParamsSourceApp},
+			Interval: 10 * time.Millisecond,
+			OnLine:   got.add,
+			OnError:  func(err error) { t.Errorf("unexpected error: %v", err) },
+		})
+	}()
+	require.Eventually(t, func() bool { return got.count("a") == 2 }, 5*time.Second, 5*time.Millisecond)
+
+	instances.disconnect("while disconnected")
+	require.Eventually(t, func() bool { return got.count("a") == 3 }, 5*time.Second, 5*time.Millisecond)
+	instances.update("Running", "after")
+	require.Eventually(t, func() bool { return got.count("a") == 4 }, 5*time.Second, 5*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	got.mu.Lock()
+	defer got.mu.Unlock()
+	var texts []string
+	for _, line := range got.lines {
+		texts = append(texts, line.Text)
+	}
+	assert.Equal(t, []string{"boot", "ready", "while disconnected", "after"}, texts)
+}
+
+// TestFormatLine tests prefixes with and without color
+func TestFormatLine(t *testing.T) {
+	line := Line{InstanceID: "a", InstanceName: "worker-a", Source: hypeman.InstanceLogsParamsSourceApp, Text: "hello"}
+	assert.Equal(t, "worker-a app hello\n", FormatLine(line, false))
+
+	colored := FormatLine(line, true)
+	assert.True(t, strings.HasPrefix(colored, "\x1b["))
+	assert.Contains(t, colored, "worker-a\x1b[0m")
+	assert.Equal(t, colored, FormatLine(line, true), "colors are stable per name")
+
+	line.InstanceName = ""
+	assert.Equal(t, "a app hello\n", FormatLine(line, false))
+}
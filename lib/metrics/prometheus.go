@@ -0,0 +1,347 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+)
+
+const (
+	// defaultNamespace prefixes every exported metric name by default
+	defaultNamespace = "hypeman"
+	// defaultScrapeTimeout bounds the API calls made for one scrape by default
+	defaultScrapeTimeout = 10 * time.Second
+	// maxConcurrentStats limits the Stats calls in flight during a scrape
+	maxConcurrentStats = 8
+	// contentTypeText is the Prometheus text exposition format
+	contentTypeText = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// ExporterOptions configures an Exporter
+type ExporterOptions struct {
+	// Optional: prefix of every metric name (defaults to "hypeman")
+	Namespace string
+	// Optional: which instances to export stats for (defaults to all). Only
+	// running instances report stats.
+	Instances hypeman.InstanceListParams
+	// Optional: tag keys added as labels to instance metrics, named tag_<key>
+	// with characters Prometheus does not allow replaced by underscores. Keys
+	// are named in sorted order, and a key whose name is already taken, e.g.
+	// app-name after app.name, gets _2, _3 and so on appended.
+	Tags []string
+	// Optional: time limit for the API calls of one scrape (defaults to 10s)
+	Timeout time.Duration
+	// Optional: called when an API call fails during a scrape. The scrape
+	// still succeeds with the metrics that could be collected.
+	OnError func(err error)
+}
+
+// Exporter serves host resources and instance stats in the Prometheus text
+// exposition format. Every scrape calls Resources.Get, Instances.List and
+// Instances.Stats for each running instance.
+//
+// Example:
+//
+//	http.Handle("/metrics", metrics.NewExporter(client.API(), metrics.ExporterOptions{
+//	    Tags: []string{"team", "env"},
+//	}))
+type Exporter struct {
+	api       hypeman.API
+	opts      ExporterOptions
+	tagLabels []tagLabel
+}
+
+// tagLabel is the label an instance tag is exported as
+type tagLabel struct {
+	key  string
+	name string
+}
+
+// NewExporter returns an Exporter that queries api's Resources and Instances
+// on every scrape.
+func NewExporter(api hypeman.API, opts ExporterOptions) *Exporter {
+	if opts.Namespace == "" {
+		opts.Namespace = defaultNamespace
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultScrapeTimeout
+	}
+	return &Exporter{api: api, opts: opts, tagLabels: tagLabelNames(opts.Tags)}
+}
+
+// tagLabelNames names the labels of tag keys, sorted by key, so that keys that
+// sanitize to the same name still get distinct labels.
+func tagLabelNames(keys []string) []tagLabel {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	taken := map[string]bool{"instance_id": true, "instance_name": true}
+	labels := make([]tagLabel, 0, len(keys))
+	for _, key := range keys {
+		base := "tag_" + sanitizeLabelName(key)
+		name := base
+		for i := 2; taken[name]; i++ {
+			name = base + "_" + strconv.Itoa(i)
+		}
+		taken[name] = true
+		labels = append(labels, tagLabel{key: key, name: name})
+	}
+	return labels
+}
+
+// ServeHTTP writes the current metrics.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := e.Collect(r.Context(), &buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeText)
+	w.Write(buf.Bytes())
+}
+
+// Collect queries the API and writes the metrics to w. API failures are
+// reported to OnError and through the <namespace>_up gauge; only write
+// errors are returned.
+func (e *Exporter) Collect(ctx context.Context, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, e.opts.Timeout)
+	defer cancel()
+
+	m := &metricSet{namespace: e.opts.Namespace}
+	up := 1.0
+	if res, err := e.api.Resources.Get(ctx); err != nil {
+		up = 0
+		e.report(fmt.Errorf("get resources: %w", err))
+	} else {
+		m.addResources(res)
+	}
+	if err := e.collectInstances(ctx, m); err != nil {
+		up = 0
+		e.report(err)
+	}
+	m.gauge("up", "Whether the last scrape of the hypeman API succeeded.").add(nil, up)
+
+	return m.write(w)
+}
+
+// collectInstances adds stats for every running instance. Failures for single
+// instances are reported without failing the scrape.
+func (e *Exporter) collectInstances(ctx context.Context, m *metricSet) error {
+	list, err := e.api.Instances.List(ctx, e.opts.Instances)
+	if err != nil {
+		return fmt.Errorf("list instances: %w", err)
+	}
+	var running []hypeman.Instance
+	if list != nil {
+		for _, inst := range *list {
+			if inst.State == hypeman.InstanceStateRunning {
+				running = append(running, inst)
+			}
+		}
+	}
+
+	stats := make([]*hypeman.InstanceStats, len(running))
+	sem := make(chan struct{}, maxConcurrentStats)
+	var wg sync.WaitGroup
+	for i, inst := range running {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			s, err := e.api.Instances.Stats(ctx, inst.ID)
+			if err != nil {
+				e.report(fmt.Errorf("get stats of %s: %w", inst.ID, err))
+				return
+			}
+			stats[i] = s
+		}()
+	}
+	wg.Wait()
+
+	for i, inst := range running {
+		if stats[i] != nil {
+			m.addInstance(*stats[i], e.instanceLabels(inst))
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) instanceLabels(inst hypeman.Instance) []label {
+	labels := []label{{"instance_id", inst.ID}, {"instance_name", inst.Name}}
+	for _, tag := range e.tagLabels {
+		labels = append(labels, label{tag.name, inst.Tags[tag.key]})
+	}
+	return labels
+}
+
+func (e *Exporter) report(err error) {
+	if e.opts.OnError != nil {
+		e.opts.OnError(err)
+	}
+}
+
+// addResources adds host capacity and allocation metrics.
+func (m *metricSet) addResources(res *hypeman.Resources) {
+	statuses := []struct {
+		name   string
+		status hypeman.ResourceStatus
+		valid  bool
+	}{
+		{"cpu", res.CPU, res.JSON.CPU.Valid()},
+		{"memory", res.Memory, res.JSON.Memory.Valid()},
+		{"disk", res.Disk, res.JSON.Disk.Valid()},
+		{"network", res.Network, res.JSON.Network.Valid()},
+		{"disk_io", res.DiskIo, res.JSON.DiskIo.Valid()},
+	}
+	capacity := m.gauge("resource_capacity", "Raw host capacity, in vCPUs for cpu, bytes for memory and disk, and bytes per second for network and disk_io.")
+	limit := m.gauge("resource_effective_limit", "Host capacity after oversubscription.")
+	allocated := m.gauge("resource_allocated", "Capacity allocated to instances.")
+	available := m.gauge("resource_available", "Capacity still available for allocation.")
+	ratio := m.gauge("resource_oversubscription_ratio", "Oversubscription ratio applied to the capacity.")
+	for _, s := range statuses {
+		if !s.valid {
+			continue
+		}
+		labels := []label{{"resource", s.name}}
+		capacity.add(labels, float64(s.status.Capacity))
+		limit.add(labels, float64(s.status.EffectiveLimit))
+		allocated.add(labels, float64(s.status.Allocated))
+		available.add(labels, float64(s.status.Available))
+		ratio.add(labels, s.status.OversubRatio)
+	}
+
+	if res.JSON.DiskBreakdown.Valid() {
+		disk := m.gauge("disk_usage_bytes", "Disk used on the host, by kind of data.")
+		disk.add([]label{{"kind", "images"}}, float64(res.DiskBreakdown.ImagesBytes))
+		disk.add([]label{{"kind", "oci_cache"}}, float64(res.DiskBreakdown.OciCacheBytes))
+		disk.add([]label{{"kind", "overlays"}}, float64(res.DiskBreakdown.OverlaysBytes))
+		disk.add([]label{{"kind", "volumes"}}, float64(res.DiskBreakdown.VolumesBytes))
+	}
+
+	if res.JSON.GPU.Valid() {
+		labels := []label{{"mode", string(res.GPU.Mode)}}
+		m.gauge("gpu_slots", "GPU slots on the host: VFs in vgpu mode, physical GPUs in passthrough mode.").add(labels, float64(res.GPU.TotalSlots))
+		m.gauge("gpu_slots_used", "GPU slots in use.").add(labels, float64(res.GPU.UsedSlots))
+	}
+}
+
+// addInstance adds the stats of one instance.
+func (m *metricSet) addInstance(stats hypeman.InstanceStats, labels []label) {
+	m.counter("instance_cpu_seconds_total", "CPU time consumed by the instance's hypervisor process.").add(labels, stats.CPUSeconds)
+	m.counter("instance_network_receive_bytes_total", "Bytes received by the instance.").add(labels, float64(stats.NetworkRxBytes))
+	m.counter("instance_network_transmit_bytes_total", "Bytes transmitted by the instance.").add(labels, float64(stats.NetworkTxBytes))
+	m.gauge("instance_memory_rss_bytes", "Physical memory used by the instance.").add(labels, float64(stats.MemoryRssBytes))
+	m.gauge("instance_memory_vms_bytes", "Virtual memory size of the instance.").add(labels, float64(stats.MemoryVmsBytes))
+	m.gauge("instance_memory_allocated_bytes", "Memory allocated to the instance.").add(labels, float64(stats.AllocatedMemoryBytes))
+	m.gauge("instance_memory_utilization_ratio", "Physical memory used over memory allocated.").add(labels, memoryUtilization(stats))
+	m.gauge("instance_vcpus", "vCPUs allocated to the instance.").add(labels, float64(stats.AllocatedVcpus))
+}
+
+// label is a Prometheus label name and value
+type label struct {
+	name, value string
+}
+
+// metricSet collects metric families in the order they are first used
+type metricSet struct {
+	namespace string
+	families  []*family
+}
+
+type family struct {
+	name, help, kind string
+	samples          []metricSample
+}
+
+type metricSample struct {
+	labels []label
+	value  float64
+}
+
+func (m *metricSet) gauge(name, help string) *family   { return m.family(name, help, "gauge") }
+func (m *metricSet) counter(name, help string) *family { return m.family(name, help, "counter") }
+
+func (m *metricSet) family(name, help, kind string) *family {
+	name = m.namespace + "_" + name
+	for _, f := range m.families {
+		if f.name == name {
+			return f
+		}
+	}
+	f := &family{name: name, help: help, kind: kind}
+	m.families = append(m.families, f)
+	return f
+}
+
+func (f *family) add(labels []label, value float64) {
+	f.samples = append(f.samples, metricSample{labels: labels, value: value})
+}
+
+// write renders every family that has samples in the text exposition format.
+func (m *metricSet) write(w io.Writer) error {
+	var b strings.Builder
+	for _, f := range m.families {
+		if len(f.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.kind)
+		for _, s := range f.samples {
+			b.WriteString(f.name)
+			if len(s.labels) > 0 {
+				b.WriteByte('{')
+				for i, l := range s.labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", l.name, escapeLabelValue(l.value))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(formatValue(s.value))
+			b.WriteByte('\n')
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// formatValue writes whole numbers such as byte counts without an exponent.
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelValueEscaper.Replace(s) }
+
+// sanitizeLabelName replaces characters not allowed in label names.
+func sanitizeLabelName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
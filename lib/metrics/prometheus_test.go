@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib/hypemanmock"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testResources = `{
+	"allocations": [],
+	"cpu": {"type":"cpu","capacity":16,"effective_limit":32,"allocated":6,"available":26,"oversub_ratio":2},
+	"memory": {"type":"memory","capacity":68719476736,"effective_limit":68719476736,"allocated":4294967296,"available":64424509440,"oversub_ratio":1},
+	"disk": {"type":"disk","capacity":1000,"effective_limit":1000,"allocated":100,"available":900,"oversub_ratio":1},
+	"network": {"type":"network","capacity":125000000,"effective_limit":125000000,"allocated":0,"available":125000000,"oversub_ratio":1},
+	"disk_breakdown": {"images_bytes":10,"oci_cache_bytes":20,"overlays_bytes":30,"volumes_bytes":40},
+	"gpu": {"mode":"passthrough","total_slots":2,"used_slots":1}
+}`
+
+// TestExporter tests the text exposition of resources and instance stats
+func TestExporter(t *testing.T) {
+	m := hypemanmock.New()
+	m.Resources.GetFunc = func(ctx context.Context, opts ...option.RequestOption) (*hypeman.Resources, error) {
+		return hypemanmock.FromJSON[*hypeman.Resources](testResources), nil
+	}
+	m.Instances.ListFunc = func(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) (*[]hypeman.Instance, error) {
+		list := hypemanmock.FromJSON[[]hypeman.Instance](`[
+			{"id":"a","name":"web \"1\"","state":"Running","tags":{"team":"core","cost-center":"42"}},
+			{"id":"b","name":"batch","state":"Stopped"},
+			{"id":"c","name":"gone","state":"Running"}
+		]`)
+		return &list, nil
+	}
+	m.Instances.StatsFunc = func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.InstanceStats, error) {
+		if id != "a" {
+			return nil, fmt.Errorf("instance %s: %w", id, hypeman.ErrNotFound)
+		}
+		return hypemanmock.FromJSON[*hypeman.InstanceStats](`{"instance_id":"a","instance_name":"web","cpu_seconds":12.5,"network_rx_bytes":100,"network_tx_bytes":200,"memory_rss_bytes":512,"memory_vms_bytes":2048,"allocated_memory_bytes":1024,"allocated_vcpus":2,"memory_utilization_ratio":0.5}`), nil
+	}
+
+	var errs []error
+	exporter := NewExporter(m.API(), ExporterOptions{
+		Tags:    []string{"team", "cost-center"},
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+	rec := httptest.NewRecorder()
+	exporter.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, contentTypeText, rec.Header().Get("Content-Type"))
+
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	out := string(body)
+
+	for _, line := range []string{
+		"# TYPE hypeman_resource_capacity gauge",
+		`hypeman_resource_capacity{resource="cpu"} 16`,
+		`hypeman_resource_capacity{resource="memory"} 68719476736`,
+		`hypeman_resource_oversubscription_ratio{resource="cpu"} 2`,
+		`hypeman_disk_usage_bytes{kind="oci_cache"} 20`,
+		`hypeman_gpu_slots{mode="passthrough"} 2`,
+		`hypeman_gpu_slots_used{mode="passthrough"} 1`,
+		"# TYPE hypeman_instance_cpu_seconds_total counter",
+		`hypeman_instance_cpu_seconds_total{instance_id="a",instance_name="web \"1\"",tag_cost_center="42",tag_team="core"} 12.5`,
+		`hypeman_instance_memory_utilization_ratio{instance_id="a",instance_name="web \"1\"",tag_cost_center="42",tag_team="core"} 0.5`,
+		"hypeman_up 1",
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+	assert.NotContains(t, out, `resource="disk_io"`, "absent resources are skipped")
+	assert.NotContains(t, out, `instance_id="b"`, "stopped instances have no stats")
+	assert.NotContains(t, out, `instance_id="c"`)
+
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "get stats of c")
+}
+
+// TestExporter_Down tests that API failures are reported through the up gauge
+func TestExporter_Down(t *testing.T) {
+	m := hypemanmock.New()
+	m.Resources.GetFunc = func(ctx context.Context, opts ...option.RequestOption) (*hypeman.Resources, error) {
+		return nil, errors.New("connection refused")
+	}
+	m.Instances.ListFunc = func(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) (*[]hypeman.Instance, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	var out strings.Builder
+	require.NoError(t, NewExporter(m.API(), ExporterOptions{Namespace: "hv"}).Collect(t.Context(), &out))
+	assert.Equal(t, "# HELP hv_up Whether the last scrape of the hypeman API succeeded.\n# TYPE hv_up gauge\nhv_up 0\n", out.String())
+}
+
+// TestTagLabelNames tests that tag keys sanitizing to the same name get
+// distinct labels, whatever order they are given in
+func TestTagLabelNames(t *testing.T) {
+	want := []tagLabel{
+		{"app-name", "tag_app_name"},
+		{"app.name", "tag_app_name_2"},
+		{"app_name_2", "tag_app_name_2_2"},
+		{"id", "tag_id"},
+	}
+	assert.Equal(t, want, tagLabelNames([]string{"id", "app.name", "app_name_2", "app-name", "id"}))
+	assert.Equal(t, want, tagLabelNames([]string{"app_name_2", "app-name", "id", "app.name"}))
+}
@@ -0,0 +1,330 @@
+// Package metrics turns the cumulative counters reported by Instances.Stats
+// into rates, and exports instance and host metrics for Prometheus.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+)
+
+const (
+	// defaultInterval is how often instances are sampled by default
+	defaultInterval = 10 * time.Second
+	// defaultHistory is how many samples are kept per instance by default
+	defaultHistory = 60
+	// defaultBuffer is the capacity of the samples channel by default
+	defaultBuffer = 16
+)
+
+// Sample is one observation of an instance, with rates computed against the
+// previous observation.
+type Sample struct {
+	InstanceID   string
+	InstanceName string
+	Time         time.Time
+	// Elapsed is the time since the previous sample, and 0 for the first
+	// sample of an instance, which carries no rates
+	Elapsed time.Duration
+	// CPUPercent is CPU time used as a percentage of the allocated vCPUs, so a
+	// fully busy VM reads 100 however many vCPUs it has
+	CPUPercent       float64
+	RxBytesPerSecond float64
+	TxBytesPerSecond float64
+	// MemoryUtilization is resident memory over allocated memory, from 0 to 1
+	MemoryUtilization float64
+	// MemoryTrend is the change in MemoryUtilization per minute, fitted over
+	// the retained history (0 until there are two samples)
+	MemoryTrend float64
+	// Reset is set when a counter went backwards since the previous sample, as
+	// happens when an instance is restarted or restored from a snapshot. Rates
+	// are then computed from zero, the counters' value at the reset.
+	Reset bool
+	// Stats is the raw response the sample was computed from
+	Stats hypeman.InstanceStats
+}
+
+// SamplerOptions configures a Sampler
+type SamplerOptions struct {
+	// Optional: instances to sample; more can be added with Add
+	InstanceIDs []string
+	// Optional: how often to sample every instance (defaults to 10s)
+	Interval time.Duration
+	// Optional: number of samples kept per instance for History (defaults to 60)
+	History int
+	// Optional: capacity of the Samples channel (defaults to 16). Sampling
+	// waits while the channel is full, so it must be drained.
+	Buffer int
+	// Optional: called when fetching stats for an instance fails; sampling continues
+	OnError func(err error)
+}
+
+// Sampler polls Instances.Stats for a set of instances and publishes rates.
+// Its methods are safe to call from any goroutine while Run is in progress.
+type Sampler struct {
+	instances hypeman.InstancesAPI
+	opts      SamplerOptions
+	samples   chan Sample
+	now       func() time.Time
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is the sampling state of one instance
+type series struct {
+	last    *Sample
+	history ring
+}
+
+// NewSampler returns a Sampler for the instances service. Call Run to start it.
+//
+// Example:
+//
+//	s := metrics.NewSampler(&client.Instances, metrics.SamplerOptions{
+//	    InstanceIDs: []string{id},
+//	    Interval:    5 * time.Second,
+//	})
+//	go s.Run(ctx)
+//	for sample := range s.Samples() {
+//	    fmt.Printf("%s cpu=%.1f%% rx=%.0fB/s\n", sample.InstanceName, sample.CPUPercent, sample.RxBytesPerSecond)
+//	}
+func NewSampler(instances hypeman.InstancesAPI, opts SamplerOptions) *Sampler {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+	if opts.History <= 0 {
+		opts.History = defaultHistory
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultBuffer
+	}
+	s := &Sampler{
+		instances: instances,
+		opts:      opts,
+		samples:   make(chan Sample, opts.Buffer),
+		now:       time.Now,
+		series:    map[string]*series{},
+	}
+	for _, id := range opts.InstanceIDs {
+		s.Add(id)
+	}
+	return s
+}
+
+// Run samples every instance each Interval until ctx is cancelled, then closes
+// the Samples channel and returns nil. Run must only be called once.
+func (s *Sampler) Run(ctx context.Context) error {
+	defer close(s.samples)
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Samples returns the channel samples are published on. It is closed when Run returns.
+func (s *Sampler) Samples() <-chan Sample {
+	return s.samples
+}
+
+// Add starts sampling an instance from the next round. Adding an instance
+// that is already sampled has no effect.
+func (s *Sampler) Add(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.series[id]; !ok {
+		s.series[id] = &series{history: newRing(s.opts.History)}
+	}
+}
+
+// Remove stops sampling an instance and drops its history.
+func (s *Sampler) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.series, id)
+}
+
+// History returns the retained samples of an instance, oldest first.
+func (s *Sampler) History(id string) []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ser, ok := s.series[id]
+	if !ok {
+		return nil
+	}
+	return ser.history.all()
+}
+
+// poll fetches stats for every instance concurrently and publishes the
+// resulting samples in instance ID order.
+func (s *Sampler) poll(ctx context.Context) {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.series))
+	for id := range s.series {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+	slices.Sort(ids)
+
+	stats := make([]*hypeman.InstanceStats, len(ids))
+	errs := make([]error, len(ids))
+	times := make([]time.Time, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stats[i], errs[i] = s.instances.Stats(ctx, id)
+			times[i] = s.now()
+		}()
+	}
+	wg.Wait()
+
+	for i, id := range ids {
+		if errs[i] != nil {
+			if ctx.Err() == nil && s.opts.OnError != nil {
+				s.opts.OnError(fmt.Errorf("get stats of %s: %w", id, errs[i]))
+			}
+			continue
+		}
+		sample, ok := s.record(id, *stats[i], times[i])
+		if !ok {
+			continue
+		}
+		select {
+		case s.samples <- sample:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// record computes a sample against the instance's previous one and adds it
+// to its history. It reports false if the instance was removed meanwhile.
+func (s *Sampler) record(id string, stats hypeman.InstanceStats, now time.Time) (Sample, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ser, ok := s.series[id]
+	if !ok {
+		return Sample{}, false
+	}
+	sample := newSample(ser.last, stats, now)
+	ser.history.push(sample)
+	sample.MemoryTrend = memoryTrend(ser.history.all())
+	ser.history.setLast(sample)
+	ser.last = &sample
+	return sample, true
+}
+
+// newSample computes the rates between prev, which may be nil, and stats.
+func newSample(prev *Sample, stats hypeman.InstanceStats, now time.Time) Sample {
+	sample := Sample{
+		InstanceID:        stats.InstanceID,
+		InstanceName:      stats.InstanceName,
+		Time:              now,
+		MemoryUtilization: memoryUtilization(stats),
+		Stats:             stats,
+	}
+	if prev == nil || !now.After(prev.Time) {
+		return sample
+	}
+	last := prev.Stats
+	sample.Elapsed = now.Sub(prev.Time)
+	sample.Reset = stats.CPUSeconds < last.CPUSeconds ||
+		stats.NetworkRxBytes < last.NetworkRxBytes ||
+		stats.NetworkTxBytes < last.NetworkTxBytes
+
+	cpu := stats.CPUSeconds
+	rx, tx := float64(stats.NetworkRxBytes), float64(stats.NetworkTxBytes)
+	if !sample.Reset {
+		cpu -= last.CPUSeconds
+		rx -= float64(last.NetworkRxBytes)
+		tx -= float64(last.NetworkTxBytes)
+	}
+	seconds := sample.Elapsed.Seconds()
+	vcpus := float64(max(stats.AllocatedVcpus, 1))
+	sample.CPUPercent = cpu / seconds / vcpus * 100
+	sample.RxBytesPerSecond = rx / seconds
+	sample.TxBytesPerSecond = tx / seconds
+	return sample
+}
+
+// memoryUtilization prefers the server's ratio and falls back to computing it.
+func memoryUtilization(stats hypeman.InstanceStats) float64 {
+	if stats.JSON.MemoryUtilizationRatio.Valid() {
+		return stats.MemoryUtilizationRatio
+	}
+	if stats.AllocatedMemoryBytes > 0 {
+		return float64(stats.MemoryRssBytes) / float64(stats.AllocatedMemoryBytes)
+	}
+	return 0
+}
+
+// memoryTrend is the least-squares slope of memory utilization per minute.
+func memoryTrend(samples []Sample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	t0 := samples[0].Time
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range samples {
+		x := sample.Time.Sub(t0).Minutes()
+		y := sample.MemoryUtilization
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	n := float64(len(samples))
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denom
+}
+
+// ring is a fixed-capacity buffer that overwrites its oldest sample.
+type ring struct {
+	buf   []Sample
+	start int
+	n     int
+}
+
+func newRing(size int) ring {
+	return ring{buf: make([]Sample, size)}
+}
+
+func (r *ring) push(sample Sample) {
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = sample
+		r.n++
+		return
+	}
+	r.buf[r.start] = sample
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// setLast replaces the most recently pushed sample.
+func (r *ring) setLast(sample Sample) {
+	r.buf[(r.start+r.n-1)%len(r.buf)] = sample
+}
+
+// all returns the samples oldest first.
+func (r *ring) all() []Sample {
+	out := make([]Sample, r.n)
+	for i := range out {
+		out[i] = r.buf[(r.start+i)%len(r.buf)]
+	}
+	return out
+}
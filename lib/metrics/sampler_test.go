@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib/hypemanmock"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSampler returns a sampler reading the stats of each instance from
+// the JSON in stats, which the test can replace between polls
+func newTestSampler(stats map[string]string, opts SamplerOptions) (*Sampler, *time.Time) {
+	m := hypemanmock.New()
+	m.Instances.StatsFunc = func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.InstanceStats, error) {
+		body, ok := stats[id]
+		if !ok {
+			return nil, fmt.Errorf("instance %s: %w", id, hypeman.ErrNotFound)
+		}
+		return hypemanmock.FromJSON[*hypeman.InstanceStats](body), nil
+	}
+	s := NewSampler(m.Instances, opts)
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+// TestSampler_Rates tests rates, counter resets and memory trends
+func TestSampler_Rates(t *testing.T) {
+	stats := map[string]string{
+		"a": `{"instance_id":"a","instance_name":"web","allocated_vcpus":2,"cpu_seconds":100,"network_rx_bytes":1000,"network_tx_bytes":500,"memory_rss_bytes":100,"allocated_memory_bytes":1000}`,
+	}
+	s, now := newTestSampler(stats, SamplerOptions{InstanceIDs: []string{"a"}})
+
+	s.poll(t.Context())
+	first := <-s.Samples()
+	assert.Equal(t, "web", first.InstanceName)
+	assert.Zero(t, first.Elapsed)
+	assert.Zero(t, first.CPUPercent)
+	assert.InDelta(t, 0.1, first.MemoryUtilization, 1e-9)
+
+	*now = now.Add(10 * time.Second)
+	stats["a"] = `{"instance_id":"a","instance_name":"web","allocated_vcpus":2,"cpu_seconds":105,"network_rx_bytes":11000,"network_tx_bytes":2500,"memory_rss_bytes":200,"allocated_memory_bytes":1000,"memory_utilization_ratio":0.2}`
+	s.poll(t.Context())
+	second := <-s.Samples()
+	assert.Equal(t, 10*time.Second, second.Elapsed)
+	assert.InDelta(t, 25, second.CPUPercent, 1e-9)
+	assert.InDelta(t, 1000, second.RxBytesPerSecond, 1e-9)
+	assert.InDelta(t, 200, second.TxBytesPerSecond, 1e-9)
+	assert.False(t, second.Reset)
+	assert.InDelta(t, 0.6, second.MemoryTrend, 1e-9, "0.1 per 10s is 0.6 per minute")
+
+	// A restore resets the counters; rates are taken from zero
+	*now = now.Add(10 * time.Second)
+	stats["a"] = `{"instance_id":"a","instance_name":"web","allocated_vcpus":2,"cpu_seconds":2,"network_rx_bytes":300,"network_tx_bytes":0,"memory_rss_bytes":200,"allocated_memory_bytes":1000}`
+	s.poll(t.Context())
+	third := <-s.Samples()
+	assert.True(t, third.Reset)
+	assert.InDelta(t, 10, third.CPUPercent, 1e-9)
+	assert.InDelta(t, 30, third.RxBytesPerSecond, 1e-9)
+	assert.Zero(t, third.TxBytesPerSecond)
+
+	history := s.History("a")
+	require.Len(t, history, 3)
+	assert.Equal(t, second.MemoryTrend, history[1].MemoryTrend)
+	assert.Nil(t, s.History("b"))
+}
+
+// TestSampler_History tests that history is bounded and errors are reported
+func TestSampler_History(t *testing.T) {
+	stats := map[string]string{
+		"a": `{"instance_id":"a","cpu_seconds":1}`,
+	}
+	var errs []error
+	s, now := newTestSampler(stats, SamplerOptions{
+		InstanceIDs: []string{"a", "missing"},
+		History:     3,
+		OnError:     func(err error) { errs = append(errs, err) },
+	})
+
+	for range 5 {
+		s.poll(t.Context())
+		<-s.Samples()
+		*now = now.Add(time.Second)
+	}
+	history := s.History("a")
+	require.Len(t, history, 3)
+	assert.Equal(t, now.Add(-3*time.Second), history[0].Time)
+	assert.Equal(t, now.Add(-time.Second), history[2].Time)
+
+	require.Len(t, errs, 5)
+	assert.Contains(t, errs[0].Error(), "get stats of missing")
+
+	s.Remove("a")
+	assert.Nil(t, s.History("a"))
+}
+
+// TestSampler_Run tests publishing until the context is cancelled
+func TestSampler_Run(t *testing.T) {
+	stats := map[string]string{"a": `{"instance_id":"a"}`}
+	s, _ := newTestSampler(stats, SamplerOptions{Interval: time.Millisecond})
+	s.now = time.Now
+	s.Add("a")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	for range 3 {
+		sample := <-s.Samples()
+		assert.Equal(t, "a", sample.InstanceID)
+	}
+	cancel()
+	for range s.Samples() {
+	}
+	require.NoError(t, <-done)
+}
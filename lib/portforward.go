@@ -0,0 +1,170 @@
+// Package lib provides manually-maintained functionality that extends the auto-generated SDK.
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// PortForwardOptions configures forwarding of local connections to a guest port
+type PortForwardOptions struct {
+	InstanceID string          // Instance ID to forward to
+	GuestPort  int             // TCP port inside the guest
+	OnError    func(err error) // Optional: called when a single forwarded connection fails
+	Dialer     WsDialer        // Optional: custom WebSocket dialer (for testing)
+}
+
+// portForwardRequest is the JSON request sent over WebSocket to open a tunnel
+type portForwardRequest struct {
+	Port int `json:"port"`
+}
+
+// portForwardMessage is a control frame exchanged on a tunnel
+type portForwardMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message,omitempty"`
+}
+
+// PortForward listens on localAddr and tunnels every accepted TCP connection to
+// guestPort inside the instance, using one WebSocket stream per connection. The
+// guest port is never exposed publicly, unlike an Ingress. It blocks until ctx
+// is cancelled.
+//
+// Example:
+//
+//	cfg, _ := lib.ExtractCpConfig(client.Options)
+//	err := lib.PortForward(ctx, cfg, "inst_123", "127.0.0.1:5432", 5432)
+func PortForward(ctx context.Context, cfg CpConfig, instanceID, localAddr string, guestPort int) error {
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", localAddr, err)
+	}
+	return ServePortForward(ctx, cfg, ln, PortForwardOptions{
+		InstanceID: instanceID,
+		GuestPort:  guestPort,
+	})
+}
+
+// ServePortForward tunnels connections accepted on ln to the guest port. Use it
+// instead of PortForward when the caller needs to own the listener, e.g. to bind
+// port 0 and read back the chosen address. ln is closed when ServePortForward
+// returns. It returns nil once ctx is cancelled.
+func ServePortForward(ctx context.Context, cfg CpConfig, ln net.Listener, opts PortForwardOptions) error {
+	if opts.GuestPort <= 0 || opts.GuestPort > 65535 {
+		ln.Close()
+		return fmt.Errorf("invalid guest port: %d", opts.GuestPort)
+	}
+	// Validate the instance ID and base URL up front rather than per connection
+	if _, err := buildInstanceWsURL(cfg.BaseURL, opts.InstanceID, "port-forward"); err != nil {
+		ln.Close()
+		return fmt.Errorf("build ws url: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			ln.Close()
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := forwardConn(ctx, cfg, conn, opts); err != nil && opts.OnError != nil {
+				opts.OnError(err)
+			}
+		}()
+	}
+}
+
+// forwardConn tunnels a single local connection until either side closes it.
+func forwardConn(ctx context.Context, cfg CpConfig, local net.Conn, opts PortForwardOptions) error {
+	defer local.Close()
+
+	ws, err := dialInstance(ctx, cfg, opts.Dialer, opts.InstanceID, "port-forward")
+	if err != nil {
+		return err
+	}
+	conn := &syncWsConn{conn: ws}
+	defer conn.Close()
+
+	if err := conn.writeJSON(portForwardRequest{Port: opts.GuestPort}); err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+
+	// Tear down both sides if the caller gives up
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+		local.Close()
+	})
+	defer stop()
+
+	// local -> guest; the guest is told about EOF so it can half-close
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := local.Read(buf)
+			if n > 0 {
+				if sendErr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); sendErr != nil {
+					return
+				}
+			}
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					conn.writeJSON(portForwardMessage{Type: "eof"})
+				}
+				return
+			}
+		}
+	}()
+
+	// guest -> local; returning closes the local connection
+	for {
+		msgType, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil || websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil
+			}
+			return fmt.Errorf("read message: %w", err)
+		}
+
+		switch msgType {
+		case websocket.BinaryMessage:
+			if _, err := local.Write(message); err != nil {
+				return fmt.Errorf("write local: %w", err)
+			}
+		case websocket.TextMessage:
+			var msg portForwardMessage
+			if err := json.Unmarshal(message, &msg); err != nil {
+				return fmt.Errorf("parse message: %w", err)
+			}
+			switch msg.Type {
+			case "error":
+				return fmt.Errorf("port forward to %d failed: %s", opts.GuestPort, msg.Message)
+			case "eof":
+				// Half-close so the local peer sees EOF but can keep sending
+				if cw, ok := local.(interface{ CloseWrite() error }); ok {
+					cw.CloseWrite()
+					continue
+				}
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoWsDialer dials chanWsConns backed by a fake guest that echoes data back
+type echoWsDialer struct {
+	mu    sync.Mutex
+	urls  []string
+	ports []int
+}
+
+func (d *echoWsDialer) DialContext(ctx context.Context, url string, headers http.Header) (WsConn, *http.Response, error) {
+	conn := newChanWsConn()
+	d.mu.Lock()
+	d.urls = append(d.urls, url)
+	d.mu.Unlock()
+
+	go func() {
+		for {
+			var msg wsMessage
+			select {
+			case msg = <-conn.written:
+			case <-conn.closed:
+				return
+			}
+			if msg.Type == websocket.BinaryMessage {
+				conn.incoming <- msg
+				continue
+			}
+			var ctrl map[string]any
+			json.Unmarshal(msg.Data, &ctrl)
+			if port, ok := ctrl["port"].(float64); ok {
+				d.mu.Lock()
+				d.ports = append(d.ports, int(port))
+				d.mu.Unlock()
+				continue
+			}
+			if ctrl["type"] == "eof" {
+				eof, _ := json.Marshal(portForwardMessage{Type: "eof"})
+				conn.incoming <- wsMessage{Type: websocket.TextMessage, Data: eof}
+				// Give the client time to drain before the guest hangs up
+				time.Sleep(50 * time.Millisecond)
+				conn.Close()
+				return
+			}
+		}
+	}()
+	return conn, nil, nil
+}
+
+// TestServePortForward tests that each local connection is tunneled to the guest port
+func TestServePortForward(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	dialer := &echoWsDialer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- ServePortForward(ctx, CpConfig{
+			BaseURL: "https://api.example.com",
+			APIKey:  "test-key",
+		}, ln, PortForwardOptions{
+			InstanceID: "inst_123",
+			GuestPort:  5432,
+			OnError:    func(err error) { t.Errorf("unexpected forward error: %v", err) },
+			Dialer:     dialer,
+		})
+	}()
+
+	for _, payload := range []string{"first connection", "second connection"} {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte(payload))
+		require.NoError(t, err)
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		got, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(got))
+		conn.Close()
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServePortForward did not return after cancel")
+	}
+
+	dialer.mu.Lock()
+	defer dialer.mu.Unlock()
+	assert.Equal(t, []string{
+		"wss://api.example.com/instances/inst_123/port-forward",
+		"wss://api.example.com/instances/inst_123/port-forward",
+	}, dialer.urls)
+	assert.Equal(t, []int{5432, 5432}, dialer.ports)
+}
+
+// TestServePortForward_InvalidPort tests that out-of-range ports are rejected
+func TestServePortForward_InvalidPort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	err = ServePortForward(context.Background(), CpConfig{BaseURL: "http://localhost:8080"}, ln, PortForwardOptions{
+		InstanceID: "inst_123",
+		GuestPort:  70000,
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid guest port")
+}
@@ -0,0 +1,156 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kernel/hypeman-go/lib"
+)
+
+// History is the restart history of one instance
+type History struct {
+	Restarts    int            `json:"restarts"`     // Restarts since the instance last ran for ResetAfter
+	LastRestart time.Time      `json:"last_restart"` // Zero if never restarted
+	LastExit    lib.ExitStatus `json:"last_exit"`    // Most recent failed exit
+}
+
+// Store keeps the restart history of supervised instances outside the
+// Supervisor, so that it outlives the process. Instance tags cannot be
+// changed after an instance is created, so the history cannot be kept with
+// the instance itself.
+//
+// A Supervisor calls Load when it first sees an instance, and Save and Delete
+// from a single goroutine of its own, so a slow Store delays writes but not
+// restarts.
+type Store interface {
+	// Load returns the history of an instance, and false if there is none.
+	Load(instanceID string) (History, bool, error)
+	// Save replaces the history of an instance.
+	Save(instanceID string, h History) error
+	// Delete removes the history of an instance once it is deleted.
+	Delete(instanceID string) error
+}
+
+// FileStore is a Store that keeps every instance's history in one JSON file,
+// which is read on every call and rewritten atomically on every change.
+// Supervisors in several processes can share a file, and so carry on from one
+// another, but a change made by one at the same moment as another's can be
+// lost.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore returns a FileStore backed by the file at path, which is
+// created, along with its directory, on the first Save if it does not exist.
+//
+// Example:
+//
+//	sup := supervisor.New(&client.Instances, supervisor.Options{
+//	    Store: supervisor.NewFileStore("/var/lib/worker/restarts.json"),
+//	})
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// DefaultStorePath is the file of the FileStore that a Supervisor uses when
+// Options.Store is not set: supervisor/restarts.json in a "hypeman" directory
+// of the user's cache directory, or of the temporary directory if there is no
+// cache directory.
+func DefaultStorePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "hypeman", "supervisor", "restarts.json")
+}
+
+// Load implements Store.
+func (f *FileStore) Load(instanceID string) (History, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	history, err := f.read()
+	if err != nil {
+		return History{}, false, err
+	}
+	h, ok := history[instanceID]
+	return h, ok, nil
+}
+
+// Save implements Store.
+func (f *FileStore) Save(instanceID string, h History) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	history, err := f.read()
+	if err != nil {
+		return err
+	}
+	history[instanceID] = h
+	return f.write(history)
+}
+
+// Delete implements Store.
+func (f *FileStore) Delete(instanceID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	history, err := f.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := history[instanceID]; !ok {
+		return nil
+	}
+	delete(history, instanceID)
+	return f.write(history)
+}
+
+// read returns the histories in the file. f.mu must be held.
+func (f *FileStore) read() (map[string]History, error) {
+	history := map[string]History{}
+	data, err := os.ReadFile(f.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("read %s: %w", f.path, err)
+	default:
+		if err := json.Unmarshal(data, &history); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", f.path, err)
+		}
+	}
+	return history, nil
+}
+
+// write replaces the file with history. f.mu must be held.
+func (f *FileStore) write(history map[string]History) error {
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("write %s: %w", f.path, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("write %s: %w", f.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", f.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", f.path, err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("write %s: %w", f.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+// Package supervisor restarts instances whose program fails, with exponential
+// backoff, a restart cap and a CrashLoopBackOff status, in the manner of a
+// container restart policy.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib"
+	"github.com/kernel/hypeman-go/lib/watch"
+)
+
+const (
+	defaultInterval       = 5 * time.Second
+	defaultInitialBackoff = 10 * time.Second
+	defaultMaxBackoff     = 5 * time.Minute
+	defaultJitter         = 0.2
+	defaultMaxRestarts    = 10
+	defaultResetAfter     = 10 * time.Minute
+)
+
+// Phase is the supervision state of an instance
+type Phase string
+
+const (
+	// PhaseHealthy means the instance has not failed, or was restarted and has
+	// not failed again
+	PhaseHealthy Phase = "Healthy"
+	// PhaseCrashLoopBackOff means the instance failed and is waiting to be restarted
+	PhaseCrashLoopBackOff Phase = "CrashLoopBackOff"
+	// PhaseFailed means the instance reached MaxRestarts and is left stopped
+	// until it is started by other means
+	PhaseFailed Phase = "Failed"
+)
+
+// Status is the supervision state of one instance
+type Status struct {
+	InstanceID   string
+	InstanceName string
+	Phase        Phase
+	Restarts     int            // Restarts since the instance last ran for ResetAfter
+	LastExit     lib.ExitStatus // Most recent failed exit
+	LastRestart  time.Time      // Zero if never restarted
+	NextRestart  time.Time      // Set in PhaseCrashLoopBackOff
+}
+
+// Options configures a Supervisor
+type Options struct {
+	// Optional: which instances to supervise (defaults to all)
+	Params hypeman.InstanceListParams
+	// Optional: how often to list instances (defaults to 5s)
+	Interval time.Duration
+	// Optional: delay before the first restart, doubled for each further one (defaults to 10s)
+	InitialBackoff time.Duration
+	// Optional: upper bound of the delay (defaults to 5m)
+	MaxBackoff time.Duration
+	// Optional: fraction by which each delay is randomly varied, from 0 to 1 (defaults to 0.2)
+	Jitter float64
+	// Optional: restarts after which an instance is left stopped (defaults to 10)
+	MaxRestarts int
+	// Optional: an instance that ran this long before failing starts over
+	// with no restarts and the initial backoff (defaults to 10m)
+	ResetAfter time.Duration
+	// Optional: decides whether a failed exit is restarted (defaults to every
+	// exit with a non-zero code, whatever the reason)
+	ShouldRestart func(exit lib.ExitStatus) bool
+	// Optional: keeps restart history across supervisors, so that a new one
+	// carries on with the restart counts and backoff of an earlier one
+	// (defaults to a FileStore at DefaultStorePath)
+	Store Store
+	// Optional: called from a single goroutine whenever an instance's status changes
+	OnStatus func(status Status)
+	// Optional: called when listing or starting an instance, or using Store, fails
+	OnError func(err error)
+}
+
+// Supervisor restarts supervised instances that stop with a failed exit.
+type Supervisor struct {
+	instances hypeman.InstancesAPI
+	opts      Options
+	informer  *watch.Informer
+	now       func() time.Time
+	jitter    func() float64 // in [-1, 1)
+
+	mu      sync.Mutex
+	tracked map[string]*tracked
+	ctx     context.Context
+	wg      sync.WaitGroup
+	updates chan Status
+	pending map[string]*History // histories to write to the store, nil to delete one
+	flush   chan struct{}       // signals that pending has changed
+}
+
+// tracked is the supervision state of one instance
+type tracked struct {
+	status    Status
+	startedAt time.Time          // when the supervisor last started it
+	handled   string             // exit already handled, so re-deliveries are ignored
+	cancel    context.CancelFunc // cancels a pending restart
+}
+
+// New returns a Supervisor for the instances service. Call Run to start it.
+//
+// Example:
+//
+//	sup := supervisor.New(&client.Instances, supervisor.Options{
+//	    Params:      hypeman.InstanceListParams{Tags: map[string]string{"restart": "on-failure"}},
+//	    MaxRestarts: 5,
+//	    ShouldRestart: func(exit lib.ExitStatus) bool {
+//	        return exit.Reason != lib.ExitReasonCommandNotFound
+//	    },
+//	    OnStatus: func(s supervisor.Status) {
+//	        log.Printf("%s: %s after %d restarts", s.InstanceName, s.Phase, s.Restarts)
+//	    },
+//	})
+//	go sup.Run(ctx)
+func New(instances hypeman.InstancesAPI, opts Options) *Supervisor {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaultInitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+	if opts.Jitter <= 0 {
+		opts.Jitter = defaultJitter
+	}
+	opts.Jitter = min(opts.Jitter, 1)
+	if opts.MaxRestarts <= 0 {
+		opts.MaxRestarts = defaultMaxRestarts
+	}
+	if opts.ResetAfter <= 0 {
+		opts.ResetAfter = defaultResetAfter
+	}
+	if opts.Store == nil {
+		opts.Store = NewFileStore(DefaultStorePath())
+	}
+	s := &Supervisor{
+		instances: instances,
+		opts:      opts,
+		now:       time.Now,
+		jitter:    func() float64 { return rand.Float64()*2 - 1 },
+		tracked:   map[string]*tracked{},
+		updates:   make(chan Status, 64),
+		pending:   map[string]*History{},
+		flush:     make(chan struct{}, 1),
+	}
+	// Resyncing on every poll re-delivers instances that failed again between
+	// polls without any watched field changing
+	s.informer = watch.NewInformer(instances, watch.Options{
+		Params:         opts.Params,
+		Interval:       opts.Interval,
+		ResyncInterval: opts.Interval,
+		OnEvent:        s.handle,
+		OnError:        opts.OnError,
+	})
+	return s
+}
+
+// Run supervises instances until ctx is cancelled, then cancels pending
+// restarts and returns nil. Run must only be called once.
+func (s *Supervisor) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for status := range s.updates {
+			if s.opts.OnStatus != nil {
+				s.opts.OnStatus(status)
+			}
+		}
+	}()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for range s.flush {
+			s.writeHistory()
+		}
+	}()
+
+	s.informer.Run(ctx)
+	s.wg.Wait()
+	close(s.updates)
+	close(s.flush)
+	<-done
+	<-written
+	return nil
+}
+
+// Status returns the supervision state of an instance.
+func (s *Supervisor) Status(id string) (Status, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tracked[id]
+	if !ok {
+		return Status{}, false
+	}
+	return t.status, true
+}
+
+// Statuses returns the supervision state of every supervised instance, ordered by ID.
+func (s *Supervisor) Statuses() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := slices.Sorted(maps.Keys(s.tracked))
+	out := make([]Status, len(ids))
+	for i, id := range ids {
+		out[i] = s.tracked[id].status
+	}
+	return out
+}
+
+// handle reacts to instance changes reported by the informer, which calls it
+// from a single goroutine.
+func (s *Supervisor) handle(event watch.Event) {
+	inst := event.Instance
+	s.mu.Lock()
+	_, known := s.tracked[inst.ID]
+	s.mu.Unlock()
+	var history History
+	if !known && event.Type != watch.Deleted {
+		// Read the store without holding s.mu
+		history = s.loadHistory(inst.ID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.tracked[inst.ID]
+	if event.Type == watch.Deleted {
+		if t != nil && t.cancel != nil {
+			t.cancel()
+		}
+		delete(s.tracked, inst.ID)
+		s.pending[inst.ID] = nil
+		s.signalFlush()
+		return
+	}
+	if t == nil {
+		t = &tracked{status: Status{
+			InstanceID:  inst.ID,
+			Phase:       PhaseHealthy,
+			Restarts:    history.Restarts,
+			LastRestart: history.LastRestart,
+			LastExit:    history.LastExit,
+		}, startedAt: history.LastRestart}
+		s.tracked[inst.ID] = t
+	}
+	t.status.InstanceName = inst.Name
+
+	if inst.State != hypeman.InstanceStateStopped {
+		if t.status.Phase == PhaseFailed && inst.State == hypeman.InstanceStateRunning {
+			// Started by someone else; supervise it afresh
+			t.status.Phase = PhaseHealthy
+			t.status.Restarts = 0
+			s.publish(t)
+			s.saveHistory(t.status)
+		}
+		t.handled = ""
+		return
+	}
+
+	exit := lib.DiagnoseExit(&inst)
+	key := exitKey(inst)
+	if exit.Err() == nil || t.handled == key || t.cancel != nil {
+		return
+	}
+	t.handled = key
+	t.status.LastExit = exit
+	if s.opts.ShouldRestart != nil && !s.opts.ShouldRestart(exit) {
+		return
+	}
+	if !t.startedAt.IsZero() && s.now().Sub(t.startedAt) >= s.opts.ResetAfter {
+		t.status.Restarts = 0
+	}
+	if t.status.Restarts >= s.opts.MaxRestarts {
+		t.status.Phase = PhaseFailed
+		t.status.NextRestart = time.Time{}
+		s.publish(t)
+		return
+	}
+
+	delay := s.backoff(t.status.Restarts)
+	t.status.Phase = PhaseCrashLoopBackOff
+	t.status.NextRestart = s.now().Add(delay)
+	s.publish(t)
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	t.cancel = cancel
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		s.restart(ctx, t, inst, delay)
+	}()
+}
+
+// restart starts inst after delay, unless ctx is cancelled first. Failed
+// starts count as restarts and are retried with the next backoff.
+func (s *Supervisor) restart(ctx context.Context, t *tracked, inst hypeman.Instance, delay time.Duration) {
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		_, err := s.instances.Start(ctx, inst.ID, hypeman.InstanceStartParams{})
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+
+		s.mu.Lock()
+		t.status.Restarts++
+		if err != nil {
+			s.report(fmt.Errorf("restart %s: %w", inst.ID, err))
+			if t.status.Restarts >= s.opts.MaxRestarts {
+				t.status.Phase = PhaseFailed
+				t.status.NextRestart = time.Time{}
+				t.cancel = nil
+				s.publish(t)
+				s.saveHistory(t.status)
+				s.mu.Unlock()
+				return
+			}
+			delay = s.backoff(t.status.Restarts)
+			t.status.NextRestart = s.now().Add(delay)
+			s.publish(t)
+			s.saveHistory(t.status)
+			s.mu.Unlock()
+			continue
+		}
+		t.status.LastRestart = s.now()
+		t.status.NextRestart = time.Time{}
+		t.status.Phase = PhaseHealthy
+		t.startedAt = t.status.LastRestart
+		t.cancel = nil
+		s.publish(t)
+		s.saveHistory(t.status)
+		s.mu.Unlock()
+		return
+	}
+}
+
+// loadHistory returns the restart history of an instance, or none if it has
+// none or the store fails. s.mu must not be held.
+func (s *Supervisor) loadHistory(id string) History {
+	s.mu.Lock()
+	h, ok := s.pending[id]
+	s.mu.Unlock()
+	if ok {
+		// Not written yet
+		if h == nil {
+			return History{}
+		}
+		return *h
+	}
+	history, _, err := s.opts.Store.Load(id)
+	if err != nil {
+		s.report(fmt.Errorf("load restart history of %s: %w", id, err))
+	}
+	return history
+}
+
+// saveHistory queues status's restart history to be written to the store.
+// s.mu must be held.
+func (s *Supervisor) saveHistory(status Status) {
+	s.pending[status.InstanceID] = &History{Restarts: status.Restarts, LastRestart: status.LastRestart, LastExit: status.LastExit}
+	s.signalFlush()
+}
+
+// signalFlush wakes the goroutine that writes pending histories. s.mu must be
+// held.
+func (s *Supervisor) signalFlush() {
+	select {
+	case s.flush <- struct{}{}:
+	default:
+		// Already signalled; the writer will see the latest pending histories
+	}
+}
+
+// writeHistory writes the pending histories to the store without holding
+// s.mu. Only the latest history of each instance is written.
+func (s *Supervisor) writeHistory() {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = map[string]*History{}
+	s.mu.Unlock()
+
+	for _, id := range slices.Sorted(maps.Keys(pending)) {
+		if h := pending[id]; h != nil {
+			if err := s.opts.Store.Save(id, *h); err != nil {
+				s.report(fmt.Errorf("save restart history of %s: %w", id, err))
+			}
+		} else if err := s.opts.Store.Delete(id); err != nil {
+			s.report(fmt.Errorf("delete restart history of %s: %w", id, err))
+		}
+	}
+}
+
+// backoff returns the delay before restart number n+1.
+func (s *Supervisor) backoff(n int) time.Duration {
+	delay := s.opts.InitialBackoff
+	for range n {
+		delay *= 2
+		if delay >= s.opts.MaxBackoff {
+			delay = s.opts.MaxBackoff
+			break
+		}
+	}
+	delay = time.Duration(float64(delay) * (1 + s.opts.Jitter*s.jitter()))
+	return max(delay, 0)
+}
+
+// publish queues a status update for OnStatus. s.mu must be held.
+func (s *Supervisor) publish(t *tracked) {
+	select {
+	case s.updates <- t.status:
+	default:
+		// OnStatus is falling behind; the latest status is always available from Status
+	}
+}
+
+func (s *Supervisor) report(err error) {
+	if s.opts.OnError != nil {
+		s.opts.OnError(err)
+	}
+}
+
+// exitKey identifies one run and exit of an instance, so the informer
+// re-delivering the same stopped instance does not restart it twice, while a
+// run that fails again after a restart, with a new start time, is noticed.
+func exitKey(inst hypeman.Instance) string {
+	return fmt.Sprintf("%s/%s/%d/%s", inst.StartedAt.Format(time.RFC3339Nano), inst.StoppedAt.Format(time.RFC3339Nano), inst.ExitCode, inst.ExitMessage)
+}
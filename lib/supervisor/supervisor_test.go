@@ -0,0 +1,286 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib"
+	"github.com/kernel/hypeman-go/lib/hypemanmock"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInstances is a mock of the instances service that lists the instances
+// in bodies, as JSON, and applies onStart when one is started
+type fakeInstances struct {
+	*hypemanmock.Instances
+	mu      sync.Mutex
+	bodies  map[string]string
+	onStart func(id string, n int) (string, error) // new body
+}
+
+func newFakeInstances(bodies map[string]string, onStart func(id string, n int) (string, error)) *fakeInstances {
+	f := &fakeInstances{Instances: hypemanmock.New().Instances, bodies: bodies, onStart: onStart}
+	f.ListFunc = func(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) (*[]hypeman.Instance, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var list []hypeman.Instance
+		for _, body := range f.bodies {
+			list = append(list, hypemanmock.FromJSON[hypeman.Instance](body))
+		}
+		return &list, nil
+	}
+	f.StartFunc = func(ctx context.Context, id string, params hypeman.InstanceStartParams, opts ...option.RequestOption) (*hypeman.Instance, error) {
+		body, err := f.onStart(id, f.startCount(id))
+		if err != nil {
+			return nil, err
+		}
+		f.set(id, body)
+		return hypemanmock.FromJSON[*hypeman.Instance](body), nil
+	}
+	return f
+}
+
+func (f *fakeInstances) set(id, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if body == "" {
+		delete(f.bodies, id)
+		return
+	}
+	f.bodies[id] = body
+}
+
+func (f *fakeInstances) startCount(id string) int {
+	n := 0
+	for _, call := range f.CallsTo("Start") {
+		if call.Args[0] == id {
+			n++
+		}
+	}
+	return n
+}
+
+var errCannotStart = errors.New("cannot start")
+
+func stoppedInstance(id string, run int, code int) string {
+	return fmt.Sprintf(`{"id":%q,"name":"worker","state":"Stopped","exit_code":%d,"exit_message":"exit status %d","tags":{"pool":"a"},"started_at":"2025-01-01T00:00:%02dZ"}`, id, code, code, run)
+}
+
+// statusLog records statuses from OnStatus
+type statusLog struct {
+	mu       sync.Mutex
+	statuses []Status
+}
+
+func (l *statusLog) add(s Status) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.statuses = append(l.statuses, s)
+}
+
+func (l *statusLog) saw(phase Phase) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.statuses {
+		if s.Phase == phase {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestSupervisor(t *testing.T, srv *fakeInstances, opts Options) (*Supervisor, *statusLog) {
+	log := &statusLog{}
+	opts.Interval = 5 * time.Millisecond
+	opts.OnStatus = log.add
+	if opts.Store == nil {
+		opts.Store = NewFileStore(filepath.Join(t.TempDir(), "restarts.json"))
+	}
+	s := New(srv, opts)
+	s.jitter = func() float64 { return 0 }
+	return s, log
+}
+
+func runSupervisor(t *testing.T, s *Supervisor) context.CancelFunc {
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+	return cancel
+}
+
+// TestSupervisor_CrashLoop tests restarting with backoff until the restart cap
+func TestSupervisor_CrashLoop(t *testing.T) {
+	srv := newFakeInstances(map[string]string{"a": stoppedInstance("a", 0, 1)}, func(id string, n int) (string, error) {
+		return stoppedInstance(id, n, 1), nil // fails again right away
+	})
+	var errs []error
+	store := NewFileStore(filepath.Join(t.TempDir(), "restarts.json"))
+	s, statuses := newTestSupervisor(t, srv, Options{
+		InitialBackoff: time.Millisecond,
+		MaxRestarts:    3,
+		Store:          store,
+		OnError:        func(err error) { errs = append(errs, err) },
+	})
+	runSupervisor(t, s)
+
+	require.Eventually(t, func() bool {
+		status, ok := s.Status("a")
+		return ok && status.Phase == PhaseFailed
+	}, 5*time.Second, 5*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, 3, srv.startCount("a"))
+
+	status, _ := s.Status("a")
+	assert.Equal(t, 3, status.Restarts)
+	assert.Equal(t, lib.ExitReasonNonZero, status.LastExit.Reason)
+	assert.False(t, status.LastRestart.IsZero())
+
+	var history History
+	require.Eventually(t, func() bool {
+		h, ok, err := NewFileStore(store.path).Load("a")
+		history = h
+		return err == nil && ok && h.Restarts == 3
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, lib.ExitReasonNonZero, history.LastExit.Reason)
+	assert.Empty(t, errs)
+
+	require.Eventually(t, func() bool { return statuses.saw(PhaseFailed) }, 5*time.Second, 5*time.Millisecond)
+	assert.True(t, statuses.saw(PhaseCrashLoopBackOff))
+}
+
+// TestSupervisor_Skips tests that clean exits, rejected exits and deleted
+// instances are not restarted
+func TestSupervisor_Skips(t *testing.T) {
+	srv := newFakeInstances(map[string]string{
+		"clean":   stoppedInstance("clean", 0, 0),
+		"missing": `{"id":"missing","state":"Stopped","exit_code":127,"exit_message":"command not found"}`,
+		"deleted": stoppedInstance("deleted", 0, 1),
+		"running": `{"id":"running","state":"Running"}`,
+	}, func(id string, n int) (string, error) { return "", errCannotStart })
+	s, _ := newTestSupervisor(t, srv, Options{
+		InitialBackoff: time.Hour,
+		ShouldRestart: func(exit lib.ExitStatus) bool {
+			return exit.Reason != lib.ExitReasonCommandNotFound
+		},
+	})
+	runSupervisor(t, s)
+
+	require.Eventually(t, func() bool {
+		status, ok := s.Status("deleted")
+		return ok && status.Phase == PhaseCrashLoopBackOff
+	}, 5*time.Second, 5*time.Millisecond)
+	srv.set("deleted", "")
+	require.Eventually(t, func() bool {
+		_, ok := s.Status("deleted")
+		return !ok
+	}, 5*time.Second, 5*time.Millisecond)
+
+	statuses := s.Statuses()
+	require.Len(t, statuses, 3)
+	for _, status := range statuses {
+		assert.Equal(t, PhaseHealthy, status.Phase, status.InstanceID)
+	}
+	assert.Equal(t, lib.ExitReasonCommandNotFound, statuses[1].LastExit.Reason)
+	srv.AssertNotCalled(t, "Start")
+}
+
+// TestSupervisor_StartFailure tests that failed starts are retried with backoff
+func TestSupervisor_StartFailure(t *testing.T) {
+	srv := newFakeInstances(map[string]string{"a": stoppedInstance("a", 0, 1)}, func(id string, n int) (string, error) {
+		if n < 3 {
+			return "", errCannotStart
+		}
+		return `{"id":"a","state":"Running"}`, nil
+	})
+	var mu sync.Mutex
+	var errs []error
+	s, _ := newTestSupervisor(t, srv, Options{
+		InitialBackoff: time.Millisecond,
+		OnError: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		},
+	})
+	runSupervisor(t, s)
+
+	require.Eventually(t, func() bool { return srv.startCount("a") == 3 }, 5*time.Second, 5*time.Millisecond)
+	require.Eventually(t, func() bool {
+		status, _ := s.Status("a")
+		return status.Phase == PhaseHealthy && status.Restarts == 3
+	}, 5*time.Second, 5*time.Millisecond)
+	mu.Lock()
+	assert.Len(t, errs, 2)
+	mu.Unlock()
+}
+
+// TestSupervisor_Store tests that a new supervisor carries on with the
+// restart history of an earlier one
+func TestSupervisor_Store(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "restarts.json"))
+	require.NoError(t, store.Save("a", History{Restarts: 3, LastRestart: time.Now()}))
+	require.NoError(t, store.Save("gone", History{Restarts: 1}))
+
+	srv := newFakeInstances(map[string]string{"a": stoppedInstance("a", 0, 1)}, func(id string, n int) (string, error) {
+		return stoppedInstance(id, n, 1), nil
+	})
+	s, _ := newTestSupervisor(t, srv, Options{InitialBackoff: time.Millisecond, MaxRestarts: 3, Store: store})
+	runSupervisor(t, s)
+
+	require.Eventually(t, func() bool {
+		status, ok := s.Status("a")
+		return ok && status.Phase == PhaseFailed
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Zero(t, srv.startCount("a"), "the restart cap was already reached")
+
+	srv.set("a", "")
+	require.Eventually(t, func() bool {
+		_, ok, err := store.Load("a")
+		return err == nil && !ok
+	}, 5*time.Second, 5*time.Millisecond)
+	_, ok, err := store.Load("gone")
+	require.NoError(t, err)
+	assert.True(t, ok, "history of instances the supervisor never saw is kept")
+}
+
+// TestBackoff tests doubling, the cap and jitter
+func TestBackoff(t *testing.T) {
+	s := New(nil, Options{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Jitter: 0.5})
+	s.jitter = func() float64 { return 0 }
+	assert.Equal(t, time.Second, s.backoff(0))
+	assert.Equal(t, 4*time.Second, s.backoff(2))
+	assert.Equal(t, 10*time.Second, s.backoff(10))
+
+	s.jitter = func() float64 { return -1 }
+	assert.Equal(t, 2*time.Second, s.backoff(2))
+	s.jitter = func() float64 { return 0.5 }
+	assert.Equal(t, 5*time.Second, s.backoff(2))
+}
+
+// TestDefaultStore tests that restart history is kept in a file by default
+func TestDefaultStore(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	s := New(nil, Options{})
+	require.IsType(t, &FileStore{}, s.opts.Store)
+
+	store := s.opts.Store.(*FileStore)
+	assert.Equal(t, DefaultStorePath(), store.path)
+	require.NoError(t, store.Save("a", History{Restarts: 1}))
+	history, ok, err := NewFileStore(DefaultStorePath()).Load("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 1, history.Restarts)
+}
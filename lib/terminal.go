@@ -0,0 +1,139 @@
+// Package lib provides manually-maintained functionality that extends the auto-generated SDK.
+package lib
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// TerminalSize is the window size of a terminal in character cells.
+type TerminalSize struct {
+	Rows uint16
+	Cols uint16
+}
+
+// Terminal abstracts the local terminal attached to an interactive exec session.
+// Use StdTerminal for the process's own terminal, or provide a fake in tests.
+type Terminal interface {
+	io.Reader
+	io.Writer
+	// MakeRaw puts the terminal into raw mode and returns a function that restores it.
+	MakeRaw() (restore func() error, err error)
+	// Size returns the current window size. A zero size means unknown.
+	Size() (TerminalSize, error)
+	// WatchSignals reports window-size changes and interrupts until stop is
+	// called. In raw mode Ctrl-C is read as input rather than raised as an
+	// interrupt, so interrupts only arrive when the terminal is not raw.
+	WatchSignals() (resize <-chan struct{}, interrupt <-chan struct{}, stop func())
+}
+
+// StdTerminal returns a Terminal backed by os.Stdin and os.Stdout.
+//
+// os.Stdin is read by a single goroutine for the rest of the process once the
+// terminal is first read, so that a session that ends leaves no Read on
+// os.Stdin behind: input typed between sessions is kept for the next one.
+// Other code in the process should not read os.Stdin directly after that.
+func StdTerminal() Terminal {
+	return &stdTerminal{in: os.Stdin, out: os.Stdout}
+}
+
+// stdTerminal implements Terminal using golang.org/x/term
+type stdTerminal struct {
+	in      *os.File
+	out     *os.File
+	pending inputChunk // the rest of a chunk Read did not have room for
+}
+
+// stdinChunks reads os.Stdin from one goroutine for the life of the process.
+// The channel is unbuffered, so at most one chunk is read ahead of a reader.
+var stdinChunks = sync.OnceValue(func() <-chan inputChunk {
+	return readChunks(os.Stdin, nil)
+})
+
+// input implements inputSource.
+func (t *stdTerminal) input() <-chan inputChunk { return stdinChunks() }
+
+func (t *stdTerminal) Read(p []byte) (int, error) {
+	if len(t.pending.data) == 0 && t.pending.err == nil {
+		chunk, ok := <-t.input()
+		if !ok {
+			return 0, io.EOF
+		}
+		t.pending = chunk
+	}
+	n := copy(p, t.pending.data)
+	t.pending.data = t.pending.data[n:]
+	if len(t.pending.data) > 0 {
+		return n, nil
+	}
+	err := t.pending.err
+	t.pending = inputChunk{}
+	return n, err
+}
+
+func (t *stdTerminal) Write(p []byte) (int, error) { return t.out.Write(p) }
+
+func (t *stdTerminal) MakeRaw() (func() error, error) {
+	fd := int(t.in.Fd())
+	if !term.IsTerminal(fd) {
+		// Nothing to do when stdin is a pipe or file
+		return func() error { return nil }, nil
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return term.Restore(fd, state) }, nil
+}
+
+func (t *stdTerminal) Size() (TerminalSize, error) {
+	fd := int(t.out.Fd())
+	if !term.IsTerminal(fd) {
+		return TerminalSize{}, nil
+	}
+	cols, rows, err := term.GetSize(fd)
+	if err != nil {
+		return TerminalSize{}, err
+	}
+	return TerminalSize{Rows: uint16(rows), Cols: uint16(cols)}, nil
+}
+
+func (t *stdTerminal) WatchSignals() (<-chan struct{}, <-chan struct{}, func()) {
+	resizeSig := make(chan os.Signal, 1)
+	interruptSig := make(chan os.Signal, 1)
+	notifyResize(resizeSig)
+	signal.Notify(interruptSig, os.Interrupt)
+
+	resize := make(chan struct{}, 1)
+	interrupt := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-resizeSig:
+				select {
+				case resize <- struct{}{}:
+				default:
+				}
+			case <-interruptSig:
+				select {
+				case interrupt <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	stop := func() {
+		signal.Stop(resizeSig)
+		signal.Stop(interruptSig)
+		close(done)
+	}
+	return resize, interrupt, stop
+}
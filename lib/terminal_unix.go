@@ -0,0 +1,14 @@
+//go:build unix
+
+package lib
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyResize relays SIGWINCH (terminal window size changes) to ch on Unix systems.
+func notifyResize(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGWINCH)
+}
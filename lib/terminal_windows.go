@@ -0,0 +1,8 @@
+//go:build windows
+
+package lib
+
+import "os"
+
+// notifyResize is a no-op on Windows, which has no SIGWINCH equivalent.
+func notifyResize(ch chan<- os.Signal) {}
@@ -0,0 +1,337 @@
+// Package usage aggregates the per-phase durations instances report in
+// PhaseDurationsMs into billing reports: usage is accrued per billing period
+// between collections, priced with a rate card and grouped by tags.
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+)
+
+// checkpointVersion is bumped when the checkpoint file format changes
+const checkpointVersion = 1
+
+// Period is the length of a billing period. Periods start at midnight UTC.
+type Period string
+
+const (
+	Hourly  Period = "hour"
+	Daily   Period = "day"
+	Monthly Period = "month"
+)
+
+// Start returns the start of the period containing t.
+func (p Period) Start(t time.Time) time.Time {
+	t = t.UTC()
+	switch p {
+	case Hourly:
+		return t.Truncate(time.Hour)
+	case Daily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// End returns the start of the period following the one starting at start.
+func (p Period) End(start time.Time) time.Time {
+	switch p {
+	case Hourly:
+		return start.Add(time.Hour)
+	case Daily:
+		return start.AddDate(0, 0, 1)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+// MeterOptions configures a Meter
+type MeterOptions struct {
+	// Optional: file the meter's state is loaded from and saved to after every
+	// collection, so usage survives restarts and instances deleted between
+	// collections stay in the period's report. Without it state is kept in memory.
+	CheckpointPath string
+	// Optional: which instances to meter (defaults to all). An instance that
+	// stops matching is treated as deleted.
+	Params hypeman.InstanceListParams
+	// Optional: billing period (defaults to Monthly)
+	Period Period
+	// Optional: rate card used to price reports (defaults to no charges)
+	Rates RateCard
+}
+
+// Meter accrues instance usage across calls to Collect. It is safe for
+// concurrent use, but only one process may use a checkpoint file at a time.
+type Meter struct {
+	instances hypeman.InstancesAPI
+	opts      MeterOptions
+	now       func() time.Time
+
+	mu sync.Mutex
+	cp *checkpoint
+}
+
+// checkpoint is the persisted state of a Meter
+type checkpoint struct {
+	Version     int                    `json:"version"`
+	PeriodStart time.Time              `json:"period_start"`
+	CollectedAt time.Time              `json:"collected_at"`
+	Observed    map[string]observation `json:"observed"` // last cumulative durations of live instances
+	Accrued     map[string]*accrual    `json:"accrued"`  // usage in the current period
+}
+
+type observation struct {
+	PhaseMs map[string]int64 `json:"phase_ms"`
+}
+
+type accrual struct {
+	Name        string            `json:"name"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Vcpus       int64             `json:"vcpus"`
+	MemoryBytes int64             `json:"memory_bytes"`
+	PhaseMs     map[string]int64  `json:"phase_ms"`
+	LastSeen    time.Time         `json:"last_seen"`
+	Deleted     bool              `json:"deleted,omitempty"`
+}
+
+// NewMeter returns a Meter for the instances service.
+//
+// Example:
+//
+//	meter := usage.NewMeter(&client.Instances, usage.MeterOptions{
+//	    CheckpointPath: "/var/lib/billing/usage.json",
+//	    Rates: usage.RateCard{Currency: "USD", Phases: map[string]usage.PhaseRate{
+//	        "running": {PerVCPUHour: 0.02, PerGBHour: 0.005},
+//	        "standby": {PerGBHour: 0.001},
+//	    }},
+//	})
+//	closed, err := meter.Collect(ctx)
+//	if err != nil {
+//	    return err
+//	}
+//	for _, report := range closed {
+//	    report.GroupBy("team").WriteCSV(os.Stdout)
+//	}
+func NewMeter(instances hypeman.InstancesAPI, opts MeterOptions) *Meter {
+	if opts.Period == "" {
+		opts.Period = Monthly
+	}
+	return &Meter{instances: instances, opts: opts, now: time.Now}
+}
+
+// Collect lists instances and accrues the time each spent in every phase
+// since the previous collection. Time is attributed to the period in which it
+// is collected, so collecting shortly after each period boundary, or often,
+// keeps periods accurate. The first collection only records a baseline.
+//
+// If this collection is the first in a new period, the report of the period
+// that ended is returned and accrual starts over.
+func (m *Meter) Collect(ctx context.Context) ([]*Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	list, err := m.instances.List(ctx, m.opts.Params)
+	if err != nil {
+		return nil, fmt.Errorf("list instances: %w", err)
+	}
+	now := m.now().UTC()
+
+	var closed []*Report
+	baseline := m.cp.CollectedAt.IsZero()
+	if start := m.opts.Period.Start(now); !baseline && !start.Equal(m.cp.PeriodStart) {
+		closed = append(closed, m.report())
+		m.cp.Accrued = map[string]*accrual{}
+	}
+	m.cp.PeriodStart = m.opts.Period.Start(now)
+
+	seen := map[string]bool{}
+	if list != nil {
+		for _, inst := range *list {
+			seen[inst.ID] = true
+			m.observe(inst, now, baseline)
+		}
+	}
+	for id := range m.cp.Observed {
+		if !seen[id] {
+			delete(m.cp.Observed, id)
+			if a := m.cp.Accrued[id]; a != nil {
+				a.Deleted = true
+			}
+		}
+	}
+	m.cp.CollectedAt = now
+
+	if err := m.save(); err != nil {
+		return closed, err
+	}
+	return closed, nil
+}
+
+// Report returns the usage accrued so far in the current period.
+func (m *Meter) Report() (*Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m.report(), nil
+}
+
+// observe accrues the usage of inst since it was last observed. m.mu must be held.
+func (m *Meter) observe(inst hypeman.Instance, now time.Time, baseline bool) {
+	prev, known := m.cp.Observed[inst.ID]
+	m.cp.Observed[inst.ID] = observation{PhaseMs: maps.Clone(inst.PhaseDurationsMs)}
+	// An instance that is new to the meter but older than the last collection,
+	// e.g. one that started matching Params, has no baseline to diff against
+	if baseline || !known && inst.CreatedAt.Before(m.cp.CollectedAt) {
+		return
+	}
+
+	a := m.cp.Accrued[inst.ID]
+	if a == nil {
+		a = &accrual{PhaseMs: map[string]int64{}}
+		m.cp.Accrued[inst.ID] = a
+	}
+	a.Name = inst.Name
+	a.Tags = maps.Clone(inst.Tags)
+	a.Vcpus = inst.Vcpus
+	a.MemoryBytes, _ = parseSize(inst.Size)
+	a.LastSeen = now
+	a.Deleted = false
+
+	for phase, ms := range inst.PhaseDurationsMs {
+		// All the time of an instance created since the last collection is
+		// new. A total that went down was reset.
+		delta := ms
+		if last, ok := prev.PhaseMs[phase]; known && ok && ms >= last {
+			delta = ms - last
+		}
+		if delta > 0 {
+			a.PhaseMs[phase] += delta
+		}
+	}
+}
+
+// report builds the report of the current period. m.mu must be held.
+func (m *Meter) report() *Report {
+	r := &Report{
+		PeriodStart: m.cp.PeriodStart,
+		PeriodEnd:   m.opts.Period.End(m.cp.PeriodStart),
+		Currency:    m.opts.Rates.Currency,
+	}
+	for id, a := range m.cp.Accrued {
+		r.Lines = append(r.Lines, m.opts.Rates.price(Line{
+			InstanceID:   id,
+			InstanceName: a.Name,
+			Tags:         maps.Clone(a.Tags),
+			Vcpus:        a.Vcpus,
+			MemoryBytes:  a.MemoryBytes,
+			PhaseMs:      maps.Clone(a.PhaseMs),
+			Deleted:      a.Deleted,
+		}))
+	}
+	r.sort()
+	return r
+}
+
+// load reads the checkpoint file the first time it is needed. m.mu must be held.
+func (m *Meter) load() error {
+	if m.cp != nil {
+		return nil
+	}
+	m.cp = &checkpoint{Version: checkpointVersion, Observed: map[string]observation{}, Accrued: map[string]*accrual{}}
+	if m.opts.CheckpointPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.opts.CheckpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		m.cp = nil
+		return fmt.Errorf("read usage checkpoint: %w", err)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		m.cp = nil
+		return fmt.Errorf("parse usage checkpoint: %w", err)
+	}
+	if cp.Version != checkpointVersion {
+		m.cp = nil
+		return fmt.Errorf("usage checkpoint has unsupported version %d", cp.Version)
+	}
+	if cp.Observed == nil {
+		cp.Observed = map[string]observation{}
+	}
+	if cp.Accrued == nil {
+		cp.Accrued = map[string]*accrual{}
+	}
+	m.cp = &cp
+	return nil
+}
+
+// save writes the checkpoint file atomically. m.mu must be held.
+func (m *Meter) save() error {
+	if m.opts.CheckpointPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode usage checkpoint: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.opts.CheckpointPath), filepath.Base(m.opts.CheckpointPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write usage checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write usage checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write usage checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), m.opts.CheckpointPath); err != nil {
+		return fmt.Errorf("write usage checkpoint: %w", err)
+	}
+	return nil
+}
+
+// sizeUnits are the multipliers of human-readable sizes, which are binary
+var sizeUnits = map[string]int64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// parseSize parses sizes such as "512MB", "2GB" or "1073741824".
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
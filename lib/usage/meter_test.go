@@ -0,0 +1,137 @@
+package usage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib/hypemanmock"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestMeter returns a meter listing the instances in the JSON array
+// *list, which the test can replace between collections
+func newTestMeter(list *string, opts MeterOptions, now *time.Time) *Meter {
+	m := hypemanmock.New()
+	m.Instances.ListFunc = func(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) (*[]hypeman.Instance, error) {
+		instances := hypemanmock.FromJSON[[]hypeman.Instance](*list)
+		return &instances, nil
+	}
+	meter := NewMeter(m.Instances, opts)
+	meter.now = func() time.Time { return *now }
+	return meter
+}
+
+// TestMeter_Collect tests accrual, resets, new and deleted instances across a
+// restart from the checkpoint file
+func TestMeter_Collect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	now := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	list := `[
+		{"id":"a","name":"web","created_at":"2025-01-01T00:00:00Z","vcpus":2,"size":"1GB","tags":{"team":"core"},"phase_durations_ms":{"running":1000000,"stopped":5000}},
+		{"id":"b","name":"batch","created_at":"2025-01-01T00:00:00Z","vcpus":1,"size":"512MB","phase_durations_ms":{"running":7000}}
+	]`
+	opts := MeterOptions{CheckpointPath: path}
+
+	// The first collection is a baseline
+	m := newTestMeter(&list, opts, &now)
+	closed, err := m.Collect(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, closed)
+	report, err := m.Report()
+	require.NoError(t, err)
+	assert.Empty(t, report.Lines)
+
+	now = now.Add(time.Hour)
+	list = `[
+		{"id":"a","name":"web","created_at":"2025-01-01T00:00:00Z","vcpus":2,"size":"1GB","tags":{"team":"core"},"phase_durations_ms":{"running":4600000,"stopped":5000}},
+		{"id":"b","name":"batch","created_at":"2025-01-01T00:00:00Z","vcpus":1,"size":"512MB","phase_durations_ms":{"running":10000}},
+		{"id":"c","name":"new","created_at":"2025-01-10T00:30:00Z","vcpus":4,"size":"2GB","phase_durations_ms":{"running":1800000}}
+	]`
+	_, err = m.Collect(t.Context())
+	require.NoError(t, err)
+
+	// A new meter picks up from the checkpoint; b is deleted and a was restored
+	// with reset counters
+	m = newTestMeter(&list, opts, &now)
+	now = now.Add(time.Hour)
+	list = `[
+		{"id":"a","name":"web","created_at":"2025-01-01T00:00:00Z","vcpus":2,"size":"1GB","tags":{"team":"core"},"phase_durations_ms":{"running":60000,"standby":120000}},
+		{"id":"c","name":"new","created_at":"2025-01-10T00:30:00Z","vcpus":4,"size":"2GB","phase_durations_ms":{"running":5400000}}
+	]`
+	_, err = m.Collect(t.Context())
+	require.NoError(t, err)
+
+	report, err = m.Report()
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), report.PeriodStart)
+	assert.Equal(t, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), report.PeriodEnd)
+	require.Len(t, report.Lines, 3)
+
+	a, b, c := report.Lines[0], report.Lines[1], report.Lines[2]
+	assert.Equal(t, map[string]int64{"running": 3600000 + 60000, "standby": 120000}, a.PhaseMs)
+	assert.Equal(t, int64(1<<30), a.MemoryBytes)
+	assert.Equal(t, map[string]string{"team": "core"}, a.Tags)
+	assert.Equal(t, map[string]int64{"running": 3000}, b.PhaseMs)
+	assert.True(t, b.Deleted)
+	assert.Equal(t, map[string]int64{"running": 5400000}, c.PhaseMs)
+	assert.False(t, c.Deleted)
+
+	// The next collection in February closes January
+	now = time.Date(2025, 2, 1, 0, 5, 0, 0, time.UTC)
+	closed, err = m.Collect(t.Context())
+	require.NoError(t, err)
+	require.Len(t, closed, 1)
+	assert.Equal(t, report, closed[0])
+
+	report, err = m.Report()
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), report.PeriodStart)
+	assert.Len(t, report.Lines, 2)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"period_start": "2025-02-01T00:00:00Z"`)
+}
+
+// TestMeter_UnknownOldInstance tests that an old instance entering the
+// selection is baselined rather than billed for its whole life
+func TestMeter_UnknownOldInstance(t *testing.T) {
+	now := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	list := `[]`
+	m := newTestMeter(&list, MeterOptions{Period: Daily}, &now)
+	_, err := m.Collect(t.Context())
+	require.NoError(t, err)
+
+	now = now.Add(time.Hour)
+	list = `[{"id":"a","created_at":"2024-01-01T00:00:00Z","phase_durations_ms":{"running":999999999}}]`
+	_, err = m.Collect(t.Context())
+	require.NoError(t, err)
+	report, err := m.Report()
+	require.NoError(t, err)
+	assert.Empty(t, report.Lines)
+	assert.Equal(t, time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC), report.PeriodStart)
+}
+
+// TestParseSize tests human-readable sizes
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"1GB":        1 << 30,
+		"512MB":      512 << 20,
+		"1.5 GiB":    3 << 29,
+		"2048":       2048,
+		"4g":         4 << 30,
+		"1073741824": 1 << 30,
+	} {
+		got, err := parseSize(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := parseSize("lots")
+	assert.Error(t, err)
+}
@@ -0,0 +1,244 @@
+package usage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateCard prices the time instances spend in each phase
+type RateCard struct {
+	// Optional: currency of the prices, copied to reports
+	Currency string
+	// Prices by phase, keyed like PhaseDurationsMs (e.g. "running", "standby").
+	// Time in phases without a rate is reported but not charged.
+	Phases map[string]PhaseRate
+}
+
+// PhaseRate is the hourly price of one phase
+type PhaseRate struct {
+	PerHour     float64 // per instance
+	PerVCPUHour float64 // per vCPU
+	PerGBHour   float64 // per GiB of base memory
+}
+
+// Report is the priced usage of one billing period
+type Report struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Currency    string    `json:"currency,omitempty"`
+	Lines       []Line    `json:"lines"`
+}
+
+// Line is the usage of one instance in a period
+type Line struct {
+	InstanceID   string            `json:"instance_id"`
+	InstanceName string            `json:"instance_name"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Vcpus        int64             `json:"vcpus"`
+	MemoryBytes  int64             `json:"memory_bytes"`
+	PhaseMs      map[string]int64  `json:"phase_ms"`
+	Cost         float64           `json:"cost"`
+	// Deleted is set when the instance was gone by the last collection of the
+	// period; its usage up to the collection before is included
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Group is the usage of the instances sharing values for a set of tag keys
+type Group struct {
+	Tags      map[string]string `json:"tags"` // Empty for instances without the tag
+	Instances int               `json:"instances"`
+	PhaseMs   map[string]int64  `json:"phase_ms"`
+	Cost      float64           `json:"cost"`
+}
+
+// Groups is a report grouped by tag keys
+type Groups struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Currency    string    `json:"currency,omitempty"`
+	Keys        []string  `json:"keys"`
+	Groups      []Group   `json:"groups"`
+}
+
+// price sets the cost of a line.
+func (rc RateCard) price(line Line) Line {
+	gib := float64(line.MemoryBytes) / (1 << 30)
+	line.Cost = 0
+	for phase, ms := range line.PhaseMs {
+		rate, ok := rc.Phases[phase]
+		if !ok {
+			continue
+		}
+		hours := float64(ms) / float64(time.Hour/time.Millisecond)
+		line.Cost += hours * (rate.PerHour + rate.PerVCPUHour*float64(line.Vcpus) + rate.PerGBHour*gib)
+	}
+	return line
+}
+
+// Reprice returns a copy of the report priced with rc.
+func (r *Report) Reprice(rc RateCard) *Report {
+	out := *r
+	out.Currency = rc.Currency
+	out.Lines = make([]Line, len(r.Lines))
+	for i, line := range r.Lines {
+		out.Lines[i] = rc.price(line)
+	}
+	return &out
+}
+
+// Total returns the cost of every line.
+func (r *Report) Total() float64 {
+	var total float64
+	for _, line := range r.Lines {
+		total += line.Cost
+	}
+	return total
+}
+
+// GroupBy sums the report's lines by the values of the given tag keys, e.g.
+// GroupBy("team", "project"). Without keys every line is in a single group.
+// Groups are ordered by their tag values.
+func (r *Report) GroupBy(keys ...string) *Groups {
+	out := &Groups{PeriodStart: r.PeriodStart, PeriodEnd: r.PeriodEnd, Currency: r.Currency, Keys: keys}
+	index := map[string]int{}
+	for _, line := range r.Lines {
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			values[i] = line.Tags[key]
+		}
+		id := strings.Join(values, "\x00")
+		i, ok := index[id]
+		if !ok {
+			tags := make(map[string]string, len(keys))
+			for j, key := range keys {
+				tags[key] = values[j]
+			}
+			i = len(out.Groups)
+			index[id] = i
+			out.Groups = append(out.Groups, Group{Tags: tags, PhaseMs: map[string]int64{}})
+		}
+		g := &out.Groups[i]
+		g.Instances++
+		g.Cost += line.Cost
+		for phase, ms := range line.PhaseMs {
+			g.PhaseMs[phase] += ms
+		}
+	}
+	slices.SortFunc(out.Groups, func(a, b Group) int {
+		for _, key := range keys {
+			if c := strings.Compare(a.Tags[key], b.Tags[key]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return out
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	return writeJSON(w, r)
+}
+
+// WriteCSV writes one row per instance: instance_id, instance_name, deleted,
+// vcpus, memory_bytes, one <phase>_hours column per phase and cost.
+func (r *Report) WriteCSV(w io.Writer) error {
+	var phaseMs []map[string]int64
+	for _, line := range r.Lines {
+		phaseMs = append(phaseMs, line.PhaseMs)
+	}
+	phases := phaseNames(phaseMs)
+
+	cw := csv.NewWriter(w)
+	cw.Write(append(append([]string{"instance_id", "instance_name", "deleted", "vcpus", "memory_bytes"}, phaseColumns(phases)...), "cost"))
+	for _, line := range r.Lines {
+		row := []string{
+			line.InstanceID,
+			line.InstanceName,
+			strconv.FormatBool(line.Deleted),
+			strconv.FormatInt(line.Vcpus, 10),
+			strconv.FormatInt(line.MemoryBytes, 10),
+		}
+		row = append(row, phaseHours(phases, line.PhaseMs)...)
+		cw.Write(append(row, formatCost(line.Cost)))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the groups as indented JSON.
+func (g *Groups) WriteJSON(w io.Writer) error {
+	return writeJSON(w, g)
+}
+
+// WriteCSV writes one row per group: one column per tag key, instances, one
+// <phase>_hours column per phase and cost.
+func (g *Groups) WriteCSV(w io.Writer) error {
+	var phaseMs []map[string]int64
+	for _, group := range g.Groups {
+		phaseMs = append(phaseMs, group.PhaseMs)
+	}
+	phases := phaseNames(phaseMs)
+
+	cw := csv.NewWriter(w)
+	cw.Write(append(append(append(slices.Clone(g.Keys), "instances"), phaseColumns(phases)...), "cost"))
+	for _, group := range g.Groups {
+		var row []string
+		for _, key := range g.Keys {
+			row = append(row, group.Tags[key])
+		}
+		row = append(row, strconv.Itoa(group.Instances))
+		row = append(row, phaseHours(phases, group.PhaseMs)...)
+		cw.Write(append(row, formatCost(group.Cost)))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// phaseNames returns every phase with usage, sorted.
+func phaseNames(all []map[string]int64) []string {
+	set := map[string]struct{}{}
+	for _, phases := range all {
+		for phase := range phases {
+			set[phase] = struct{}{}
+		}
+	}
+	return slices.Sorted(maps.Keys(set))
+}
+
+func phaseColumns(phases []string) []string {
+	cols := make([]string, len(phases))
+	for i, phase := range phases {
+		cols[i] = phase + "_hours"
+	}
+	return cols
+}
+
+func phaseHours(phases []string, ms map[string]int64) []string {
+	cols := make([]string, len(phases))
+	for i, phase := range phases {
+		cols[i] = strconv.FormatFloat(float64(ms[phase])/float64(time.Hour/time.Millisecond), 'f', 4, 64)
+	}
+	return cols
+}
+
+func formatCost(cost float64) string {
+	return strconv.FormatFloat(cost, 'f', 4, 64)
+}
+
+// sort orders lines by instance ID.
+func (r *Report) sort() {
+	slices.SortFunc(r.Lines, func(a, b Line) int { return strings.Compare(a.InstanceID, b.InstanceID) })
+}
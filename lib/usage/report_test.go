@@ -0,0 +1,70 @@
+package usage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReport() *Report {
+	hour := int64(time.Hour / time.Millisecond)
+	return &Report{
+		PeriodStart: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:   time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+		Lines: []Line{
+			{InstanceID: "a", InstanceName: "web", Tags: map[string]string{"team": "core", "env": "prod"}, Vcpus: 2, MemoryBytes: 2 << 30, PhaseMs: map[string]int64{"running": 10 * hour, "standby": 5 * hour}},
+			{InstanceID: "b", InstanceName: "api", Tags: map[string]string{"team": "core", "env": "dev"}, Vcpus: 1, MemoryBytes: 1 << 30, PhaseMs: map[string]int64{"running": 2 * hour}, Deleted: true},
+			{InstanceID: "c", InstanceName: "etl", Vcpus: 4, MemoryBytes: 4 << 30, PhaseMs: map[string]int64{"stopped": 100 * hour}},
+		},
+	}
+}
+
+var testRates = RateCard{Currency: "USD", Phases: map[string]PhaseRate{
+	"running": {PerHour: 0.01, PerVCPUHour: 0.02, PerGBHour: 0.005},
+	"standby": {PerGBHour: 0.001},
+}}
+
+// TestReport_Reprice tests pricing by phase, vCPU and memory
+func TestReport_Reprice(t *testing.T) {
+	r := testReport().Reprice(testRates)
+	assert.Equal(t, "USD", r.Currency)
+	assert.InDelta(t, 10*(0.01+0.04+0.01)+5*0.002, r.Lines[0].Cost, 1e-9)
+	assert.InDelta(t, 2*(0.01+0.02+0.005), r.Lines[1].Cost, 1e-9)
+	assert.Zero(t, r.Lines[2].Cost, "unpriced phases are free")
+	assert.InDelta(t, 0.61+0.07, r.Total(), 1e-9)
+}
+
+// TestReport_GroupBy tests grouping by tags and CSV and JSON output
+func TestReport_GroupBy(t *testing.T) {
+	r := testReport().Reprice(testRates)
+
+	g := r.GroupBy("team")
+	require.Len(t, g.Groups, 2)
+	assert.Equal(t, map[string]string{"team": ""}, g.Groups[0].Tags)
+	assert.Equal(t, 2, g.Groups[1].Instances)
+	assert.Equal(t, int64(12*time.Hour/time.Millisecond), g.Groups[1].PhaseMs["running"])
+
+	var csv strings.Builder
+	require.NoError(t, g.WriteCSV(&csv))
+	assert.Equal(t, "team,instances,running_hours,standby_hours,stopped_hours,cost\n"+
+		",1,0.0000,0.0000,100.0000,0.0000\n"+
+		"core,2,12.0000,5.0000,0.0000,0.6800\n", csv.String())
+
+	assert.Len(t, r.GroupBy().Groups, 1)
+	assert.Len(t, r.GroupBy("team", "env").Groups, 3)
+
+	csv.Reset()
+	require.NoError(t, r.WriteCSV(&csv))
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "instance_id,instance_name,deleted,vcpus,memory_bytes,running_hours,standby_hours,stopped_hours,cost", lines[0])
+	assert.Equal(t, "b,api,true,1,1073741824,2.0000,0.0000,0.0000,0.0700", lines[2])
+
+	var js strings.Builder
+	require.NoError(t, g.WriteJSON(&js))
+	assert.Contains(t, js.String(), `"currency": "USD"`)
+	assert.Contains(t, js.String(), `"keys": [`)
+}
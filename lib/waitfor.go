@@ -0,0 +1,165 @@
+// Package lib provides manually-maintained functionality that extends the auto-generated SDK.
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+)
+
+const (
+	// maxWaitCallTimeout is the server's cap on a single Wait call
+	maxWaitCallTimeout = 5 * time.Minute
+	// defaultMultiStateWaitCallTimeout bounds each Wait call when several states
+	// are acceptable, since the server only watches for the first of them
+	defaultMultiStateWaitCallTimeout = 15 * time.Second
+	// defaultWaitPollInterval is the minimum time between Wait calls
+	defaultWaitPollInterval = time.Second
+)
+
+var (
+	// ErrInstanceExited is matched by a WaitError when the instance stopped with a
+	// non-zero exit code before reaching a target state
+	ErrInstanceExited = errors.New("instance exited")
+	// ErrInstanceStateUnknown is matched by a WaitError when the server could not
+	// determine the instance's state
+	ErrInstanceStateUnknown = errors.New("instance state unknown")
+)
+
+// WaitForOptions configures WaitFor
+type WaitForOptions struct {
+	// Optional: server-side timeout of each Wait call. Defaults to the 5 minute
+	// cap for a single target state, and 15 seconds for several, since only the
+	// first state is watched by the server and the others are checked between calls.
+	CallTimeout time.Duration
+	// Optional: minimum time between Wait calls, so a server that returns early
+	// is not polled in a busy loop (defaults to 1s)
+	PollInterval time.Duration
+}
+
+// WaitError is returned by WaitFor when the instance did not reach a target state.
+type WaitError struct {
+	InstanceID string
+	States     []hypeman.InstanceState // Target states that were waited for
+	Instance   *hypeman.Instance       // Last observed instance, nil if it was never fetched
+	Err        error                   // Why waiting stopped
+}
+
+func (e *WaitError) Error() string {
+	states := make([]string, len(e.States))
+	for i, state := range e.States {
+		states[i] = string(state)
+	}
+	msg := fmt.Sprintf("wait for instance %s to be %s", e.InstanceID, strings.Join(states, " or "))
+	if e.Instance != nil {
+		msg += fmt.Sprintf(" (last state %s)", e.Instance.State)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *WaitError) Unwrap() error {
+	return e.Err
+}
+
+// WaitFor waits until the instance is in one of states, for as long as ctx
+// allows. It loops Wait calls, so unlike InstanceService.Wait it is not limited
+// to 5 minutes. It fails fast with a *WaitError if the instance stops with a
+// non-zero exit code (an *ExitError matching ErrInstanceExited) or its state is
+// Unknown with a state error (ErrInstanceStateUnknown), unless that state is
+// itself a target. All failures, including ctx ending, are returned as a
+// *WaitError carrying the last observed Instance.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(ctx, 20*time.Minute)
+//	defer cancel()
+//	inst, err := lib.WaitFor(ctx, &client.Instances, "inst_123",
+//	    []hypeman.InstanceState{hypeman.InstanceStateRunning}, lib.WaitForOptions{})
+//	var waitErr *lib.WaitError
+//	if errors.As(err, &waitErr) && waitErr.Instance != nil {
+//	    fmt.Println(waitErr.Instance.ExitMessage)
+//	}
+func WaitFor(ctx context.Context, instances hypeman.InstancesAPI, id string, states []hypeman.InstanceState, opts WaitForOptions) (*hypeman.Instance, error) {
+	if len(states) == 0 {
+		return nil, fmt.Errorf("no target states given")
+	}
+
+	callTimeout := opts.CallTimeout
+	if callTimeout <= 0 {
+		callTimeout = maxWaitCallTimeout
+		if len(states) > 1 {
+			callTimeout = defaultMultiStateWaitCallTimeout
+		}
+	}
+	callTimeout = min(callTimeout, maxWaitCallTimeout)
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultWaitPollInterval
+	}
+
+	waitErr := &WaitError{InstanceID: id, States: states}
+	fail := func(err error) (*hypeman.Instance, error) {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		waitErr.Err = err
+		return nil, waitErr
+	}
+
+	for {
+		inst, err := instances.Get(ctx, id)
+		if err != nil {
+			return fail(fmt.Errorf("get instance: %w", err))
+		}
+		waitErr.Instance = inst
+
+		if slices.Contains(states, inst.State) {
+			return inst, nil
+		}
+		if err := instanceFailure(inst); err != nil {
+			return fail(err)
+		}
+
+		timeout := callTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = min(timeout, time.Until(deadline))
+		}
+		timeout = max(timeout.Round(time.Second), time.Second)
+
+		next := time.Now().Add(pollInterval)
+		_, err = instances.Wait(ctx, id, hypeman.InstanceWaitParams{
+			State:   hypeman.InstanceWaitParamsState(states[0]),
+			Timeout: hypeman.String(timeout.String()),
+		})
+		if err != nil {
+			return fail(fmt.Errorf("wait: %w", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return fail(ctx.Err())
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// instanceFailure reports why an instance can no longer reach a running state,
+// or nil if it still might.
+func instanceFailure(inst *hypeman.Instance) error {
+	switch inst.State {
+	case hypeman.InstanceStateStopped:
+		if inst.JSON.ExitCode.Valid() && inst.ExitCode != 0 {
+			return DiagnoseExit(inst).Err()
+		}
+	case hypeman.InstanceStateUnknown:
+		if inst.StateError != "" {
+			return fmt.Errorf("%w: %s", ErrInstanceStateUnknown, inst.StateError)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib/hypemanmock"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newWaitMock returns a mock whose Get returns the instances in script, as
+// JSON, advancing one step per Wait call; the last one repeats
+func newWaitMock(script ...string) *hypemanmock.Instances {
+	m := hypemanmock.New()
+	var mu sync.Mutex
+	step := 0
+	m.Instances.GetFunc = func(ctx context.Context, id string, opts ...option.RequestOption) (*hypeman.Instance, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return hypemanmock.FromJSON[*hypeman.Instance](script[min(step, len(script)-1)]), nil
+	}
+	m.Instances.WaitFunc = func(ctx context.Context, id string, params hypeman.InstanceWaitParams, opts ...option.RequestOption) (*hypeman.WaitForStateResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		step++
+		return &hypeman.WaitForStateResponse{State: hypeman.WaitForStateResponseStateInitializing, TimedOut: true}, nil
+	}
+	return m.Instances
+}
+
+// waitTimeouts returns the timeout of each Wait call made to m
+func waitTimeouts(m *hypemanmock.Instances) []string {
+	var timeouts []string
+	for _, call := range m.CallsTo("Wait") {
+		timeouts = append(timeouts, call.Args[1].(hypeman.InstanceWaitParams).Timeout.Value)
+	}
+	return timeouts
+}
+
+// TestWaitFor_LoopsUntilState tests that Wait is called repeatedly until a target state
+func TestWaitFor_LoopsUntilState(t *testing.T) {
+	m := newWaitMock(
+		`{"id":"i1","state":"Created"}`,
+		`{"id":"i1","state":"Initializing"}`,
+		`{"id":"i1","state":"Running"}`,
+	)
+
+	inst, err := WaitFor(t.Context(), m, "i1", []hypeman.InstanceState{hypeman.InstanceStateRunning}, WaitForOptions{PollInterval: time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateRunning, inst.State)
+	assert.Equal(t, []string{"5m0s", "5m0s"}, waitTimeouts(m))
+}
+
+// TestWaitFor_DeadlineBoundsCallTimeout tests that each Wait call fits within ctx
+func TestWaitFor_DeadlineBoundsCallTimeout(t *testing.T) {
+	m := newWaitMock(`{"id":"i1","state":"Initializing"}`, `{"id":"i1","state":"Standby"}`)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 90*time.Second)
+	defer cancel()
+	states := []hypeman.InstanceState{hypeman.InstanceStateRunning, hypeman.InstanceStateStandby}
+	inst, err := WaitFor(ctx, m, "i1", states, WaitForOptions{
+		CallTimeout:  10 * time.Minute,
+		PollInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateStandby, inst.State)
+	assert.Equal(t, []string{"1m30s"}, waitTimeouts(m))
+}
+
+// TestWaitFor_FailsFast tests that terminal states end the wait with a WaitError
+func TestWaitFor_FailsFast(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		target error
+	}{
+		{"exited", `{"id":"i1","state":"Stopped","exit_code":137,"exit_message":"killed by signal 9 (SIGKILL) - OOM"}`, ErrInstanceExited},
+		{"oom", `{"id":"i1","state":"Stopped","exit_code":137,"exit_message":"killed by signal 9 (SIGKILL) - OOM"}`, ErrOOMKilled},
+		{"unknown", `{"id":"i1","state":"Unknown","state_error":"hypervisor socket missing"}`, ErrInstanceStateUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newWaitMock(`{"id":"i1","state":"Initializing"}`, tt.body)
+
+			inst, err := WaitFor(t.Context(), m, "i1", []hypeman.InstanceState{hypeman.InstanceStateRunning}, WaitForOptions{PollInterval: time.Millisecond})
+			require.Error(t, err)
+			assert.Nil(t, inst)
+			assert.ErrorIs(t, err, tt.target)
+
+			var waitErr *WaitError
+			require.True(t, errors.As(err, &waitErr))
+			require.NotNil(t, waitErr.Instance)
+			assert.Equal(t, "i1", waitErr.Instance.ID)
+			assert.Len(t, waitTimeouts(m), 1)
+		})
+	}
+}
+
+// TestWaitFor_StoppedCleanly tests that a clean exit is not treated as a failure
+func TestWaitFor_StoppedCleanly(t *testing.T) {
+	m := newWaitMock(`{"id":"i1","state":"Stopped","exit_code":0}`)
+
+	inst, err := WaitFor(t.Context(), m, "i1", []hypeman.InstanceState{hypeman.InstanceStateStopped}, WaitForOptions{PollInterval: time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, hypeman.InstanceStateStopped, inst.State)
+}
+
+// TestWaitFor_ContextDone tests that ctx ending yields a WaitError with the last instance
+func TestWaitFor_ContextDone(t *testing.T) {
+	m := newWaitMock(`{"id":"i1","state":"Initializing"}`)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	_, err := WaitFor(ctx, m, "i1", []hypeman.InstanceState{hypeman.InstanceStateRunning}, WaitForOptions{PollInterval: time.Millisecond})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	var waitErr *WaitError
+	require.True(t, errors.As(err, &waitErr))
+	require.NotNil(t, waitErr.Instance)
+	assert.Equal(t, hypeman.InstanceStateInitializing, waitErr.Instance.State)
+}
@@ -0,0 +1,275 @@
+// Package watch provides a client-side informer for instances: it polls
+// Instances.List, keeps an indexed in-memory cache, and reports changes as
+// Added, Updated and Deleted events.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+)
+
+// defaultInterval is how often instances are listed by default
+const defaultInterval = 5 * time.Second
+
+// EventType describes how an instance changed
+type EventType string
+
+const (
+	Added   EventType = "Added"
+	Updated EventType = "Updated"
+	Deleted EventType = "Deleted"
+)
+
+// Event reports a change to a watched instance
+type Event struct {
+	Type EventType
+	// Instance is the new state, or the last known state for Deleted
+	Instance hypeman.Instance
+	// Old is the previous state for Updated, and nil otherwise
+	Old *hypeman.Instance
+}
+
+// Options configures an Informer
+type Options struct {
+	// Optional: only watch instances matching these tags and state. An instance
+	// that stops matching, e.g. by leaving the state, is reported as Deleted.
+	Params hypeman.InstanceListParams
+	// Optional: how often to list instances (defaults to 5s)
+	Interval time.Duration
+	// Optional: how often to re-deliver every cached instance as an Updated event
+	// with an unchanged Old, so handlers can reconcile missed work (0 disables)
+	ResyncInterval time.Duration
+	// Optional: tag keys to index for ByTag lookups
+	IndexTags []string
+	// Optional: called in order, from the goroutine running Run, for every event
+	OnEvent func(event Event)
+	// Optional: called when listing fails; the cache is kept and polling continues
+	OnError func(err error)
+}
+
+// Informer watches instances and caches their latest known state. It is safe
+// to query the cache from any goroutine while Run is in progress.
+type Informer struct {
+	instances hypeman.InstancesAPI
+	opts      Options
+
+	mu      sync.RWMutex
+	cache   map[string]hypeman.Instance
+	indexes map[string]map[string]map[string]struct{} // tag key -> value -> instance IDs
+
+	syncOnce sync.Once
+	synced   chan struct{}
+}
+
+// NewInformer returns an Informer for the instances service. Call Run to start it.
+//
+// Example:
+//
+//	inf := watch.NewInformer(&client.Instances, watch.Options{
+//	    Params:    hypeman.InstanceListParams{Tags: map[string]string{"team": "backend"}},
+//	    IndexTags: []string{"env"},
+//	    OnEvent: func(e watch.Event) {
+//	        fmt.Println(e.Type, e.Instance.Name, e.Instance.State)
+//	    },
+//	})
+//	go inf.Run(ctx)
+func NewInformer(instances hypeman.InstancesAPI, opts Options) *Informer {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+	indexes := make(map[string]map[string]map[string]struct{}, len(opts.IndexTags))
+	for _, key := range opts.IndexTags {
+		indexes[key] = map[string]map[string]struct{}{}
+	}
+	return &Informer{
+		instances: instances,
+		opts:      opts,
+		cache:     map[string]hypeman.Instance{},
+		indexes:   indexes,
+		synced:    make(chan struct{}),
+	}
+}
+
+// Run lists instances every Interval and delivers events until ctx is
+// cancelled, then returns nil. Run must only be called once.
+func (inf *Informer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(inf.opts.Interval)
+	defer ticker.Stop()
+
+	var resync <-chan time.Time
+	if inf.opts.ResyncInterval > 0 {
+		resyncTicker := time.NewTicker(inf.opts.ResyncInterval)
+		defer resyncTicker.Stop()
+		resync = resyncTicker.C
+	}
+
+	inf.poll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			inf.poll(ctx)
+		case <-resync:
+			inf.resync()
+		}
+	}
+}
+
+// Synced returns a channel that is closed once the cache has been filled by
+// the first successful list.
+func (inf *Informer) Synced() <-chan struct{} {
+	return inf.synced
+}
+
+// Get returns the cached instance with the given ID.
+func (inf *Informer) Get(id string) (hypeman.Instance, bool) {
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+	inst, ok := inf.cache[id]
+	return inst, ok
+}
+
+// List returns every cached instance, ordered by ID.
+func (inf *Informer) List() []hypeman.Instance {
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+	return inf.sorted(slices.Collect(maps.Keys(inf.cache)))
+}
+
+// ByTag returns the cached instances whose tag key has the given value, ordered
+// by ID. The key must be one of Options.IndexTags.
+func (inf *Informer) ByTag(key, value string) ([]hypeman.Instance, error) {
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+	index, ok := inf.indexes[key]
+	if !ok {
+		return nil, fmt.Errorf("tag %q is not indexed", key)
+	}
+	return inf.sorted(slices.Collect(maps.Keys(index[value]))), nil
+}
+
+// sorted returns the cached instances with ids, ordered by ID. inf.mu must be held.
+func (inf *Informer) sorted(ids []string) []hypeman.Instance {
+	slices.Sort(ids)
+	out := make([]hypeman.Instance, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, inf.cache[id])
+	}
+	return out
+}
+
+// poll lists instances once, reporting failures to OnError.
+func (inf *Informer) poll(ctx context.Context) {
+	if err := inf.sync(ctx); err != nil && ctx.Err() == nil && inf.opts.OnError != nil {
+		inf.opts.OnError(err)
+	}
+}
+
+// sync lists instances, updates the cache and delivers the resulting events.
+func (inf *Informer) sync(ctx context.Context) error {
+	list, err := inf.instances.List(ctx, inf.opts.Params)
+	if err != nil {
+		return fmt.Errorf("list instances: %w", err)
+	}
+	var current []hypeman.Instance
+	if list != nil {
+		current = *list
+	}
+
+	inf.mu.Lock()
+	var events []Event
+	seen := make(map[string]struct{}, len(current))
+	for _, inst := range current {
+		seen[inst.ID] = struct{}{}
+		old, ok := inf.cache[inst.ID]
+		switch {
+		case !ok:
+			events = append(events, Event{Type: Added, Instance: inst})
+		case changed(old, inst):
+			events = append(events, Event{Type: Updated, Instance: inst, Old: &old})
+		}
+		inf.store(inst)
+	}
+	for _, inst := range inf.sorted(slices.Collect(maps.Keys(inf.cache))) {
+		if _, ok := seen[inst.ID]; !ok {
+			events = append(events, Event{Type: Deleted, Instance: inst})
+			inf.remove(inst)
+		}
+	}
+	inf.mu.Unlock()
+
+	inf.syncOnce.Do(func() { close(inf.synced) })
+	inf.deliver(events)
+	return nil
+}
+
+// resync re-delivers every cached instance as an Updated event.
+func (inf *Informer) resync() {
+	inf.mu.RLock()
+	instances := inf.sorted(slices.Collect(maps.Keys(inf.cache)))
+	inf.mu.RUnlock()
+
+	events := make([]Event, len(instances))
+	for i, inst := range instances {
+		events[i] = Event{Type: Updated, Instance: inst, Old: &inst}
+	}
+	inf.deliver(events)
+}
+
+func (inf *Informer) deliver(events []Event) {
+	if inf.opts.OnEvent == nil {
+		return
+	}
+	for _, event := range events {
+		inf.opts.OnEvent(event)
+	}
+}
+
+// store caches inst and updates the indexes. inf.mu must be held.
+func (inf *Informer) store(inst hypeman.Instance) {
+	if old, ok := inf.cache[inst.ID]; ok {
+		inf.remove(old)
+	}
+	inf.cache[inst.ID] = inst
+	for key, index := range inf.indexes {
+		value, ok := inst.Tags[key]
+		if !ok {
+			continue
+		}
+		if index[value] == nil {
+			index[value] = map[string]struct{}{}
+		}
+		index[value][inst.ID] = struct{}{}
+	}
+}
+
+// remove drops inst from the cache and indexes. inf.mu must be held.
+func (inf *Informer) remove(inst hypeman.Instance) {
+	delete(inf.cache, inst.ID)
+	for key, index := range inf.indexes {
+		value, ok := inst.Tags[key]
+		if !ok {
+			continue
+		}
+		delete(index[value], inst.ID)
+		if len(index[value]) == 0 {
+			delete(index, value)
+		}
+	}
+}
+
+// changed reports whether any watched field differs between old and cur.
+func changed(old, cur hypeman.Instance) bool {
+	return old.State != cur.State ||
+		old.CurrentPhase != cur.CurrentPhase ||
+		old.ExitCode != cur.ExitCode ||
+		old.JSON.ExitCode.Valid() != cur.JSON.ExitCode.Valid() ||
+		!maps.Equal(old.Tags, cur.Tags)
+}
@@ -0,0 +1,133 @@
+package watch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kernel/hypeman-go"
+	"github.com/kernel/hypeman-go/lib/hypemanmock"
+	"github.com/kernel/hypeman-go/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestInformer returns an informer listing the instances in the JSON
+// array *list, which the test can replace between syncs
+func newTestInformer(list *string, opts Options) (*Informer, *hypemanmock.Instances, *[]Event) {
+	m := hypemanmock.New()
+	m.Instances.ListFunc = func(ctx context.Context, params hypeman.InstanceListParams, opts ...option.RequestOption) (*[]hypeman.Instance, error) {
+		instances := hypemanmock.FromJSON[[]hypeman.Instance](*list)
+		return &instances, nil
+	}
+
+	var mu sync.Mutex
+	events := &[]Event{}
+	opts.OnEvent = func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		*events = append(*events, e)
+	}
+	return NewInformer(m.Instances, opts), m.Instances, events
+}
+
+func eventSummary(events []Event) []string {
+	var out []string
+	for _, e := range events {
+		out = append(out, string(e.Type)+" "+e.Instance.ID+" "+string(e.Instance.State))
+	}
+	return out
+}
+
+// TestInformer_Sync tests that successive lists produce Added, Updated and Deleted events
+func TestInformer_Sync(t *testing.T) {
+	list := `[
+		{"id":"a","state":"Initializing","tags":{"env":"prod"}},
+		{"id":"b","state":"Running","tags":{"env":"dev"}}
+	]`
+	inf, _, events := newTestInformer(&list, Options{IndexTags: []string{"env"}})
+
+	require.NoError(t, inf.sync(t.Context()))
+	assert.Equal(t, []string{"Added a Initializing", "Added b Running"}, eventSummary(*events))
+
+	// Unwatched fields such as the image do not produce events
+	list = `[
+		{"id":"a","state":"Running","tags":{"env":"prod"}},
+		{"id":"b","state":"Running","image":"new","tags":{"env":"dev"}},
+		{"id":"c","state":"Created","tags":{"env":"prod"}}
+	]`
+	*events = nil
+	require.NoError(t, inf.sync(t.Context()))
+	assert.Equal(t, []string{"Updated a Running", "Added c Created"}, eventSummary(*events))
+	assert.Equal(t, hypeman.InstanceStateInitializing, (*events)[0].Old.State)
+
+	prod, err := inf.ByTag("env", "prod")
+	require.NoError(t, err)
+	require.Len(t, prod, 2)
+	assert.Equal(t, "a", prod[0].ID)
+	assert.Equal(t, "c", prod[1].ID)
+
+	// Tag changes move instances between index entries
+	list = `[{"id":"c","state":"Created","tags":{"env":"dev"}}]`
+	*events = nil
+	require.NoError(t, inf.sync(t.Context()))
+	assert.Equal(t, []string{"Updated c Created", "Deleted a Running", "Deleted b Running"}, eventSummary(*events))
+
+	prod, err = inf.ByTag("env", "prod")
+	require.NoError(t, err)
+	assert.Empty(t, prod)
+	dev, err := inf.ByTag("env", "dev")
+	require.NoError(t, err)
+	require.Len(t, dev, 1)
+	assert.Equal(t, "c", dev[0].ID)
+
+	_, ok := inf.Get("a")
+	assert.False(t, ok)
+	assert.Len(t, inf.List(), 1)
+
+	_, err = inf.ByTag("team", "x")
+	assert.Error(t, err)
+}
+
+// TestInformer_ExitCodeChange tests that a newly reported exit code is an update
+func TestInformer_ExitCodeChange(t *testing.T) {
+	list := `[{"id":"a","state":"Stopped","exit_code":null}]`
+	inf, _, events := newTestInformer(&list, Options{})
+	require.NoError(t, inf.sync(t.Context()))
+
+	list = `[{"id":"a","state":"Stopped","exit_code":0}]`
+	*events = nil
+	require.NoError(t, inf.sync(t.Context()))
+	assert.Equal(t, []string{"Updated a Stopped"}, eventSummary(*events))
+}
+
+// TestInformer_Run tests filtering, resync and shutdown via context
+func TestInformer_Run(t *testing.T) {
+	list := `[{"id":"a","state":"Running","tags":{"team":"backend"}}]`
+	inf, mock, events := newTestInformer(&list, Options{
+		Params:         hypeman.InstanceListParams{Tags: map[string]string{"team": "backend"}},
+		Interval:       time.Hour,
+		ResyncInterval: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() { done <- inf.Run(ctx) }()
+
+	select {
+	case <-inf.Synced():
+	case <-time.After(5 * time.Second):
+		t.Fatal("informer did not sync")
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	require.GreaterOrEqual(t, len(*events), 2)
+	assert.Equal(t, Added, (*events)[0].Type)
+	resync := (*events)[1]
+	assert.Equal(t, Updated, resync.Type)
+	assert.Equal(t, resync.Instance.ID, resync.Old.ID)
+	mock.AssertCalled(t, "List", hypeman.InstanceListParams{Tags: map[string]string{"team": "backend"}})
+}
@@ -3,7 +3,12 @@ package lib
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"path"
+	"strings"
 
 	"github.com/gorilla/websocket"
 )
@@ -36,3 +41,58 @@ func (d *DefaultDialer) DialContext(ctx context.Context, url string, headers htt
 // Ensure gorilla websocket.Conn implements WsConn
 var _ WsConn = (*websocket.Conn)(nil)
 
+// dialInstance opens a WebSocket to a per-instance endpoint (e.g. "exec") using
+// the API key from cfg. A nil dialer falls back to DefaultDialer.
+func dialInstance(ctx context.Context, cfg CpConfig, dialer WsDialer, instanceID, endpoint string) (WsConn, error) {
+	wsURL, err := buildInstanceWsURL(cfg.BaseURL, instanceID, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("build ws url: %w", err)
+	}
+
+	headers := http.Header{}
+	headers.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.APIKey))
+
+	if dialer == nil {
+		dialer = &DefaultDialer{}
+	}
+
+	ws, resp, err := dialer.DialContext(ctx, wsURL, headers)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return nil, fmt.Errorf("websocket connect failed (HTTP %d): %s", resp.StatusCode, string(body))
+		}
+		return nil, fmt.Errorf("websocket connect failed: %w", err)
+	}
+	return ws, nil
+}
+
+// buildInstanceWsURL builds the WebSocket URL for a per-instance endpoint such as cp or exec
+func buildInstanceWsURL(baseURL, instanceID, endpoint string) (string, error) {
+	// Validate instanceID to prevent path traversal attacks
+	if instanceID == "" {
+		return "", fmt.Errorf("instance ID cannot be empty")
+	}
+	if strings.Contains(instanceID, "/") || strings.Contains(instanceID, "\\") || strings.Contains(instanceID, "..") {
+		return "", fmt.Errorf("invalid instance ID: contains path separator or traversal sequence")
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	// Append to existing path (preserves any path prefix like /api)
+	// Use path.Join to handle trailing slashes and ensure clean paths
+	u.Path = path.Join(u.Path, "instances", instanceID, endpoint)
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+
+	return u.String(), nil
+}
@@ -0,0 +1,131 @@
+package hypeman
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+
+	"github.com/kernel/hypeman-go/option"
+)
+
+// iterList runs a list request through list with the raw response captured,
+// and yields the elements of its JSON array body as they are decoded. The
+// response body is closed when iteration ends, including when the caller
+// breaks out early. An error ends iteration and is yielded once.
+func iterList[T any](list func(opts ...option.RequestOption) error, opts []option.RequestOption) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		var raw *http.Response
+		opts = append(opts[:len(opts):len(opts)], option.WithResponseBodyInto(&raw))
+		if err := list(opts...); err != nil {
+			yield(zero, err)
+			return
+		}
+		defer raw.Body.Close()
+
+		dec := json.NewDecoder(raw.Body)
+		tok, err := dec.Token()
+		if err != nil {
+			yield(zero, fmt.Errorf("error parsing response json: %w", err))
+			return
+		}
+		if tok == nil {
+			return // null
+		}
+		if tok != json.Delim('[') {
+			yield(zero, fmt.Errorf("error parsing response json: expected array, got %v", tok))
+			return
+		}
+
+		for dec.More() {
+			var elem json.RawMessage
+			if err := dec.Decode(&elem); err != nil {
+				yield(zero, fmt.Errorf("error parsing response json: %w", err))
+				return
+			}
+			var v T
+			if err := json.Unmarshal(elem, &v); err != nil {
+				yield(zero, fmt.Errorf("error parsing response json: %w", err))
+				return
+			}
+			if !yield(v, nil) {
+				return
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			yield(zero, fmt.Errorf("error parsing response json: %w", err))
+		}
+	}
+}
+
+// ListIter is like [BuildService.List], but yields builds one at a time as they
+// are decoded, for use with range.
+func (r *BuildService) ListIter(ctx context.Context, query BuildListParams, opts ...option.RequestOption) iter.Seq2[Build, error] {
+	return iterList[Build](func(opts ...option.RequestOption) error {
+		_, err := r.List(ctx, query, opts...)
+		return err
+	}, opts)
+}
+
+// ListIter is like [DeviceService.List], but yields devices one at a time as
+// they are decoded, for use with range.
+func (r *DeviceService) ListIter(ctx context.Context, query DeviceListParams, opts ...option.RequestOption) iter.Seq2[Device, error] {
+	return iterList[Device](func(opts ...option.RequestOption) error {
+		_, err := r.List(ctx, query, opts...)
+		return err
+	}, opts)
+}
+
+// ListIter is like [ImageService.List], but yields images one at a time as they
+// are decoded, for use with range.
+func (r *ImageService) ListIter(ctx context.Context, query ImageListParams, opts ...option.RequestOption) iter.Seq2[Image, error] {
+	return iterList[Image](func(opts ...option.RequestOption) error {
+		_, err := r.List(ctx, query, opts...)
+		return err
+	}, opts)
+}
+
+// ListIter is like [IngressService.List], but yields ingresses one at a time as
+// they are decoded, for use with range.
+func (r *IngressService) ListIter(ctx context.Context, query IngressListParams, opts ...option.RequestOption) iter.Seq2[Ingress, error] {
+	return iterList[Ingress](func(opts ...option.RequestOption) error {
+		_, err := r.List(ctx, query, opts...)
+		return err
+	}, opts)
+}
+
+// ListIter is like [InstanceService.List], but yields instances one at a time
+// as they are decoded, for use with range:
+//
+//	for inst, err := range client.Instances.ListIter(ctx, hypeman.InstanceListParams{}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(inst.Name)
+//	}
+func (r *InstanceService) ListIter(ctx context.Context, query InstanceListParams, opts ...option.RequestOption) iter.Seq2[Instance, error] {
+	return iterList[Instance](func(opts ...option.RequestOption) error {
+		_, err := r.List(ctx, query, opts...)
+		return err
+	}, opts)
+}
+
+// ListIter is like [SnapshotService.List], but yields snapshots one at a time
+// as they are decoded, for use with range.
+func (r *SnapshotService) ListIter(ctx context.Context, query SnapshotListParams, opts ...option.RequestOption) iter.Seq2[Snapshot, error] {
+	return iterList[Snapshot](func(opts ...option.RequestOption) error {
+		_, err := r.List(ctx, query, opts...)
+		return err
+	}, opts)
+}
+
+// ListIter is like [VolumeService.List], but yields volumes one at a time as
+// they are decoded, for use with range.
+func (r *VolumeService) ListIter(ctx context.Context, query VolumeListParams, opts ...option.RequestOption) iter.Seq2[Volume, error] {
+	return iterList[Volume](func(opts ...option.RequestOption) error {
+		_, err := r.List(ctx, query, opts...)
+		return err
+	}, opts)
+}
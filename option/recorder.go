@@ -0,0 +1,534 @@
+package option
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// RecorderMode selects whether [WithRecorder] records requests or replays them.
+type RecorderMode int
+
+const (
+	// RecorderModeAuto replays the cassette if it exists, and records a new
+	// one otherwise.
+	RecorderModeAuto RecorderMode = iota
+	// RecorderModeReplay replays the cassette and never sends requests. A
+	// request that matches no recorded interaction fails with
+	// [ErrNoRecordedInteraction].
+	RecorderModeReplay
+	// RecorderModeRecord sends requests and records them, replacing the
+	// cassette.
+	RecorderModeRecord
+)
+
+// ErrNoRecordedInteraction is matched by the error of a request replayed
+// from a cassette that has no unused interaction matching it.
+var ErrNoRecordedInteraction = errors.New("no recorded interaction matches the request")
+
+// RecorderMatcher reports whether req matches recorded, a request from a
+// cassette. Both bodies have been read into memory, except that bodies larger
+// than 1 MiB are not kept and are left empty; [MatchBody] compares those by
+// SHA-256.
+type RecorderMatcher func(req, recorded *http.Request) bool
+
+// MatchMethod matches requests with the same method.
+func MatchMethod(req, recorded *http.Request) bool {
+	return req.Method == recorded.Method
+}
+
+// MatchPath matches requests with the same URL path. The host is not
+// compared, so a cassette recorded against one server replays against any.
+func MatchPath(req, recorded *http.Request) bool {
+	return req.URL.Path == recorded.URL.Path
+}
+
+// MatchQuery matches requests with the same query parameters, regardless of
+// their order.
+func MatchQuery(req, recorded *http.Request) bool {
+	return normalizeQuery(req.URL) == normalizeQuery(recorded.URL)
+}
+
+// MatchBody matches requests with the same body. Bodies larger than 1 MiB,
+// which are not kept in cassettes, match if their sizes and SHA-256 digests
+// are the same.
+func MatchBody(req, recorded *http.Request) bool {
+	a, aok := req.Context().Value(bodySummaryKey{}).(*bodySummary)
+	b, bok := recorded.Context().Value(bodySummaryKey{}).(*bodySummary)
+	if !aok || !bok || a.truncated != b.truncated {
+		return false
+	}
+	if a.truncated {
+		return a.size == b.size && a.sha256 == b.sha256
+	}
+	return bytes.Equal(a.data, b.data)
+}
+
+func normalizeQuery(u *url.URL) string {
+	q := u.Query()
+	for _, values := range q {
+		slices.Sort(values)
+	}
+	return q.Encode()
+}
+
+// credentialEnvMarkers are substrings of the names of environment variables
+// whose values are scrubbed from cassettes.
+var credentialEnvMarkers = []string{"API_KEY", "TOKEN", "SECRET", "PASSWORD"}
+
+// minScrubLength is the shortest environment value that is scrubbed, so that
+// placeholders like "1" or "true" do not erase unrelated text.
+const minScrubLength = 8
+
+const redacted = "[REDACTED]"
+
+// maxRecordedBody is the largest request body kept in a cassette. Larger
+// bodies, such as build contexts, are streamed through when recording and
+// only their size and SHA-256 are kept.
+const maxRecordedBody = 1 << 20
+
+// WithRecorder returns a RequestOption that records requests and their
+// responses to a cassette file at path, or replays them from it, according to
+// mode. Replaying makes tests deterministic and runnable with no network.
+//
+// A replayed request is answered by the first interaction not yet replayed
+// whose request matches by every one of matchers, which default to
+// [MatchMethod], [MatchPath] and [MatchQuery]. Interactions are replayed in
+// the order they were recorded, so repeated polls of the same URL replay the
+// recorded sequence of responses.
+//
+// Response bodies are recorded as they are read, so Server-Sent Event streams
+// are recorded up to where the caller stopped reading and closed them. An
+// interaction is written to the cassette when its response body is closed or
+// read to the end. Request bodies are hashed as they are sent rather than
+// buffered, and those larger than 1 MiB are recorded by size and SHA-256
+// only. Sensitive headers such as Authorization are redacted, and the values
+// of environment variables whose names contain API_KEY, TOKEN, SECRET or
+// PASSWORD are scrubbed from URLs, headers and the bodies that are kept.
+//
+// Options that share a cassette should be created once, e.g. when creating a
+// client, not per request.
+func WithRecorder(path string, mode RecorderMode, matchers ...RecorderMatcher) RequestOption {
+	if len(matchers) == 0 {
+		matchers = []RecorderMatcher{MatchMethod, MatchPath, MatchQuery}
+	}
+	r := &recorder{path: path, mode: mode, matchers: matchers}
+	return WithMiddleware(r.middleware)
+}
+
+// cassette is the JSON encoding of a recording.
+type cassette struct {
+	Interactions []*interaction `json:"interactions"`
+}
+
+type interaction struct {
+	Request  recordedRequest   `json:"request"`
+	Response *recordedResponse `json:"response"`
+
+	used bool // replayed already
+}
+
+type recordedRequest struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Header       http.Header `json:"header"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+	// Set instead of Body for bodies larger than maxRecordedBody
+	BodySize   int64  `json:"body_size,omitempty"`
+	BodySHA256 string `json:"body_sha256,omitempty"`
+}
+
+type recordedResponse struct {
+	StatusCode   int         `json:"status_code"`
+	Header       http.Header `json:"header"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+type recorder struct {
+	path     string
+	mode     RecorderMode
+	matchers []RecorderMatcher
+
+	mu        sync.Mutex
+	loaded    bool
+	err       error // from loading the cassette
+	recording bool
+	cassette  cassette
+}
+
+func (r *recorder) middleware(req *http.Request, next MiddlewareNext) (*http.Response, error) {
+	recording, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	if recording {
+		return r.record(req, next)
+	}
+	return r.replay(req)
+}
+
+// load reads the cassette on the first request, and reports whether requests
+// are to be recorded.
+func (r *recorder) load() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.loaded {
+		return r.recording, r.err
+	}
+	r.loaded = true
+
+	r.recording = r.mode == RecorderModeRecord
+	if r.recording {
+		return true, nil
+	}
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) && r.mode == RecorderModeAuto {
+		r.recording = true
+		return true, nil
+	}
+	if err != nil {
+		r.err = fmt.Errorf("reading cassette: %w", err)
+		return false, r.err
+	}
+	if err := json.Unmarshal(data, &r.cassette); err != nil {
+		r.err = fmt.Errorf("parsing cassette %s: %w", r.path, err)
+		return false, r.err
+	}
+	return false, nil
+}
+
+func (r *recorder) replay(req *http.Request) (*http.Response, error) {
+	secrets := credentialValues()
+	tee := newBodyTee(req.Body)
+	if hasBody(req) {
+		_, err := io.Copy(io.Discard, tee)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading request body: %w", err)
+		}
+	}
+	body := tee.summary(secrets)
+	scrubbed := req.Clone(context.WithValue(req.Context(), bodySummaryKey{}, body))
+	scrubbed.URL, _ = url.Parse(scrubString(req.URL.String(), secrets))
+	scrubbed.Body = io.NopCloser(bytes.NewReader(body.data))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, in := range r.cassette.Interactions {
+		if in.used || in.Response == nil {
+			continue
+		}
+		recorded, err := in.Request.httpRequest()
+		if err != nil {
+			return nil, fmt.Errorf("cassette %s: %w", r.path, err)
+		}
+		if !r.matches(scrubbed, recorded) {
+			continue
+		}
+		in.used = true
+		return in.Response.httpResponse(req)
+	}
+	return nil, fmt.Errorf("cassette %s: %s %s: %w", r.path, req.Method, scrubbed.URL, ErrNoRecordedInteraction)
+}
+
+func (r *recorder) matches(req, recorded *http.Request) bool {
+	for _, match := range r.matchers {
+		if !match(req, recorded) {
+			return false
+		}
+	}
+	return true
+}
+
+// record sends req, and adds the interaction to the cassette once its
+// response body has been read. The request body is hashed as it is sent, and
+// the interaction takes its place in the cassette when the request is sent,
+// so that concurrent requests are replayed in the order they were made.
+func (r *recorder) record(req *http.Request, next MiddlewareNext) (*http.Response, error) {
+	secrets := credentialValues()
+	tee := newBodyTee(req.Body)
+	if hasBody(req) {
+		req.Body = tee
+	}
+	in := &interaction{}
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, in)
+	r.mu.Unlock()
+
+	res, err := next(req)
+	if err != nil {
+		return res, err
+	}
+	res.Body = &recordingBody{rc: res.Body, done: func(data []byte) {
+		// The request body has been sent by the time the response is read
+		request := newRecordedRequest(req, tee.summary(secrets), secrets)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		in.Request = request
+		in.Response = newRecordedResponse(res, data, secrets)
+		// A failure to save leaves the cassette incomplete, which replaying
+		// reports as unmatched requests.
+		_ = r.save()
+	}}
+	return res, nil
+}
+
+// save writes the interactions that have a response. r.mu must be held.
+func (r *recorder) save() error {
+	out := cassette{Interactions: []*interaction{}}
+	for _, in := range r.cassette.Interactions {
+		if in.Response != nil {
+			out.Interactions = append(out.Interactions, in)
+		}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// bodySummaryKey is the context key of the *bodySummary of the requests
+// given to a RecorderMatcher.
+type bodySummaryKey struct{}
+
+// bodySummary is what is kept of a request body.
+type bodySummary struct {
+	data      []byte // scrubbed, nil if truncated
+	truncated bool   // larger than maxRecordedBody
+	size      int64
+	sha256    string // of the body as sent, hex encoded
+}
+
+// hasBody reports whether req has a body that the transport reads.
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody
+}
+
+// bodyTee passes a request body through as it is read, hashing it and
+// keeping up to maxRecordedBody bytes of it.
+type bodyTee struct {
+	rc io.ReadCloser
+
+	mu   sync.Mutex // the transport reads the body while summary may be called
+	hash hash.Hash
+	buf  bytes.Buffer
+	size int64
+}
+
+func newBodyTee(rc io.ReadCloser) *bodyTee {
+	return &bodyTee{rc: rc, hash: sha256.New()}
+}
+
+func (t *bodyTee) Read(p []byte) (int, error) {
+	n, err := t.rc.Read(p)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hash.Write(p[:n])
+	t.size += int64(n)
+	if t.size <= maxRecordedBody {
+		t.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (t *bodyTee) Close() error {
+	return t.rc.Close()
+}
+
+// summary describes what has been read of the body.
+func (t *bodyTee) summary(secrets []string) *bodySummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &bodySummary{size: t.size, sha256: hex.EncodeToString(t.hash.Sum(nil))}
+	if t.size > maxRecordedBody {
+		s.truncated = true
+	} else {
+		s.data = scrubBytes(bytes.Clone(t.buf.Bytes()), secrets)
+	}
+	return s
+}
+
+// recordingBody passes a response body through, calling done with what was
+// read once it has been read to the end or closed.
+type recordingBody struct {
+	rc   io.ReadCloser
+	buf  bytes.Buffer
+	once sync.Once
+	done func([]byte)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.buf.Bytes()) })
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.once.Do(func() { b.done(b.buf.Bytes()) })
+	return b.rc.Close()
+}
+
+func newRecordedRequest(req *http.Request, body *bodySummary, secrets []string) recordedRequest {
+	rr := recordedRequest{
+		Method: req.Method,
+		URL:    scrubString(req.URL.String(), secrets),
+		Header: scrubHeader(req.Header, secrets),
+	}
+	if body.truncated {
+		rr.BodySize, rr.BodySHA256 = body.size, body.sha256
+	} else {
+		rr.Body, rr.BodyEncoding = encodeBody(body.data)
+	}
+	return rr
+}
+
+func (rr *recordedRequest) httpRequest() (*http.Request, error) {
+	body, err := decodeBody(rr.Body, rr.BodyEncoding)
+	if err != nil {
+		return nil, err
+	}
+	summary := &bodySummary{data: body, size: int64(len(body))}
+	if rr.BodySHA256 != "" {
+		summary = &bodySummary{truncated: true, size: rr.BodySize, sha256: rr.BodySHA256}
+	}
+	ctx := context.WithValue(context.Background(), bodySummaryKey{}, summary)
+	req, err := http.NewRequestWithContext(ctx, rr.Method, rr.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = rr.Header.Clone()
+	return req, nil
+}
+
+func newRecordedResponse(res *http.Response, body []byte, secrets []string) *recordedResponse {
+	text, encoding := encodeBody(scrubBytes(body, secrets))
+	return &recordedResponse{
+		StatusCode:   res.StatusCode,
+		Header:       scrubHeader(res.Header, secrets),
+		Body:         text,
+		BodyEncoding: encoding,
+	}
+}
+
+func (rr *recordedResponse) httpResponse(req *http.Request) (*http.Response, error) {
+	body, err := decodeBody(rr.Body, rr.BodyEncoding)
+	if err != nil {
+		return nil, err
+	}
+	header := rr.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rr.StatusCode, http.StatusText(rr.StatusCode)),
+		StatusCode:    rr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// encodeBody returns body as text, base64 encoded if it is not UTF-8.
+func encodeBody(body []byte) (text, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func decodeBody(text, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(text), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(text)
+	default:
+		return nil, fmt.Errorf("unknown body encoding %q", encoding)
+	}
+}
+
+// credentialValues returns the values of environment variables that look
+// like credentials, longest first so that no value is partly scrubbed by a
+// shorter one it contains.
+func credentialValues() []string {
+	var values []string
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if len(value) < minScrubLength {
+			continue
+		}
+		for _, marker := range credentialEnvMarkers {
+			if strings.Contains(strings.ToUpper(name), marker) {
+				values = append(values, value)
+				break
+			}
+		}
+	}
+	slices.SortFunc(values, func(a, b string) int { return len(b) - len(a) })
+	return values
+}
+
+func scrubString(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			s = strings.ReplaceAll(s, escaped, redacted)
+		}
+	}
+	return s
+}
+
+func scrubBytes(b []byte, secrets []string) []byte {
+	for _, secret := range secrets {
+		b = bytes.ReplaceAll(b, []byte(secret), []byte(redacted))
+	}
+	return b
+}
+
+// scrubHeader returns a copy of header with sensitive headers redacted and
+// secrets scrubbed from the rest.
+func scrubHeader(header http.Header, secrets []string) http.Header {
+	out := make(http.Header, len(header))
+	for name, values := range header {
+		if slices.Contains(sensitiveLogHeaders, strings.ToLower(name)) {
+			out[name] = []string{redacted}
+			continue
+		}
+		for _, v := range values {
+			out[name] = append(out[name], scrubString(v, secrets))
+		}
+	}
+	return out
+}
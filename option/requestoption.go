@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/kernel/hypeman-go/internal/requestconfig"
+	"github.com/kernel/hypeman-go/packages/ssestream"
 	"github.com/tidwall/sjson"
 )
 
@@ -106,6 +107,49 @@ func WithMaxRetries(retries int) RequestOption {
 	})
 }
 
+// WithIdempotencyKey returns a RequestOption that sends key in the
+// Idempotency-Key header, so that the server acts on a request at most once
+// however many times it is sent. Reuse the key when repeating a request
+// yourself, e.g. after the process restarts. Every retry attempt of a request
+// sends the same key.
+//
+// POST requests without a key are given a random one, unless turned off with
+// [WithAutoIdempotencyKey].
+func WithIdempotencyKey(key string) RequestOption {
+	return WithHeader(requestconfig.IdempotencyKeyHeader, key)
+}
+
+// WithAutoIdempotencyKey returns a RequestOption that sets whether POST
+// requests without an Idempotency-Key header are given a random one, which
+// they are by default. Without a key, a POST request that fails after it may
+// have reached the server is not retried, unless POST is made retry-safe with
+// [WithRetrySafeMethods].
+func WithAutoIdempotencyKey(enabled bool) RequestOption {
+	return requestconfig.RequestOptionFunc(func(r *requestconfig.RequestConfig) error {
+		r.NoAutoIdempotencyKey = !enabled
+		return nil
+	})
+}
+
+// WithRetrySafeMethods returns a RequestOption that sets the HTTP methods that
+// are retried after failures the server may have acted on: connection errors,
+// timeouts and 5xx responses. Requests with other methods are retried after
+// such failures only if they have an idempotency key, and are otherwise only
+// retried when the server turned them away, with a 429 response or an
+// x-should-retry header.
+//
+// By default GET, HEAD, OPTIONS, PUT, PATCH and DELETE are retry-safe, and
+// POST requests are made retry-safe by the Idempotency-Key each is given.
+func WithRetrySafeMethods(methods ...string) RequestOption {
+	if methods == nil {
+		methods = []string{}
+	}
+	return requestconfig.RequestOptionFunc(func(r *requestconfig.RequestConfig) error {
+		r.RetrySafeMethods = methods
+		return nil
+	})
+}
+
 // WithHeader returns a RequestOption that sets the header value to the associated key. It overwrites
 // any value if there was one already present.
 func WithHeader(key, value string) RequestOption {
@@ -249,6 +293,30 @@ func WithRequestBody(contentType string, body any) RequestOption {
 	})
 }
 
+// WithUploadProgress returns a RequestOption that calls fn with the number of
+// request body bytes sent so far. This is most useful for large uploads such as
+// build sources and volume archives, which are streamed rather than buffered.
+// The count restarts from zero if the request is retried.
+func WithUploadProgress(fn func(bytesSent int64)) RequestOption {
+	return requestconfig.RequestOptionFunc(func(r *requestconfig.RequestConfig) error {
+		r.UploadProgress = fn
+		return nil
+	})
+}
+
+// WithStreamReconnect returns a RequestOption that resumes Server-Sent Event
+// streams, such as LogsStreaming and EventsStreaming, when their connection
+// drops. The request is repeated with a Last-Event-ID header after the delay
+// the server asked for with "retry:", or opts.Delay otherwise. Streams that
+// cannot be resumed end with a [*ssestream.GapError] rather than stopping
+// silently. A stream the server closes cleanly is not reopened.
+func WithStreamReconnect(opts ssestream.ReconnectOptions) RequestOption {
+	return requestconfig.RequestOptionFunc(func(r *requestconfig.RequestConfig) error {
+		r.StreamReconnect = &opts
+		return nil
+	})
+}
+
 // WithRequestTimeout returns a RequestOption that sets the timeout for
 // each request attempt. This should be smaller than the timeout defined in
 // the context, which spans all retries.
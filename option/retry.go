@@ -0,0 +1,102 @@
+package option
+
+import (
+	"time"
+
+	"github.com/kernel/hypeman-go/internal/requestconfig"
+)
+
+// RetryPolicy decides whether a failed request attempt is retried, and how
+// long to wait first. It is only asked about attempts that may be retried at
+// all: fewer retries than [WithMaxRetries] allows have been made, the request
+// body can be sent again, and retrying is safe (see [WithRetrySafeMethods]).
+type RetryPolicy = requestconfig.RetryPolicy
+
+// RetryAttempt describes a failed request attempt, for a [RetryPolicy] and
+// [WithOnRetry].
+type RetryAttempt = requestconfig.RetryAttempt
+
+// Backoff is a [RetryPolicy] with exponential backoff, jitter, rules for
+// particular statuses and errors, and an optional [RetryBudget]. Its zero
+// value is the default policy: it retries connection errors, 408, 409, 429
+// and 5xx responses after 0.5s, 1s, 2s and so on up to 8s, less up to a
+// quarter, or after the delay the server asks for with Retry-After.
+type Backoff = requestconfig.Backoff
+
+// Jitter is how [Backoff] randomizes its delays, so that clients that failed
+// together do not retry together.
+type Jitter = requestconfig.Jitter
+
+const (
+	// JitterDefault takes up to a quarter off each delay.
+	JitterDefault = requestconfig.JitterDefault
+	// JitterNone uses the exponential delays as they are.
+	JitterNone = requestconfig.JitterNone
+	// JitterFull picks each delay at random between 0 and the exponential
+	// delay.
+	JitterFull = requestconfig.JitterFull
+	// JitterDecorrelated picks each delay at random between Base and three
+	// times the previous delay, up to Max.
+	JitterDecorrelated = requestconfig.JitterDecorrelated
+)
+
+// RetryRule decides whether [Backoff] retries an attempt, if it applies to
+// it. ok is false if the rule does not apply.
+type RetryRule = requestconfig.RetryRule
+
+// RetryOnStatus returns a rule that retries responses with any of statuses.
+func RetryOnStatus(statuses ...int) RetryRule {
+	return requestconfig.RetryOnStatus(statuses...)
+}
+
+// NoRetryOnStatus returns a rule that does not retry responses with any of
+// statuses.
+func NoRetryOnStatus(statuses ...int) RetryRule {
+	return requestconfig.NoRetryOnStatus(statuses...)
+}
+
+// RetryOnError returns a rule that decides whether to retry attempts that
+// failed with an error matching target with errors.Is.
+func RetryOnError(target error, retry bool) RetryRule {
+	return requestconfig.RetryOnError(target, retry)
+}
+
+// RetryBudget is a token bucket that limits how often requests sharing it are
+// retried, so that a server that fails every request, e.g. while restarting,
+// is not met with a retry storm. Each retry takes a token.
+type RetryBudget = requestconfig.RetryBudget
+
+// NewRetryBudget returns a budget of burst retries, refilled at perSecond
+// retries a second. A budget in a policy given to a client is shared by all of
+// the client's requests.
+func NewRetryBudget(burst int, perSecond float64) *RetryBudget {
+	return requestconfig.NewRetryBudget(burst, perSecond)
+}
+
+// WithRetryPolicy returns a RequestOption that sets the policy deciding which
+// failed attempts are retried, and when. [WithMaxRetries] still caps the
+// number of retries.
+//
+//	client := hypeman.NewClient(
+//		option.WithMaxRetries(5),
+//		option.WithRetryPolicy(option.Backoff{
+//			Jitter: option.JitterFull,
+//			Rules:  []option.RetryRule{option.NoRetryOnStatus(http.StatusConflict)},
+//			Budget: option.NewRetryBudget(10, 1),
+//		}),
+//	)
+func WithRetryPolicy(policy RetryPolicy) RequestOption {
+	return requestconfig.RequestOptionFunc(func(r *requestconfig.RequestConfig) error {
+		r.RetryPolicy = policy
+		return nil
+	})
+}
+
+// WithOnRetry returns a RequestOption that calls fn before each retry, with
+// the failed attempt and the delay before the retry, e.g. to log retries.
+func WithOnRetry(fn func(attempt RetryAttempt, delay time.Duration)) RequestOption {
+	return requestconfig.RequestOptionFunc(func(r *requestconfig.RequestConfig) error {
+		r.OnRetry = fn
+		return nil
+	})
+}
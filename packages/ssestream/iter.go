@@ -0,0 +1,28 @@
+package ssestream
+
+import "iter"
+
+// All returns an iterator over the values of the stream, for use with range:
+//
+//	for event, err := range stream.All() {
+//		if err != nil {
+//			...
+//		}
+//	}
+//
+// An error ends iteration and is yielded once, with the zero value. The stream
+// is closed when iteration ends, including when the loop is broken early.
+func (s *Stream[T]) All() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		defer s.Close()
+		for s.Next() {
+			if !yield(s.Current(), nil) {
+				return
+			}
+		}
+		if err := s.Err(); err != nil {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
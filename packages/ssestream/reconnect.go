@@ -0,0 +1,182 @@
+package ssestream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultReconnectAttempts = 5
+	defaultReconnectDelay    = time.Second
+	maxReconnectDelay        = 30 * time.Second
+)
+
+// ReconnectOptions configures how a dropped event stream is resumed.
+type ReconnectOptions struct {
+	// MaxAttempts is the number of consecutive failed reconnects after which the
+	// stream ends with a [*GapError]. Defaults to 5.
+	MaxAttempts int
+	// Delay is how long to wait before the first reconnect when the server has
+	// not sent a retry hint. Consecutive failures double it, up to 30s. Defaults
+	// to 1s.
+	Delay time.Duration
+	// OnGap, if set, is called when the stream is resumed without a last event
+	// ID, so events sent while it was disconnected may have been missed. When
+	// OnGap is nil, such a stream ends with a [*GapError] instead of resuming.
+	OnGap func(gap *GapError)
+}
+
+// GapError reports that a stream was interrupted and events may have been
+// missed, either because it could not be resumed or because it was resumed
+// without a last event ID.
+type GapError struct {
+	// LastEventID is the ID of the last event received before the interruption,
+	// or empty if the server never sent one.
+	LastEventID string
+	// Err is the error that interrupted the stream, or the error of the final
+	// reconnect attempt.
+	Err error
+}
+
+func (e *GapError) Error() string {
+	if e.LastEventID == "" {
+		return fmt.Sprintf("event stream interrupted: %v", e.Err)
+	}
+	return fmt.Sprintf("event stream interrupted after event %q: %v", e.LastEventID, e.Err)
+}
+
+func (e *GapError) Unwrap() error {
+	return e.Err
+}
+
+// ResumableBody is a response body for a stream that can be reopened after
+// its connection drops. [NewDecoder] returns a decoder that reconnects with
+// the Last-Event-ID of the last event received when it sees one.
+type ResumableBody struct {
+	io.ReadCloser
+	// Context governs the waits between reconnect attempts.
+	Context context.Context
+	// Reopen repeats the original request with the given Last-Event-ID.
+	Reopen  func(lastEventID string) (*http.Response, error)
+	Options ReconnectOptions
+}
+
+// reconnectingDecoder decodes a stream across reconnects. Only read errors
+// trigger a reconnect; a stream the server ends cleanly is not reopened.
+type reconnectingDecoder struct {
+	body   *ResumableBody
+	cur    Decoder
+	lastID string
+	retry  time.Duration
+	err    error
+}
+
+func newReconnectingDecoder(res *http.Response, body *ResumableBody) *reconnectingDecoder {
+	if body.Context == nil {
+		body.Context = context.Background()
+	}
+	return &reconnectingDecoder{body: body, cur: newBaseDecoder(res, "")}
+}
+
+func (d *reconnectingDecoder) Next() bool {
+	for d.err == nil {
+		if d.cur.Next() {
+			d.lastID = d.cur.Event().ID
+			return true
+		}
+		d.syncRetry()
+
+		err := d.cur.Err()
+		d.cur.Close()
+		if err == nil || d.body.Context.Err() != nil {
+			d.err = err
+			return false
+		}
+		d.reconnect(err)
+	}
+	return false
+}
+
+// syncRetry records the server's retry hint, if the decoder understands one.
+func (d *reconnectingDecoder) syncRetry() {
+	if esd, ok := d.cur.(*eventStreamDecoder); ok && esd.retry > 0 {
+		d.retry = esd.retry
+	}
+}
+
+// reconnect replaces the current decoder after the stream failed with cause,
+// or sets d.err if the stream cannot be resumed.
+func (d *reconnectingDecoder) reconnect(cause error) {
+	gap := &GapError{LastEventID: d.lastID, Err: cause}
+	if d.lastID == "" && d.body.Options.OnGap == nil {
+		d.err = gap
+		return
+	}
+
+	maxAttempts := d.body.Options.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultReconnectAttempts
+	}
+	delay := d.retry
+	if delay == 0 {
+		delay = d.body.Options.Delay
+	}
+	if delay <= 0 {
+		delay = defaultReconnectDelay
+	}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		select {
+		case <-d.body.Context.Done():
+			d.err = d.body.Context.Err()
+			return
+		case <-time.After(delay):
+		}
+
+		res, err := d.body.Reopen(d.lastID)
+		if err != nil {
+			gap.Err = err
+			// Only the server's hint is honored as-is; our own delay backs off
+			if d.retry == 0 {
+				delay = min(delay*2, maxReconnectDelay)
+			}
+			continue
+		}
+		// 204 No Content tells clients to stop reconnecting
+		if res.StatusCode == http.StatusNoContent {
+			res.Body.Close()
+			d.cur = emptyDecoder{}
+			return
+		}
+
+		if d.lastID == "" {
+			d.body.Options.OnGap(gap)
+		}
+		d.cur = newBaseDecoder(res, d.lastID)
+		return
+	}
+	d.err = gap
+}
+
+func (d *reconnectingDecoder) Event() Event {
+	return d.cur.Event()
+}
+
+func (d *reconnectingDecoder) Close() error {
+	return d.cur.Close()
+}
+
+func (d *reconnectingDecoder) Err() error {
+	return d.err
+}
+
+// emptyDecoder is a finished stream.
+type emptyDecoder struct{}
+
+func (emptyDecoder) Event() Event { return Event{} }
+func (emptyDecoder) Next() bool   { return false }
+func (emptyDecoder) Close() error { return nil }
+func (emptyDecoder) Err() error   { return nil }
@@ -8,7 +8,9 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Decoder interface {
@@ -22,7 +24,15 @@ func NewDecoder(res *http.Response) Decoder {
 	if res == nil || res.Body == nil {
 		return nil
 	}
+	if body, ok := res.Body.(*ResumableBody); ok {
+		return newReconnectingDecoder(res, body)
+	}
+	return newBaseDecoder(res, "")
+}
 
+// newBaseDecoder returns the decoder registered for the response content type,
+// or a text/event-stream decoder whose last event ID starts at lastEventID.
+func newBaseDecoder(res *http.Response, lastEventID string) Decoder {
 	var decoder Decoder
 	contentType := res.Header.Get("content-type")
 	if t, ok := decoderTypes[contentType]; ok {
@@ -30,7 +40,7 @@ func NewDecoder(res *http.Response) Decoder {
 	} else {
 		scn := bufio.NewScanner(res.Body)
 		scn.Buffer(nil, bufio.MaxScanTokenSize<<9)
-		decoder = &eventStreamDecoder{rc: res.Body, scn: scn}
+		decoder = &eventStreamDecoder{rc: res.Body, scn: scn, lastID: lastEventID}
 	}
 	return decoder
 }
@@ -44,14 +54,19 @@ func RegisterDecoder(contentType string, decoder func(io.ReadCloser) Decoder) {
 type Event struct {
 	Type string
 	Data []byte
+	// ID is the last event ID seen on the stream, which persists across events
+	// that do not set their own.
+	ID string
 }
 
 // A base implementation of a Decoder for text/event-stream.
 type eventStreamDecoder struct {
-	evt Event
-	rc  io.ReadCloser
-	scn *bufio.Scanner
-	err error
+	evt    Event
+	rc     io.ReadCloser
+	scn    *bufio.Scanner
+	err    error
+	lastID string
+	retry  time.Duration
 }
 
 func (s *eventStreamDecoder) Next() bool {
@@ -61,15 +76,22 @@ func (s *eventStreamDecoder) Next() bool {
 
 	event := ""
 	data := bytes.NewBuffer(nil)
+	hasData := false
 
 	for s.scn.Scan() {
 		txt := s.scn.Bytes()
 
 		// Dispatch event on an empty line
 		if len(txt) == 0 {
+			// Blocks without data, e.g. a bare "retry:", only update stream state
+			if !hasData {
+				event = ""
+				continue
+			}
 			s.evt = Event{
 				Type: event,
 				Data: data.Bytes(),
+				ID:   s.lastID,
 			}
 			return true
 		}
@@ -88,7 +110,17 @@ func (s *eventStreamDecoder) Next() bool {
 			continue
 		case "event":
 			event = string(value)
+		case "id":
+			// IDs containing NULL are ignored, as in the EventSource spec
+			if bytes.IndexByte(value, 0) < 0 {
+				s.lastID = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(string(value), 10, 63); err == nil {
+				s.retry = time.Duration(ms) * time.Millisecond
+			}
 		case "data":
+			hasData = true
 			_, s.err = data.Write(value)
 			if s.err != nil {
 				break
@@ -194,6 +194,10 @@ type VolumeNewFromArchiveParams struct {
 	paramObj
 }
 
+func (r VolumeNewFromArchiveParams) MarshalMultipartStream() (*apiform.Stream, error) {
+	return apiform.NewStream(r, r.ExtraFields())
+}
+
 func (r VolumeNewFromArchiveParams) MarshalMultipart() (data []byte, contentType string, err error) {
 	buf := bytes.NewBuffer(nil)
 	writer := multipart.NewWriter(buf)